@@ -0,0 +1,692 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// accountsCmd represents the accounts command
+var accountsCmd = &cobra.Command{
+	Use:   "accounts",
+	Short: "Manage the cloud accounts in cloud_accounts.conf",
+}
+
+// accountsListCmd represents the accounts list command
+var accountsListCmd = &cobra.Command{
+	Use:   "list [.yogaya/cloud_accounts.conf-file-path]",
+	Short: "List configured cloud accounts",
+	Run:   accountsListCommand,
+}
+
+// accountsShowCmd represents the accounts show command
+var accountsShowCmd = &cobra.Command{
+	Use:   "show [.yogaya/cloud_accounts.conf-file-path] [account-id-or-alias]",
+	Short: "Show a cloud account with its secrets masked",
+	Run:   accountsShowCommand,
+}
+
+// accountsRemoveCmd represents the accounts remove command
+var accountsRemoveCmd = &cobra.Command{
+	Use:   "remove [.yogaya/cloud_accounts.conf-file-path] [account-id-or-alias]",
+	Short: "Remove a cloud account and its stored credentials",
+	Run:   accountsRemoveCommand,
+}
+
+// accountsRenameCmd represents the accounts rename command
+var accountsRenameCmd = &cobra.Command{
+	Use:     "rename [.yogaya/cloud_accounts.conf-file-path] [account-id-or-alias] [new-alias]",
+	Aliases: []string{"alias"},
+	Short:   "Set the alias of a cloud account",
+	Run:     accountsRenameCommand,
+}
+
+// accountsRevalidateCmd represents the accounts revalidate command
+var accountsRevalidateCmd = &cobra.Command{
+	Use:   "revalidate [.yogaya/cloud_accounts.conf-file-path] [account-id-or-alias]",
+	Short: "Validate the credentials of a cloud account again",
+	Run:   accountsRevalidateCommand,
+}
+
+// accountsRotateCmd represents the accounts rotate command
+var accountsRotateCmd = &cobra.Command{
+	Use:   "rotate [.yogaya/cloud_accounts.conf-file-path] [account-id-or-alias] [new-provider-credentials-file-path]",
+	Short: "Replace the credentials of a cloud account, keeping its ID",
+	Run:   accountsRotateCommand,
+}
+
+// accountsLabelCmd represents the accounts label command
+var accountsLabelCmd = &cobra.Command{
+	Use:   "label [.yogaya/cloud_accounts.conf-file-path] [account-id-or-alias] [key=value|key-]...",
+	Short: "Set or remove labels of a cloud account",
+	Long: "Set or remove labels of a cloud account\n\n" +
+		"  key=value  sets the label\n" +
+		"  key-       removes the label\n",
+	Run: accountsLabelCommand,
+}
+
+var (
+	accountsListOutput   string
+	accountsListSelector string
+)
+
+var rotateOptions AddOptions
+
+var accountsRemoveForce bool
+
+func init() {
+	accountsListCmd.Flags().StringVarP(&accountsListOutput, "output", "o", "table", "output format (table|json)")
+	accountsListCmd.Flags().StringVarP(&accountsListSelector, "selector", "l", "", "only list accounts matching the label selector (e.g. env=prod,provider=aws)")
+
+	accountsRotateCmd.Flags().StringVar(&rotateOptions.AWSProfile, "profile", "", "AWS profile to read from the credentials file (default $AWS_PROFILE or \"default\")")
+	accountsRotateCmd.Flags().StringVar(&rotateOptions.AWSConfigFile, "aws-config-file", "", "AWS config file merged with the profile (default $AWS_CONFIG_FILE or ~/.aws/config)")
+	accountsRotateCmd.Flags().StringVar(&rotateOptions.Region, "region", "", "AWS region used for STS and validation (default: the account's region)")
+	accountsRotateCmd.Flags().StringVar(&rotateOptions.GCPProject, "project", "", "GCP project, required when the credentials do not name one")
+	accountsRotateCmd.Flags().BoolVar(&rotateOptions.AllowWrite, "allow-write", false, "accept new credentials that can modify infrastructure")
+	accountsRemoveCmd.Flags().BoolVar(&accountsRemoveForce, "force", false, "also remove the accounts and discoveries that use the credentials of the account")
+
+	accountsRotateCmd.Flags().StringVar(&rotateOptions.AzureSubscription, "subscription", "", "Azure subscription, required when the service principal file does not name one")
+
+	for _, c := range []*cobra.Command{accountsShowCmd, accountsRemoveCmd, accountsRenameCmd, accountsRevalidateCmd, accountsRotateCmd, accountsLabelCmd} {
+		c.Flags().String("account", "", "ID or alias of the account")
+	}
+	accountsRenameCmd.Flags().String("alias", "", "new alias of the account")
+	accountsRotateCmd.Flags().String("credentials", "", "path to the new provider credentials file")
+
+	accountsCmd.AddCommand(accountsListCmd)
+	accountsCmd.AddCommand(accountsShowCmd)
+	accountsCmd.AddCommand(accountsRemoveCmd)
+	accountsCmd.AddCommand(accountsRenameCmd)
+	accountsCmd.AddCommand(accountsRevalidateCmd)
+	accountsCmd.AddCommand(accountsRotateCmd)
+	accountsCmd.AddCommand(accountsLabelCmd)
+	rootCmd.AddCommand(accountsCmd)
+}
+
+// secretCredentialFields lists credential fields that are never printed in clear text
+var secretCredentialFields = map[string]bool{
+	"secret_access_key": true,
+	"session_token":     true,
+	"private_key":       true,
+	"private_key_id":    true,
+	"client_secret":     true,
+	"refresh_token":     true,
+	"password":          true,
+
+	"client_certificate_password": true,
+}
+
+// FindAccount looks up an account by ID or alias
+func (cm *CredentialManager) FindAccount(idOrAlias string) (*CloudAccount, error) {
+	for i := range cm.config.Accounts {
+		if cm.config.Accounts[i].ID == idOrAlias {
+			return &cm.config.Accounts[i], nil
+		}
+	}
+	for i := range cm.config.Accounts {
+		if cm.config.Accounts[i].Alias != "" && cm.config.Accounts[i].Alias == idOrAlias {
+			return &cm.config.Accounts[i], nil
+		}
+	}
+	return nil, fmt.Errorf("account not found: %s", idOrAlias)
+}
+
+// AccountDependents are the accounts and discoveries that use the credentials of an account
+type AccountDependents struct {
+	Accounts    []string
+	Discoveries []string
+}
+
+// empty reports whether nothing depends on the account
+func (d AccountDependents) empty() bool {
+	return len(d.Accounts) == 0 && len(d.Discoveries) == 0
+}
+
+func (d AccountDependents) String() string {
+	parts := []string{}
+	if len(d.Accounts) > 0 {
+		parts = append(parts, "accounts "+strings.Join(d.Accounts, ", "))
+	}
+	if len(d.Discoveries) > 0 {
+		parts = append(parts, "discoveries "+strings.Join(d.Discoveries, ", "))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// accountDependents returns the accounts that authenticate through the credentials of the
+// account, directly or through another dependent account, and the discoveries that list
+// their members through one of them. It reads the source account recorded on each account,
+// so no credentials are loaded.
+func (cm *CredentialManager) accountDependents(accountID string) AccountDependents {
+	dependents := AccountDependents{Accounts: []string{}, Discoveries: []string{}}
+	removed := map[string]bool{accountID: true}
+	for changed := true; changed; {
+		changed = false
+		for _, account := range cm.config.Accounts {
+			if !removed[account.ID] && removed[account.SourceAccountID] {
+				removed[account.ID] = true
+				dependents.Accounts = append(dependents.Accounts, account.ID)
+				changed = true
+			}
+		}
+	}
+	for _, discovery := range cm.config.Discoveries {
+		if removed[discovery.SourceAccountID] {
+			dependents.Discoveries = append(dependents.Discoveries, discovery.ID)
+		}
+	}
+	return dependents
+}
+
+// RemoveAccount removes an account and deletes its credentials from the credential store.
+// It refuses while other accounts or discoveries use the credentials of the account,
+// unless force is set, in which case those are removed as well and returned.
+func (cm *CredentialManager) RemoveAccount(idOrAlias string, force bool) (*CloudAccount, AccountDependents, error) {
+	account, err := cm.FindAccount(idOrAlias)
+	if err != nil {
+		return nil, AccountDependents{}, err
+	}
+	removed := *account
+
+	dependents := cm.accountDependents(removed.ID)
+	if !dependents.empty() && !force {
+		return nil, dependents, fmt.Errorf("account %s is used by %s; remove them first or pass --force to remove them as well", removed.ID, dependents)
+	}
+
+	accountIDs := append([]string{removed.ID}, dependents.Accounts...)
+	if err := cm.removeAccounts(accountIDs, dependents.Discoveries); err != nil {
+		return nil, dependents, err
+	}
+	return &removed, dependents, nil
+}
+
+// removeAccounts removes accounts and discoveries without checking what depends on them.
+// The config is saved before the credentials are deleted from their credential stores,
+// so a failed save never leaves the config referring to secrets that are gone.
+func (cm *CredentialManager) removeAccounts(accountIDs, discoveryIDs []string) error {
+	removedAccounts := map[string]bool{}
+	for _, id := range accountIDs {
+		removedAccounts[id] = true
+	}
+	removedDiscoveries := map[string]bool{}
+	for _, id := range discoveryIDs {
+		removedDiscoveries[id] = true
+	}
+
+	accounts := make([]CloudAccount, 0, len(cm.config.Accounts))
+	deleted := []CloudAccount{}
+	for _, a := range cm.config.Accounts {
+		if removedAccounts[a.ID] {
+			deleted = append(deleted, a)
+		} else {
+			accounts = append(accounts, a)
+		}
+	}
+	cm.config.Accounts = accounts
+
+	discoveries := make([]Discovery, 0, len(cm.config.Discoveries))
+	for _, d := range cm.config.Discoveries {
+		if !removedDiscoveries[d.ID] {
+			discoveries = append(discoveries, d)
+		}
+	}
+	cm.config.Discoveries = discoveries
+
+	if err := cm.saveConfig(); err != nil {
+		return err
+	}
+
+	// The accounts are gone from the config; a secret left behind in a store is only reported
+	for i := range deleted {
+		account := &deleted[i]
+		store, err := cm.credentialStore(account.CredentialStore)
+		if err == nil {
+			err = store.Delete(account)
+		}
+		if err != nil {
+			log.Printf("⚠️ Warning: failed to delete stored credentials of account %s: %v", account.ID, err)
+		}
+	}
+	return nil
+}
+
+// RenameAccount sets the alias of an account
+func (cm *CredentialManager) RenameAccount(idOrAlias, alias string) error {
+	account, err := cm.FindAccount(idOrAlias)
+	if err != nil {
+		return err
+	}
+
+	if err := cm.checkAliasAvailable(alias, account.ID); err != nil {
+		return err
+	}
+
+	account.Alias = alias
+	return cm.saveConfig()
+}
+
+// checkAliasAvailable reports an error when the alias already names another account
+func (cm *CredentialManager) checkAliasAvailable(alias, accountID string) error {
+	if alias == "" {
+		return nil
+	}
+	if other, err := cm.FindAccount(alias); err == nil && other.ID != accountID {
+		return fmt.Errorf("alias %s is already used by account %s", alias, other.ID)
+	}
+	return nil
+}
+
+// RevalidateAccount validates the stored credentials of an account and records the result
+func (cm *CredentialManager) RevalidateAccount(idOrAlias string) (*CloudAccount, error) {
+	account, err := cm.FindAccount(idOrAlias)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cm.loadCredentials(account); err != nil {
+		return nil, err
+	}
+
+	if err := cm.validateCredentials(account.Provider, account.Credentials); err != nil {
+		return nil, fmt.Errorf("credential validation failed: %v", err)
+	}
+
+	// Accounts migrated while their credentials were in an external store get their source here
+	account.SourceAccountID = credentialsSourceAccountID(account.Credentials)
+	account.LastValidated = time.Now()
+	return account, cm.saveConfig()
+}
+
+// RotateAccount replaces the credentials of an account with new ones for the same identity.
+// The account keeps its ID, alias and added date, so its generated directory does not change.
+func (cm *CredentialManager) RotateAccount(idOrAlias, credentialsPath string, opts AddOptions) (*CloudAccount, error) {
+	account, err := cm.FindAccount(idOrAlias)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cm.loadCredentials(account); err != nil {
+		return nil, err
+	}
+	current := account.Credentials
+
+	if sourceID := credentialsSourceAccountID(current); sourceID != "" {
+		return nil, fmt.Errorf("account %s uses the credentials of account %s; rotate that account instead", account.ID, sourceID)
+	}
+	if awsCreds, ok := current.(*AWSCredentials); ok && opts.Region == "" {
+		opts.Region = awsCreds.Region
+	}
+
+	credentials, err := cm.readCredentialsFile(account.Provider, credentialsPath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %v", err)
+	}
+
+	// Role settings belong to the account, not to the keys that assume the role
+	rotated := &CloudAccount{ID: account.ID, Provider: account.Provider, Credentials: credentials}
+	if account.Provider == "aws" {
+		rotatedCreds, err := rotated.awsCredentials()
+		if err != nil {
+			return nil, err
+		}
+		currentCreds, err := account.awsCredentials()
+		if err != nil {
+			return nil, err
+		}
+		keepAWSRoleSettings(rotatedCreds, currentCreds)
+	}
+
+	fingerprint, err := cm.generateAccountID(rotated)
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range cm.config.Accounts {
+		if other.ID != account.ID && (other.ID == fingerprint || other.Fingerprint == fingerprint) {
+			return nil, fmt.Errorf("the new credentials belong to account %s", other.ID)
+		}
+	}
+
+	if err := cm.verifyCredentials(account.Provider, credentials, opts.AllowWrite); err != nil {
+		return nil, err
+	}
+
+	// Replace the secret in the store the account already uses
+	store, err := cm.credentialStore(account.CredentialStore)
+	if err != nil {
+		return nil, err
+	}
+	account.Credentials = credentials
+	account.SourceAccountID = credentialsSourceAccountID(credentials)
+	if err := store.Save(account); err != nil {
+		return nil, fmt.Errorf("failed to store credentials: %v", err)
+	}
+
+	now := time.Now()
+	account.Fingerprint = fingerprint
+	account.LastValidated = now
+	account.Rotations = append(account.Rotations, now)
+
+	return account, cm.saveConfig()
+}
+
+// keepAWSRoleSettings copies the role an account assumes onto its rotated credentials
+func keepAWSRoleSettings(rotated, current *AWSCredentials) {
+	if rotated.RoleARN != "" || current.RoleARN == "" {
+		return
+	}
+	rotated.RoleARN = current.RoleARN
+	rotated.ExternalID = current.ExternalID
+	rotated.RoleSessionName = current.RoleSessionName
+	rotated.STSEndpoint = current.STSEndpoint
+}
+
+// maskedCredentials returns the credentials as a map with secret fields masked,
+// including those inside nested documents
+func maskedCredentials(creds interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	masked := map[string]interface{}{}
+	if err := json.Unmarshal(data, &masked); err != nil {
+		return nil, err
+	}
+
+	maskSecretFields(masked)
+	return masked, nil
+}
+
+// maskSecretFields masks secret fields of a decoded JSON object in place
+func maskSecretFields(fields map[string]interface{}) {
+	for key, value := range fields {
+		switch v := value.(type) {
+		case string:
+			if v != "" && secretCredentialFields[key] {
+				fields[key] = maskSecret(v)
+			}
+		case map[string]interface{}:
+			maskSecretFields(v)
+		}
+	}
+}
+
+// maskSecret hides all but the last four characters of a secret
+func maskSecret(secret string) string {
+	if len(secret) <= 8 {
+		return "********"
+	}
+	return "********" + secret[len(secret)-4:]
+}
+
+// accountsListCommand lists configured accounts as a table or JSON
+func accountsListCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "config")
+	if len(args) != 1 {
+		fmt.Println("Usage: yogaya accounts list [.yogaya/cloud_accounts.conf-file-path] [--output table|json]")
+		return
+	}
+
+	cm, err := NewCredentialManager(args[0])
+	if err != nil {
+		fmt.Printf("Error initializing credential manager: %v\n", err)
+		return
+	}
+
+	selector, err := parseSelector(accountsListSelector)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	selected := cm.SelectAccounts(selector)
+
+	switch accountsListOutput {
+	case "json":
+		accounts := make([]CloudAccount, len(selected))
+		for i, account := range selected {
+			account.Credentials = nil
+			account.SealedCredentials = nil
+			accounts[i] = account
+		}
+		data, err := json.MarshalIndent(accounts, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding accounts: %v\n", err)
+			return
+		}
+		fmt.Println(string(data))
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tALIAS\tPROVIDER\tLABELS\tSTORE\tADDED\tLAST VALIDATED")
+		for _, account := range selected {
+			store := account.CredentialStore
+			if store == "" {
+				store = fileStoreBackend
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				account.ID,
+				account.Alias,
+				account.Provider,
+				formatLabels(account.Labels),
+				store,
+				account.AddedAt.Format(time.RFC3339),
+				account.LastValidated.Format(time.RFC3339))
+		}
+		w.Flush()
+	default:
+		fmt.Printf("Unsupported output format: %s\n", accountsListOutput)
+	}
+}
+
+// accountsShowCommand prints a single account with masked secrets
+func accountsShowCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "config", "account")
+	if len(args) != 2 {
+		fmt.Println("Usage: yogaya accounts show [.yogaya/cloud_accounts.conf-file-path] <account-id-or-alias>")
+		return
+	}
+
+	cm, err := NewCredentialManager(args[0])
+	if err != nil {
+		fmt.Printf("Error initializing credential manager: %v\n", err)
+		return
+	}
+
+	account, err := cm.FindAccount(args[1])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("ID: %s\n", account.ID)
+	fmt.Printf("Alias: %s\n", account.Alias)
+	fmt.Printf("Provider: %s\n", account.Provider)
+	fmt.Printf("Labels: %s\n", formatLabels(account.Labels))
+	if account.Status != "" {
+		fmt.Printf("Status: %s\n", account.Status)
+	}
+	if account.DiscoveredBy != "" {
+		fmt.Printf("Discovered By: %s (%s)\n", account.DiscoveredBy, account.ProviderAccountID)
+	}
+	fmt.Printf("Added: %s\n", account.AddedAt.Format(time.RFC3339))
+	fmt.Printf("Last Validated: %s\n", account.LastValidated.Format(time.RFC3339))
+	if account.CredentialStore != "" {
+		fmt.Printf("Credential Store: %s (%s)\n", account.CredentialStore, account.CredentialRef)
+	}
+	if len(account.Rotations) > 0 {
+		fmt.Printf("Last Rotated: %s (%d rotations)\n", account.Rotations[len(account.Rotations)-1].Format(time.RFC3339), len(account.Rotations))
+	}
+
+	if err := cm.loadCredentials(account); err != nil {
+		fmt.Printf("Credentials: unavailable (%v)\n", err)
+		return
+	}
+
+	masked, err := maskedCredentials(account.Credentials)
+	if err != nil {
+		fmt.Printf("Error masking credentials: %v\n", err)
+		return
+	}
+
+	keys := make([]string, 0, len(masked))
+	for key := range masked {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Println("Credentials:")
+	for _, key := range keys {
+		fmt.Printf("  %s: %v\n", key, masked[key])
+	}
+}
+
+// accountsRemoveCommand removes an account
+func accountsRemoveCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "config", "account")
+	if len(args) != 2 {
+		fmt.Println("Usage: yogaya accounts remove [.yogaya/cloud_accounts.conf-file-path] <account-id-or-alias>")
+		return
+	}
+
+	cm, err := NewCredentialManager(args[0])
+	if err != nil {
+		fmt.Printf("Error initializing credential manager: %v\n", err)
+		return
+	}
+
+	removed, dependents, err := cm.RemoveAccount(args[1], accountsRemoveForce)
+	if err != nil {
+		fmt.Printf("Error removing account: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Successfully removed %s account %s\n", removed.Provider, removed.ID)
+	message := fmt.Sprintf("accounts remove: %s account %s", removed.Provider, removed.displayName())
+	if !dependents.empty() {
+		fmt.Printf("Also removed %s\n", dependents)
+		message += fmt.Sprintf(" with %s", dependents)
+	}
+	cm.commitHistory(message)
+}
+
+// accountsRenameCommand sets the alias of an account
+func accountsRenameCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "config", "account", "alias")
+	if len(args) != 3 {
+		fmt.Println("Usage: yogaya accounts rename [.yogaya/cloud_accounts.conf-file-path] <account-id-or-alias> <new-alias>")
+		return
+	}
+
+	alias := strings.TrimSpace(args[2])
+
+	cm, err := NewCredentialManager(args[0])
+	if err != nil {
+		fmt.Printf("Error initializing credential manager: %v\n", err)
+		return
+	}
+
+	if err := cm.RenameAccount(args[1], alias); err != nil {
+		fmt.Printf("Error renaming account: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Successfully set alias of account %s to %s\n", args[1], alias)
+	cm.commitHistory(fmt.Sprintf("accounts rename: %s to %s", args[1], alias))
+}
+
+// accountsRevalidateCommand validates the credentials of an account again
+func accountsRevalidateCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "config", "account")
+	if len(args) != 2 {
+		fmt.Println("Usage: yogaya accounts revalidate [.yogaya/cloud_accounts.conf-file-path] <account-id-or-alias>")
+		return
+	}
+
+	cm, err := NewCredentialManager(args[0])
+	if err != nil {
+		fmt.Printf("Error initializing credential manager: %v\n", err)
+		return
+	}
+
+	account, err := cm.RevalidateAccount(args[1])
+	if err != nil {
+		fmt.Printf("Error revalidating account: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Successfully validated %s account %s at %s\n", account.Provider, account.ID, account.LastValidated.Format(time.RFC3339))
+	cm.commitHistory(fmt.Sprintf("accounts revalidate: %s account %s", account.Provider, account.displayName()))
+}
+
+// accountsRotateCommand replaces the credentials of an account
+func accountsRotateCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "config", "account", "credentials")
+	if len(args) != 3 {
+		fmt.Println("Usage: yogaya accounts rotate [.yogaya/cloud_accounts.conf-file-path] <account-id-or-alias> <new-provider-credentials-file-path>")
+		return
+	}
+
+	cm, err := NewCredentialManager(args[0])
+	if err != nil {
+		fmt.Printf("Error initializing credential manager: %v\n", err)
+		return
+	}
+
+	account, err := cm.RotateAccount(args[1], args[2], rotateOptions)
+	if err != nil {
+		fmt.Printf("Error rotating credentials: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Successfully rotated credentials of %s account %s\n", account.Provider, account.ID)
+	cm.commitHistory(fmt.Sprintf("accounts rotate: %s account %s", account.Provider, account.displayName()))
+}
+
+// accountsLabelCommand sets or removes labels of an account
+func accountsLabelCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "config", "account")
+	if len(args) < 3 {
+		fmt.Println("Usage: yogaya accounts label [.yogaya/cloud_accounts.conf-file-path] <account-id-or-alias> <key=value|key->...")
+		return
+	}
+
+	set := []string{}
+	remove := []string{}
+	for _, arg := range args[2:] {
+		if key, found := strings.CutSuffix(arg, "-"); found && !strings.Contains(arg, "=") {
+			remove = append(remove, key)
+			continue
+		}
+		set = append(set, arg)
+	}
+
+	labels, err := parseLabels(set)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	cm, err := NewCredentialManager(args[0])
+	if err != nil {
+		fmt.Printf("Error initializing credential manager: %v\n", err)
+		return
+	}
+
+	account, err := cm.LabelAccount(args[1], labels, remove)
+	if err != nil {
+		fmt.Printf("Error labeling account: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Labels of account %s: %s\n", account.displayName(), formatLabels(account.Labels))
+	cm.commitHistory(fmt.Sprintf("accounts label: %s %s", account.displayName(), formatLabels(account.Labels)))
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAccountDependents(t *testing.T) {
+	// The variables of the env store are unset, so loading any credentials would fail
+	cm := &CredentialManager{}
+	cm.config.Accounts = []CloudAccount{
+		{ID: "management", Provider: "aws", CredentialStore: envStoreBackend, CredentialRef: "YOGAYA_TEST_UNSET_MANAGEMENT"},
+		{ID: "member", Provider: "aws", CredentialStore: envStoreBackend, CredentialRef: "YOGAYA_TEST_UNSET_MEMBER", SourceAccountID: "management"},
+		{ID: "chained", Provider: "aws", CredentialStore: envStoreBackend, CredentialRef: "YOGAYA_TEST_UNSET_CHAINED", SourceAccountID: "member"},
+		{ID: "other", Provider: "gcp", CredentialStore: envStoreBackend, CredentialRef: "YOGAYA_TEST_UNSET_OTHER"},
+	}
+	cm.config.Discoveries = []Discovery{
+		{ID: "o-example", Kind: discoveryAWSOrganization, SourceAccountID: "member"},
+		{ID: "org-other", Kind: discoveryGCPOrganization, SourceAccountID: "other"},
+	}
+
+	tests := []struct {
+		accountID string
+		want      AccountDependents
+	}{
+		{accountID: "management", want: AccountDependents{Accounts: []string{"member", "chained"}, Discoveries: []string{"o-example"}}},
+		{accountID: "chained", want: AccountDependents{Accounts: []string{}, Discoveries: []string{}}},
+		{accountID: "other", want: AccountDependents{Accounts: []string{}, Discoveries: []string{"org-other"}}},
+	}
+
+	for _, tt := range tests {
+		if got := cm.accountDependents(tt.accountID); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("accountDependents(%s) = %+v, want %+v", tt.accountID, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveAccountSavesBeforeDeleting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, cloudAccountsFile)
+	helper := credentialHelper(t, dir, path, false)
+
+	cm := &CredentialManager{configPath: path, config: CloudAccountsConfig{SchemaVersion: currentSchemaVersion}}
+	cm.config.Accounts = []CloudAccount{
+		{ID: "management", Provider: "aws", Credentials: &AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "aws-secret"}},
+		{ID: "member", Provider: "aws", Credentials: &AWSCredentials{RoleARN: "arn:aws:iam::111111111111:role/ReadOnly", SourceAccountID: "management"}, SourceAccountID: "management"},
+	}
+	if err := cm.SetCredentialStore(CredentialStoreConfig{Backend: commandStoreBackend, Command: helper}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := cm.RemoveAccount("management", false); err == nil || !strings.Contains(err.Error(), "is used by accounts member") {
+		t.Fatalf("RemoveAccount() error = %v, want it refused while member uses the account", err)
+	}
+
+	// The helper cannot erase a secret it no longer holds; that is reported, not fatal
+	for _, secret := range helperSecrets(t, dir) {
+		if strings.HasSuffix(secret, "member") {
+			if err := os.Remove(secret); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	removed, dependents, err := cm.RemoveAccount("management", true)
+	if err != nil {
+		t.Fatalf("RemoveAccount(force) error = %v", err)
+	}
+	if removed.ID != "management" || !reflect.DeepEqual(dependents.Accounts, []string{"member"}) {
+		t.Errorf("RemoveAccount(force) = %s, %+v; want management and its member", removed.ID, dependents)
+	}
+	if got := helperSecrets(t, dir); len(got) != 0 {
+		t.Errorf("helper still holds %v", got)
+	}
+	if !strings.Contains(logs.String(), "failed to delete stored credentials of account member") {
+		t.Errorf("logs = %q, want a warning about the secret of member", logs.String())
+	}
+
+	// The helper erased the secret of management once the config no longer listed it
+	atErase, err := os.ReadFile(filepath.Join(dir, "config-at-erase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(atErase), `"management"`) {
+		t.Errorf("config when the helper erased a secret = %s, want the account already removed", atErase)
+	}
+}
@@ -12,14 +12,12 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"strings"
+	"sync"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/spf13/cobra"
 	"golang.org/x/oauth2/google"
 )
@@ -31,214 +29,459 @@ var addCmd = &cobra.Command{
 	Run:   addCommand,
 }
 
+var addOptions AddOptions
+
 func init() {
-	rootCmd.DisableFlagParsing = true
+	addCmd.Flags().String("provider", "", "cloud provider of the account (aws|gcp|azure)")
+	addCmd.Flags().String("credentials", "", "path to the provider credentials file")
+	addCmd.Flags().StringVar(&addOptions.AWSProfile, "profile", "", "AWS profile to read from the credentials file (default $AWS_PROFILE or \"default\")")
+	addCmd.Flags().StringVar(&addOptions.AWSConfigFile, "aws-config-file", "", "AWS config file merged with the profile (default $AWS_CONFIG_FILE or ~/.aws/config)")
+	addCmd.Flags().StringVar(&addOptions.Region, "region", "", "AWS region used for STS and validation")
+	addCmd.Flags().StringVar(&addOptions.RoleARN, "role-arn", "", "AWS role to assume for this account")
+	addCmd.Flags().StringVar(&addOptions.ExternalID, "external-id", "", "external ID passed when assuming the role")
+	addCmd.Flags().StringVar(&addOptions.RoleSessionName, "role-session-name", "", "session name used when assuming the role")
+	addCmd.Flags().StringVar(&addOptions.SourceAccount, "source-account", "", "ID or alias of an added account whose credentials assume the role or list the organization's accounts")
+	addCmd.Flags().StringVar(&addOptions.STSEndpoint, "sts-endpoint", "", "custom STS endpoint URL (e.g. a local STS stub)")
+	addCmd.Flags().StringVar(&addOptions.GCPProject, "project", "", "GCP project, required when the credentials do not name one")
+	addCmd.Flags().StringVar(&addOptions.RoleTemplate, "role-template", "", "aws-org: role assumed in each member account, as a name or an ARN with {account_id}")
+	addCmd.Flags().StringVar(&addOptions.GCPParent, "parent", "", "gcp-org: organizations/<id> or folders/<id> whose projects are added")
+	addCmd.Flags().BoolVar(&addOptions.Sync, "sync", false, "aws-org, gcp-org, azure --all-subscriptions: sync an organization that was already added")
+	addCmd.Flags().StringVar(&addOptions.Alias, "alias", "", "human readable alias of the account")
+	addCmd.Flags().StringSliceVar(&addOptions.Labels, "label", nil, "label of the account as key=value (repeatable)")
+	addCmd.Flags().BoolVar(&addOptions.AllowWrite, "allow-write", false, "add the account even if its credentials can modify infrastructure")
+	addCmd.Flags().StringVar(&addOptions.AzureSubscription, "subscription", "", "Azure subscription, required when the service principal file does not name one")
+	addCmd.Flags().BoolVar(&addOptions.AllSubscriptions, "all-subscriptions", false, "azure: add every subscription of the tenant visible to the identity")
+	addCmd.Flags().StringVar(&addOptions.ManagementGroup, "management-group", "", "azure --all-subscriptions: only add subscriptions below this management group")
+	addCmd.Flags().StringVar(&addOptions.SubscriptionPattern, "subscription-pattern", "", "azure --all-subscriptions: only add subscriptions whose name matches this glob (e.g. 'prod-*')")
+
 	rootCmd.AddCommand(addCmd)
 }
 
 // CloudAccount represents a single cloud account configuration
 type CloudAccount struct {
-	ID            string      `json:"id"`
-	Provider      string      `json:"provider"`
-	AddedAt       time.Time   `json:"added_at"`
-	LastValidated time.Time   `json:"last_validated"`
-	Credentials   interface{} `json:"credentials"`
+	ID                string             `json:"id"`
+	Alias             string             `json:"alias,omitempty"`
+	Labels            map[string]string  `json:"labels,omitempty"`
+	Provider          string             `json:"provider"`
+	AddedAt           time.Time          `json:"added_at"`
+	LastValidated     time.Time          `json:"last_validated"`
+	Credentials       interface{}        `json:"credentials,omitempty"`
+	SealedCredentials *SealedCredentials `json:"sealed_credentials,omitempty"`
+	CredentialStore   string             `json:"credential_store,omitempty"`
+	CredentialRef     string             `json:"credential_ref,omitempty"`
+	Fingerprint       string             `json:"fingerprint,omitempty"`
+	Rotations         []time.Time        `json:"rotations,omitempty"`
+	Status            string             `json:"status,omitempty"`
+	DiscoveredBy      string             `json:"discovered_by,omitempty"`
+	ProviderAccountID string             `json:"provider_account_id,omitempty"`
+	// SourceAccountID copies the source account of the credentials, so what depends on an
+	// account is known without loading secrets from a credential store
+	SourceAccountID string `json:"source_account_id,omitempty"`
 }
 
 // AWSCredentials represents AWS-specific credentials
 type AWSCredentials struct {
-	AccessKeyID     string `json:"access_key_id" yaml:"access_key_id"`
-	SecretAccessKey string `json:"secret_access_key" yaml:"secret_access_key"`
-	Region          string `json:"region" yaml:"region"`
+	Type              string `json:"type,omitempty" yaml:"type,omitempty"`
+	Profile           string `json:"profile,omitempty" yaml:"profile,omitempty"`
+	AccessKeyID       string `json:"access_key_id,omitempty" yaml:"access_key_id,omitempty"`
+	SecretAccessKey   string `json:"secret_access_key,omitempty" yaml:"secret_access_key,omitempty"`
+	SessionToken      string `json:"session_token,omitempty" yaml:"session_token,omitempty"`
+	Region            string `json:"region" yaml:"region"`
+	RoleARN           string `json:"role_arn,omitempty" yaml:"role_arn,omitempty"`
+	ExternalID        string `json:"external_id,omitempty" yaml:"external_id,omitempty"`
+	RoleSessionName   string `json:"role_session_name,omitempty" yaml:"role_session_name,omitempty"`
+	SourceAccountID   string `json:"source_account_id,omitempty" yaml:"source_account_id,omitempty"`
+	STSEndpoint       string `json:"sts_endpoint,omitempty" yaml:"sts_endpoint,omitempty"`
+	SSOSession        string `json:"sso_session,omitempty" yaml:"sso_session,omitempty"`
+	SSOStartURL       string `json:"sso_start_url,omitempty" yaml:"sso_start_url,omitempty"`
+	SSORegion         string `json:"sso_region,omitempty" yaml:"sso_region,omitempty"`
+	SSOAccountID      string `json:"sso_account_id,omitempty" yaml:"sso_account_id,omitempty"`
+	SSORoleName       string `json:"sso_role_name,omitempty" yaml:"sso_role_name,omitempty"`
+	CredentialProcess string `json:"credential_process,omitempty" yaml:"credential_process,omitempty"`
 }
 
 // GCPCloudCredentials represents GCP-specific credentials
 type GCPCloudCredentials struct {
-	ProjectID    string `json:"project_id" yaml:"project_id"`
-	PrivateKeyID string `json:"private_key_id" yaml:"private_key_id"`
-	PrivateKey   string `json:"private_key" yaml:"private_key"`
-	ClientEmail  string `json:"client_email" yaml:"client_email"`
-	ClientID     string `json:"client_id" yaml:"client_id"`
+	Type      string          `json:"type,omitempty" yaml:"type,omitempty"`
+	ProjectID string          `json:"project_id" yaml:"project_id"`
+	Principal string          `json:"principal,omitempty" yaml:"principal,omitempty"`
+	Document  json.RawMessage `json:"document,omitempty" yaml:"document,omitempty"`
+	// SourceAccountID is set on projects that use the credentials of another account (gcp-org)
+	SourceAccountID string `json:"source_account_id,omitempty" yaml:"source_account_id,omitempty"`
+
+	// Service account fields of accounts added before the credential document was kept
+	PrivateKeyID string `json:"private_key_id,omitempty" yaml:"private_key_id,omitempty"`
+	PrivateKey   string `json:"private_key,omitempty" yaml:"private_key,omitempty"`
+	ClientEmail  string `json:"client_email,omitempty" yaml:"client_email,omitempty"`
+	ClientID     string `json:"client_id,omitempty" yaml:"client_id,omitempty"`
 }
 
 // AzureCredentials represents Azure-specific credentials
 type AzureCredentials struct {
-	SubscriptionID string `json:"subscription_id"`
-	TenantID       string `json:"tenant_id"`
-	Name           string `json:"name"`
-	Environment    string `json:"environment"`
+	AuthType                  string `json:"auth_type,omitempty"`
+	SubscriptionID            string `json:"subscription_id"`
+	TenantID                  string `json:"tenant_id"`
+	Name                      string `json:"name"`
+	Environment               string `json:"environment"`
+	ClientID                  string `json:"client_id,omitempty"`
+	ClientSecret              string `json:"client_secret,omitempty"`
+	ClientCertificatePath     string `json:"client_certificate_path,omitempty"`
+	ClientCertificatePassword string `json:"client_certificate_password,omitempty"`
+	// SourceAccountID is set on subscriptions that use the credentials of another account (--all-subscriptions)
+	SourceAccountID string `json:"source_account_id,omitempty"`
+}
+
+// AddOptions holds the optional settings used when adding an account
+type AddOptions struct {
+	AWSProfile      string
+	AWSConfigFile   string
+	Region          string
+	RoleARN         string
+	ExternalID      string
+	RoleSessionName string
+	SourceAccount   string
+	STSEndpoint     string
+	GCPProject      string
+
+	AzureSubscription   string
+	AllSubscriptions    bool
+	ManagementGroup     string
+	SubscriptionPattern string
+
+	AllowWrite bool
+
+	Alias  string
+	Labels []string
+
+	RoleTemplate string
+	GCPParent    string
+	Sync         bool
 }
 
 // CloudAccountsConfig represents the structure of cloud_accounts.conf
 type CloudAccountsConfig struct {
-	Accounts []CloudAccount `json:"accounts"`
+	SchemaVersion   int                    `json:"schema_version"`
+	Encryption      *EncryptionConfig      `json:"encryption,omitempty"`
+	CredentialStore *CredentialStoreConfig `json:"credential_store,omitempty"`
+	Accounts        []CloudAccount         `json:"accounts"`
+	Discoveries     []Discovery            `json:"discoveries,omitempty"`
+	Output          *OutputConfig          `json:"output,omitempty"`
 }
 
 // CredentialManager handles cloud provider credentials
 type CredentialManager struct {
-	configPath string
-	config     CloudAccountsConfig
+	configPath        string
+	config            CloudAccountsConfig
+	encryptionKey     []byte
+	appliedMigrations []configMigration
+	// tenantKey identifies the workspace in credential stores shared by several workspaces
+	tenantKey string
+	// credentialsMu serializes loading credentials from the credential store
+	credentialsMu sync.Mutex
+	// verifyAccess replaces verifyCredentials in tests, which cannot reach the providers
+	verifyAccess func(provider string, creds interface{}, allowWrite bool) error
 }
 
 // NewCredentialManager creates a new credential manager instance
 func NewCredentialManager(configPath string) (*CredentialManager, error) {
 	cm := &CredentialManager{
 		configPath: configPath,
-		config:     CloudAccountsConfig{Accounts: []CloudAccount{}},
+		config:     CloudAccountsConfig{SchemaVersion: currentSchemaVersion, Accounts: []CloudAccount{}},
 	}
 
 	if err := cm.loadConfig(); err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
 
+	tenant, err := readTenantConf(workspaceOfConfig(configPath).TenantConfPath())
+	if err != nil {
+		return nil, err
+	}
+	cm.tenantKey = tenant.TenantKey
+
 	return cm, nil
 }
 
-// loadConfig loads the existing configuration from cloud_accounts.conf
+// loadConfig loads the existing configuration from cloud_accounts.conf,
+// upgrades files written with an older schema and decrypts sealed credentials
+// when the file is encrypted
 func (cm *CredentialManager) loadConfig() error {
 	data, err := os.ReadFile(cm.configPath)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &cm.config)
+
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	version, err := schemaVersion(raw)
+	if err != nil {
+		return err
+	}
+	pending, err := pendingMigrations(version)
+	if err != nil {
+		return err
+	}
+
+	var header struct {
+		Encryption *EncryptionConfig `json:"encryption"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return err
+	}
+	if header.Encryption != nil {
+		key, err := header.Encryption.deriveKey()
+		if err != nil {
+			return err
+		}
+		if err := header.Encryption.verifyKey(key); err != nil {
+			return err
+		}
+		cm.encryptionKey = key
+	}
+
+	if len(pending) > 0 {
+		backupPath, err := backupConfigFile(cm.configPath, data, version)
+		if err != nil {
+			return err
+		}
+		log.Printf("Backed up %s to %s before migrating it", cm.configPath, backupPath)
+
+		if err := openRawCredentials(raw, cm.encryptionKey); err != nil {
+			return err
+		}
+		if err := applyMigrations(raw, pending); err != nil {
+			return err
+		}
+		if data, err = json.Marshal(raw); err != nil {
+			return err
+		}
+	}
+
+	if err := json.Unmarshal(data, &cm.config); err != nil {
+		return err
+	}
+
+	for i := range cm.config.Accounts {
+		account := &cm.config.Accounts[i]
+		if account.SealedCredentials == nil {
+			continue
+		}
+		plaintext, err := openCredentials(cm.encryptionKey, account.ID, account.SealedCredentials)
+		if err != nil {
+			return err
+		}
+		creds, err := decodeCredentials(account.Provider, plaintext)
+		if err != nil {
+			return fmt.Errorf("failed to decode credentials for account %s: %v", account.ID, err)
+		}
+		account.Credentials = creds
+		account.SealedCredentials = nil
+	}
+
+	if len(pending) > 0 {
+		cm.appliedMigrations = pending
+		return cm.saveConfig()
+	}
+	return nil
 }
 
-// saveConfig saves the current configuration to cloud_accounts.conf
+// saveConfig saves the current configuration to cloud_accounts.conf.
+// Credentials kept in an external store are replaced by their reference,
+// and inline credentials are sealed when encryption is enabled.
 func (cm *CredentialManager) saveConfig() error {
-	data, err := json.MarshalIndent(cm.config, "", "  ")
+	stored := cm.config
+	stored.Accounts = make([]CloudAccount, len(cm.config.Accounts))
+	for i, account := range cm.config.Accounts {
+		switch {
+		case account.CredentialStore != "" && account.CredentialStore != fileStoreBackend:
+			account.Credentials = nil
+		case stored.Encryption != nil && account.Credentials != nil:
+			sealed, err := sealCredentials(cm.encryptionKey, account.ID, account.Credentials)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt credentials for account %s: %v", account.ID, err)
+			}
+			account.SealedCredentials = sealed
+			account.Credentials = nil
+		}
+		stored.Accounts[i] = account
+	}
+
+	data, err := json.MarshalIndent(stored, "", "  ")
 	if err != nil {
 		return err
 	}
 	return os.WriteFile(cm.configPath, data, 0600)
 }
 
+// EnableEncryption seals all credentials with a key from the passphrase or keyfile
+func (cm *CredentialManager) EnableEncryption() error {
+	if cm.config.Encryption != nil {
+		return fmt.Errorf("%s is already encrypted", cm.configPath)
+	}
+
+	encryption, key, err := newEncryptionConfig()
+	if err != nil {
+		return err
+	}
+
+	cm.config.Encryption = encryption
+	cm.encryptionKey = key
+
+	return cm.saveConfig()
+}
+
 // generateAccountID generates a unique ID for an account based on its credentials
-func (cm *CredentialManager) generateAccountID(account *CloudAccount) string {
+func (cm *CredentialManager) generateAccountID(account *CloudAccount) (string, error) {
 	hash := sha256.New()
 	switch account.Provider {
 	case "aws":
-		hash.Write([]byte(account.Credentials.(*AWSCredentials).AccessKeyID + account.Credentials.(*AWSCredentials).Region))
+		awsCreds, err := account.awsCredentials()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case awsCreds.RoleARN != "":
+			// Several roles can be assumed with the same keys, so the role identifies the account
+			hash.Write([]byte(awsCreds.RoleARN + awsCreds.Region))
+		case awsCreds.Type == awsCredentialSSO:
+			hash.Write([]byte(awsCreds.SSOAccountID + awsCreds.SSORoleName + awsCreds.Region))
+		case awsCreds.Type == awsCredentialProcess:
+			hash.Write([]byte(awsCreds.CredentialProcess + awsCreds.Region))
+		default:
+			hash.Write([]byte(awsCreds.AccessKeyID + awsCreds.Region))
+		}
 	case "gcp":
-		hash.Write([]byte(account.Credentials.(*GCPCloudCredentials).ProjectID + account.Credentials.(*GCPCloudCredentials).ClientEmail))
+		gcpCreds, err := account.gcpCredentials()
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte(gcpCreds.ProjectID + gcpCreds.principal()))
 	case "azure":
-		hash.Write([]byte(account.Credentials.(*AzureCredentials).SubscriptionID + account.Credentials.(*AzureCredentials).TenantID))
+		azureCreds, err := account.azureCredentials()
+		if err != nil {
+			return "", err
+		}
+		// Service principals are told apart from the CLI login on the same subscription
+		hash.Write([]byte(azureCreds.SubscriptionID + azureCreds.TenantID + azureCreds.ClientID))
+	default:
+		return "", fmt.Errorf("unsupported provider: %s", account.Provider)
 	}
-	return hex.EncodeToString(hash.Sum(nil))[:12]
+	return hex.EncodeToString(hash.Sum(nil))[:12], nil
 }
 
-// isDuplicateAccount checks if an account already exists
-func (cm *CredentialManager) isDuplicateAccount(newAccount *CloudAccount) bool {
-	newID := cm.generateAccountID(newAccount)
+// isDuplicateAccount checks if an account already exists.
+// Rotated accounts keep their ID, so the fingerprint of the current credentials is compared too.
+func (cm *CredentialManager) isDuplicateAccount(newAccount *CloudAccount) (bool, error) {
+	newID, err := cm.generateAccountID(newAccount)
+	if err != nil {
+		return false, err
+	}
 	for _, account := range cm.config.Accounts {
-		if account.ID == newID {
-			return true
+		if account.ID == newID || account.Fingerprint == newID {
+			return true, nil
 		}
 	}
-	return false
+	return false, nil
 }
 
-// AddCredentials adds new cloud provider credentials
-func (cm *CredentialManager) AddCredentials(provider, credentialsPath string) error {
-	credentials, err := cm.readCredentialsFile(provider, credentialsPath)
+// AddCredentials adds new cloud provider credentials and returns the added account
+func (cm *CredentialManager) AddCredentials(provider, credentialsPath string, opts AddOptions) (*CloudAccount, error) {
+	labels, err := parseLabels(opts.Labels)
 	if err != nil {
-		return fmt.Errorf("failed to read credentials file: %v", err)
+		return nil, err
+	}
+	if err := cm.checkAliasAvailable(opts.Alias, ""); err != nil {
+		return nil, err
+	}
+
+	credentials, err := cm.readCredentialsFile(provider, credentialsPath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %v", err)
 	}
 
 	newAccount := &CloudAccount{
-		Provider:    provider,
-		AddedAt:     time.Now(),
-		Credentials: credentials,
+		Alias:           opts.Alias,
+		Provider:        provider,
+		AddedAt:         time.Now(),
+		Credentials:     credentials,
+		SourceAccountID: credentialsSourceAccountID(credentials),
+	}
+	if len(labels) > 0 {
+		newAccount.Labels = labels
 	}
 
-	if cm.isDuplicateAccount(newAccount) {
-		return fmt.Errorf("duplicate account: credentials for this account already exist")
+	duplicate, err := cm.isDuplicateAccount(newAccount)
+	if err != nil {
+		return nil, err
+	}
+	if duplicate {
+		return nil, fmt.Errorf("duplicate account: credentials for this account already exist")
 	}
 
-	if err := cm.validateCredentials(provider, credentials); err != nil {
-		return fmt.Errorf("credential validation failed: %v", err)
+	if err := cm.verifyCredentials(provider, credentials, opts.AllowWrite); err != nil {
+		return nil, err
 	}
 
 	newAccount.LastValidated = time.Now()
-	newAccount.ID = cm.generateAccountID(newAccount)
+	newAccount.ID, err = cm.generateAccountID(newAccount)
+	if err != nil {
+		return nil, err
+	}
+	newAccount.Fingerprint = newAccount.ID
+
+	store, err := cm.defaultCredentialStore()
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Save(newAccount); err != nil {
+		return nil, fmt.Errorf("failed to store credentials: %v", err)
+	}
 	cm.config.Accounts = append(cm.config.Accounts, *newAccount)
 
-	return cm.saveConfig()
+	return newAccount, cm.saveConfig()
 }
 
 // readCredentialsFile reads and parses the credentials file
-func (cm *CredentialManager) readCredentialsFile(provider, path string) (interface{}, error) {
+func (cm *CredentialManager) readCredentialsFile(provider, path string, opts AddOptions) (interface{}, error) {
 	switch provider {
 	case "aws":
-		data, err := os.ReadFile(path)
-		if err != nil {
+		creds := &AWSCredentials{}
+		// Accounts chained from a source account do not need keys of their own
+		if path != "" || opts.SourceAccount == "" {
+			source, err := readAWSProfileSource(path, opts)
+			if err != nil {
+				return nil, err
+			}
+			creds, err = parseAWSCredentials(source)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := cm.applyAWSRoleOptions(creds, opts); err != nil {
 			return nil, err
 		}
-		return parseAWSCredentials(data)
+		return creds, nil
 	case "gcp":
 		data, err := os.ReadFile(path)
 		if err != nil {
 			return nil, err
 		}
-		return parseGCPCredentials(data)
+		return parseGCPCredentials(data, opts)
 	case "azure":
-		return getAzureCredentialsFromCLI()
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", provider)
-	}
-}
-
-// parseAWSCredentials parses AWS credentials from INI format
-func parseAWSCredentials(data []byte) (interface{}, error) {
-	lines := strings.Split(string(data), "\n")
-	creds := &AWSCredentials{}
-
-	var currentProfile string
-	var awsAccessKeyId, awsSecretAccessKey, region string
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
-			continue // Skip empty lines and comments
+		// "any" keeps using the subscription of the current `az login` session
+		if path == "" || path == "any" {
+			return getAzureCredentialsFromCLI()
 		}
-
-		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
-			currentProfile = line[1 : len(line)-1]
-			continue
-		}
-
-		if currentProfile == "default" {
-			if strings.HasPrefix(line, "aws_access_key_id") {
-				awsAccessKeyId = strings.SplitN(line, "=", 2)[1]
-			} else if strings.HasPrefix(line, "aws_secret_access_key") {
-				awsSecretAccessKey = strings.SplitN(line, "=", 2)[1]
-			} else if strings.HasPrefix(line, "region") {
-				region = strings.SplitN(line, "=", 2)[1]
-			}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
 		}
+		return parseAzureServicePrincipal(data, opts)
+	default:
+		return nil, fmt.Errorf("unsupported provider: %s", provider)
 	}
-
-	// return awsCreds, nil
-	awsAccessKeyId = strings.TrimSpace(awsAccessKeyId)
-	awsSecretAccessKey = strings.TrimSpace(awsSecretAccessKey)
-	region = strings.TrimSpace(region)
-
-	creds.AccessKeyID = awsAccessKeyId
-	creds.SecretAccessKey = awsSecretAccessKey
-	creds.Region = region
-
-	return creds, nil
-}
-
-// parseGCPCredentials parses GCP credentials from JSON format
-func parseGCPCredentials(data []byte) (interface{}, error) {
-	creds := &GCPCloudCredentials{}
-
-	err := json.Unmarshal(data, creds)
-	if err != nil {
-		log.Fatalf("Unable to decode JSON: %v", err)
-	}
-
-	return creds, nil
 }
 
 // getAzureCredentialsFromCLI retrieves Azure credentials from Azure CLI
@@ -261,6 +504,7 @@ func getAzureCredentialsFromCLI() (*AzureCredentials, error) {
 	}
 
 	return &AzureCredentials{
+		AuthType:       azureAuthCLI,
 		SubscriptionID: accountInfo.ID,
 		TenantID:       accountInfo.TenantID,
 		Name:           accountInfo.Name,
@@ -268,7 +512,20 @@ func getAzureCredentialsFromCLI() (*AzureCredentials, error) {
 	}, nil
 }
 
-// validateCredentials checks if the credentials have read-only permissions
+// verifyCredentials checks new credentials before they are added: they must authenticate
+// and, unless allowWrite is set, be unable to modify infrastructure
+func (cm *CredentialManager) verifyCredentials(provider string, creds interface{}, allowWrite bool) error {
+	if cm.verifyAccess != nil {
+		return cm.verifyAccess(provider, creds, allowWrite)
+	}
+	if err := cm.validateCredentials(provider, creds); err != nil {
+		return fmt.Errorf("credential validation failed: %v", err)
+	}
+	return cm.enforceReadOnly(provider, creds, allowWrite)
+}
+
+// validateCredentials checks that the credentials authenticate.
+// Read-only access is verified separately by enforceReadOnly.
 func (cm *CredentialManager) validateCredentials(provider string, creds interface{}) error {
 	switch provider {
 	case "aws":
@@ -294,31 +551,23 @@ func (cm *CredentialManager) validateCredentials(provider string, creds interfac
 	}
 }
 
-// validateAwsCredentials validates AWS credentials without simulating policies
+// validateAwsCredentials validates AWS credentials without simulating policies.
+// For role accounts this assumes the role, so it also verifies the trust policy.
 func (cm *CredentialManager) validateAwsCredentials(creds AWSCredentials) error {
 	ctx := context.Background()
 
-	// Load the AWS configuration
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion(creds.Region),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			creds.AccessKeyID,
-			creds.SecretAccessKey,
-			"",
-		)),
-	)
+	cfg, err := cm.awsConfig(ctx, &creds)
 	if err != nil {
-		return fmt.Errorf("failed to load AWS configuration: %v", err)
+		return err
 	}
 
-	// Create an IAM client
-	iamClient := iam.NewFromConfig(cfg)
-
-	// List IAM users and check if their credentials work
-	input := &iam.ListUsersInput{}
-
-	// This call will not throw an error if the credentials are valid and have the right permissions
-	_, err = iamClient.ListUsers(ctx, input)
+	// Resolve the caller identity through STS; this works for every AWS identity
+	stsClient := sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if creds.STSEndpoint != "" {
+			o.BaseEndpoint = aws.String(creds.STSEndpoint)
+		}
+	})
+	_, err = stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
 	if err != nil {
 		return fmt.Errorf("failed to validate AWS credentials: %v", err)
 	}
@@ -326,17 +575,10 @@ func (cm *CredentialManager) validateAwsCredentials(creds AWSCredentials) error
 	return nil
 }
 
-// validateGcpCredentials validates GCP credentials
+// validateGcpCredentials validates GCP credentials of any supported type by fetching a token
 func (cm *CredentialManager) validateGcpCredentials(creds GCPCloudCredentials) error {
 	ctx := context.Background()
-	credJSON, err := json.MarshalIndent(map[string]interface{}{
-		"type":           "service_account",
-		"project_id":     creds.ProjectID,
-		"private_key_id": creds.PrivateKeyID,
-		"private_key":    creds.PrivateKey,
-		"client_email":   creds.ClientEmail,
-		"client_id":      creds.ClientID,
-	}, "", "  ")
+	credJSON, err := cm.gcpCredentialsJSON(&creds)
 	if err != nil {
 		return err
 	}
@@ -352,7 +594,11 @@ func (cm *CredentialManager) validateGcpCredentials(creds GCPCloudCredentials) e
 
 // validateAzureCredentials validates Azure credentials without simulating policies
 func (cm *CredentialManager) validateAzureCredentials(creds AzureCredentials) error {
-	credential, err := azidentity.NewDefaultAzureCredential(nil)
+	resolved, err := cm.azureAccountCredentials(&creds)
+	if err != nil {
+		return err
+	}
+	credential, err := azureTokenCredential(resolved)
 	if err != nil {
 		return fmt.Errorf("failed to create Azure credential: %v", err)
 	}
@@ -376,6 +622,12 @@ func (cm *CredentialManager) ListAccounts() {
 	fmt.Printf("Configured Cloud Accounts:\n\n")
 	for _, account := range cm.config.Accounts {
 		fmt.Printf("ID: %s\n", account.ID)
+		if account.Alias != "" {
+			fmt.Printf("Alias: %s\n", account.Alias)
+		}
+		if len(account.Labels) > 0 {
+			fmt.Printf("Labels: %s\n", formatLabels(account.Labels))
+		}
 		fmt.Printf("Provider: %s\n", account.Provider)
 		fmt.Printf("Added: %s\n", account.AddedAt.Format(time.RFC3339))
 		fmt.Printf("Last Validated: %s\n", account.LastValidated.Format(time.RFC3339))
@@ -385,8 +637,17 @@ func (cm *CredentialManager) ListAccounts() {
 
 // addCommand adds a cloud account with the credentials.
 func addCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "provider", "config", "credentials")
+	// AWS role accounts chained from a source account have no credentials file
+	if len(args) == 2 && addOptions.SourceAccount != "" {
+		args = append(args, "")
+	}
 	if len(args) != 3 {
-		fmt.Println("Usage: yogaya add <provider-name> <.yogaya/cloud_accounts.conf-file-path> <provider-credentials-file-path>")
+		fmt.Println("Usage: yogaya add <provider-name> [.yogaya/cloud_accounts.conf-file-path] <provider-credentials-file-path>")
+		fmt.Println("       yogaya add aws [.yogaya/cloud_accounts.conf-file-path] --source-account <account-id> --role-arn <role-arn>")
+		fmt.Println("       yogaya add aws-org [.yogaya/cloud_accounts.conf-file-path] <management-credentials-file-path> --role-template <role-name>")
+		fmt.Println("       yogaya add gcp-org [.yogaya/cloud_accounts.conf-file-path] <gcp-credentials-file-path> --parent organizations/<id>")
+		fmt.Println("       yogaya add azure [.yogaya/cloud_accounts.conf-file-path] <azure-credentials-file-path|any> --all-subscriptions")
 		return
 	}
 
@@ -398,11 +659,45 @@ func addCommand(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	if err := cm.AddCredentials(provider, credentialsFile); err != nil {
+	switch provider {
+	case discoveryAWSOrganization:
+		discovery, result, err := cm.AddAWSOrganization(credentialsFile, addOptions)
+		if err != nil {
+			fmt.Printf("Error adding AWS organization: %v\n", err)
+			return
+		}
+		printSyncResult(discovery, result)
+		cm.commitHistory(syncCommitMessage("add", discovery, result))
+		return
+	case discoveryGCPOrganization:
+		discovery, result, err := cm.AddGCPOrganization(credentialsFile, addOptions)
+		if err != nil {
+			fmt.Printf("Error adding GCP projects: %v\n", err)
+			return
+		}
+		printSyncResult(discovery, result)
+		cm.commitHistory(syncCommitMessage("add", discovery, result))
+		return
+	case "azure":
+		if addOptions.AllSubscriptions {
+			discovery, result, err := cm.AddAzureSubscriptions(credentialsFile, addOptions)
+			if err != nil {
+				fmt.Printf("Error adding Azure subscriptions: %v\n", err)
+				return
+			}
+			printSyncResult(discovery, result)
+			cm.commitHistory(syncCommitMessage("add", discovery, result))
+			return
+		}
+	}
+
+	account, err := cm.AddCredentials(provider, credentialsFile, addOptions)
+	if err != nil {
 		fmt.Printf("Error adding credentials: %v\n", err)
 		return
 	}
 
 	fmt.Printf("Successfully added %s account\n", provider)
+	cm.commitHistory(fmt.Sprintf("add: %s account %s", provider, account.displayName()))
 	cm.ListAccounts()
 }
@@ -0,0 +1,461 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/processcreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sso"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const (
+	defaultAWSProfile = "default"
+
+	// Ways the base credentials of an AWS account are obtained
+	awsCredentialStatic  = "static"
+	awsCredentialSSO     = "sso"
+	awsCredentialProcess = "credential_process"
+
+	// defaultAWSRegion is used for STS calls when the account has no region
+	defaultAWSRegion = "us-east-1"
+
+	// maxAWSSourceChain limits how many source accounts are followed when chaining roles
+	maxAWSSourceChain = 5
+)
+
+// awsProfileSource describes where the AWS profile of an added account is read from
+type awsProfileSource struct {
+	CredentialsData []byte
+	ConfigData      []byte
+	Profile         string
+}
+
+// readAWSProfileSource reads the credentials file and the shared AWS config file.
+// The config file is optional unless it was given explicitly.
+func readAWSProfileSource(credentialsPath string, opts AddOptions) (*awsProfileSource, error) {
+	credentialsData, err := os.ReadFile(credentialsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &awsProfileSource{
+		CredentialsData: credentialsData,
+		Profile:         opts.AWSProfile,
+	}
+	if source.Profile == "" {
+		source.Profile = os.Getenv("AWS_PROFILE")
+	}
+	if source.Profile == "" {
+		source.Profile = defaultAWSProfile
+	}
+
+	configPath := opts.AWSConfigFile
+	explicit := configPath != ""
+	if !explicit {
+		configPath = defaultAWSConfigPath()
+	}
+	if configPath != "" {
+		configData, err := os.ReadFile(configPath)
+		if err != nil && (explicit || !os.IsNotExist(err)) {
+			return nil, fmt.Errorf("failed to read AWS config file: %v", err)
+		}
+		source.ConfigData = configData
+	}
+
+	return source, nil
+}
+
+// defaultAWSConfigPath returns the shared AWS config file location
+func defaultAWSConfigPath() string {
+	if path := os.Getenv("AWS_CONFIG_FILE"); path != "" {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".aws", "config")
+}
+
+// parseAWSCredentials parses the selected profile from the AWS credentials file (INI format),
+// merged with the matching section of the AWS config file
+func parseAWSCredentials(source *awsProfileSource) (*AWSCredentials, error) {
+	settings, err := awsProfileSettings(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if settings["mfa_serial"] != "" {
+		return nil, fmt.Errorf("profile %s requires MFA, which is not supported", source.Profile)
+	}
+
+	creds := &AWSCredentials{
+		Profile:         source.Profile,
+		Region:          settings["region"],
+		RoleARN:         settings["role_arn"],
+		ExternalID:      settings["external_id"],
+		RoleSessionName: settings["role_session_name"],
+	}
+
+	// Role profiles take their keys from the source profile
+	keySettings := settings
+	if creds.RoleARN != "" {
+		sourceProfile := settings["source_profile"]
+		if sourceProfile == "" {
+			return nil, fmt.Errorf("profile %s has role_arn but no source_profile", source.Profile)
+		}
+		keySettings, err = awsProfileSettings(&awsProfileSource{
+			CredentialsData: source.CredentialsData,
+			ConfigData:      source.ConfigData,
+			Profile:         sourceProfile,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if keySettings["role_arn"] != "" {
+			return nil, fmt.Errorf("source_profile %s is itself a role profile; add it as its own account and chain with --source-account", sourceProfile)
+		}
+	}
+
+	if err := applyAWSCredentialSource(creds, source, keySettings); err != nil {
+		return nil, fmt.Errorf("profile %s: %v", source.Profile, err)
+	}
+
+	return creds, nil
+}
+
+// applyAWSCredentialSource reads how the base credentials of a profile are obtained:
+// a credential_process command, an IAM Identity Center (SSO) login or static keys.
+// Process and SSO accounts store no secrets; they are resolved each time they are used.
+func applyAWSCredentialSource(creds *AWSCredentials, source *awsProfileSource, settings map[string]string) error {
+	switch {
+	case settings["credential_process"] != "":
+		creds.Type = awsCredentialProcess
+		creds.CredentialProcess = settings["credential_process"]
+
+	case settings["sso_session"] != "" || settings["sso_start_url"] != "":
+		creds.Type = awsCredentialSSO
+		creds.SSOSession = settings["sso_session"]
+		creds.SSOStartURL = settings["sso_start_url"]
+		creds.SSORegion = settings["sso_region"]
+		creds.SSOAccountID = settings["sso_account_id"]
+		creds.SSORoleName = settings["sso_role_name"]
+
+		if creds.SSOSession != "" {
+			session, err := awsSSOSessionSettings(source, creds.SSOSession)
+			if err != nil {
+				return err
+			}
+			creds.SSOStartURL = session["sso_start_url"]
+			creds.SSORegion = session["sso_region"]
+		}
+
+		if creds.SSOStartURL == "" || creds.SSORegion == "" || creds.SSOAccountID == "" || creds.SSORoleName == "" {
+			return fmt.Errorf("incomplete SSO settings: sso_start_url, sso_region, sso_account_id and sso_role_name are required")
+		}
+
+	default:
+		creds.Type = awsCredentialStatic
+		creds.AccessKeyID = settings["aws_access_key_id"]
+		creds.SecretAccessKey = settings["aws_secret_access_key"]
+		creds.SessionToken = settings["aws_session_token"]
+		if creds.SessionToken == "" {
+			creds.SessionToken = settings["aws_security_token"]
+		}
+
+		if creds.AccessKeyID == "" {
+			return fmt.Errorf("no aws_access_key_id")
+		}
+		if creds.SecretAccessKey == "" {
+			return fmt.Errorf("no aws_secret_access_key")
+		}
+	}
+
+	return nil
+}
+
+// awsSSOSessionSettings returns the [sso-session <name>] section of the AWS config file
+func awsSSOSessionSettings(source *awsProfileSource, name string) (map[string]string, error) {
+	for _, data := range [][]byte{source.ConfigData, source.CredentialsData} {
+		sections, err := parseINI(data)
+		if err != nil {
+			return nil, fmt.Errorf("malformed AWS config file: %v", err)
+		}
+		if section, ok := sections["sso-session "+name]; ok {
+			return section, nil
+		}
+	}
+	return nil, fmt.Errorf("sso-session %s not found in AWS config file", name)
+}
+
+// awsProfileSettings merges the settings of a profile from the config and credentials files.
+// Values from the credentials file take precedence, as in the AWS CLI.
+func awsProfileSettings(source *awsProfileSource) (map[string]string, error) {
+	credentialsSections, err := parseINI(source.CredentialsData)
+	if err != nil {
+		return nil, fmt.Errorf("malformed AWS credentials file: %v", err)
+	}
+
+	configSections := map[string]map[string]string{}
+	if len(source.ConfigData) > 0 {
+		configSections, err = parseINI(source.ConfigData)
+		if err != nil {
+			return nil, fmt.Errorf("malformed AWS config file: %v", err)
+		}
+	}
+
+	configSection, inConfig := configSections["profile "+source.Profile]
+	if !inConfig && source.Profile == defaultAWSProfile {
+		configSection, inConfig = configSections[defaultAWSProfile]
+	}
+	credentialsSection, inCredentials := credentialsSections[source.Profile]
+	if !inCredentials {
+		// The given file may be a config file using [profile <name>] sections
+		credentialsSection, inCredentials = credentialsSections["profile "+source.Profile]
+	}
+
+	if !inConfig && !inCredentials {
+		return nil, fmt.Errorf("profile %s not found in AWS credentials or config file", source.Profile)
+	}
+
+	settings := map[string]string{}
+	for key, value := range configSection {
+		settings[key] = value
+	}
+	for key, value := range credentialsSection {
+		settings[key] = value
+	}
+	return settings, nil
+}
+
+// parseINI parses an AWS style INI file into sections of key/value pairs.
+// Indented sub-settings (e.g. the keys below `s3 =`) are skipped.
+func parseINI(data []byte) (map[string]map[string]string, error) {
+	sections := map[string]map[string]string{}
+	var current map[string]string
+	nested := false
+
+	for i, rawLine := range strings.Split(string(data), "\n") {
+		line := strings.TrimSpace(rawLine)
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue // Skip empty lines and comments
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated section header", i+1)
+			}
+			name := strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			if name == "" {
+				return nil, fmt.Errorf("line %d: empty section name", i+1)
+			}
+			if _, ok := sections[name]; !ok {
+				sections[name] = map[string]string{}
+			}
+			current = sections[name]
+			nested = false
+			continue
+		}
+
+		if nested && rawLine != strings.TrimLeft(rawLine, " \t") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected key = value", i+1)
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: setting outside of a section", i+1)
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if key == "" {
+			return nil, fmt.Errorf("line %d: empty key", i+1)
+		}
+		current[key] = value
+		nested = value == ""
+	}
+
+	return sections, nil
+}
+
+// awsConfig builds an AWS SDK configuration for the account credentials.
+// Static keys are used directly; role accounts assume their role with the keys
+// of the account itself or of the referenced source account.
+func (cm *CredentialManager) awsConfig(ctx context.Context, creds *AWSCredentials) (aws.Config, error) {
+	return cm.awsConfigChain(ctx, creds, 0)
+}
+
+func (cm *CredentialManager) awsConfigChain(ctx context.Context, creds *AWSCredentials, depth int) (aws.Config, error) {
+	if depth > maxAWSSourceChain {
+		return aws.Config{}, fmt.Errorf("source account chain is longer than %d accounts", maxAWSSourceChain)
+	}
+
+	region := creds.Region
+	if region == "" {
+		region = defaultAWSRegion
+	}
+
+	var cfg aws.Config
+	if creds.SourceAccountID != "" {
+		source, err := cm.sourceAWSCredentials(creds.SourceAccountID)
+		if err != nil {
+			return aws.Config{}, err
+		}
+		cfg, err = cm.awsConfigChain(ctx, source, depth+1)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("failed to resolve source account %s: %v", creds.SourceAccountID, err)
+		}
+		cfg.Region = region
+	} else {
+		var err error
+		cfg, err = awsBaseConfig(ctx, creds, region)
+		if err != nil {
+			return aws.Config{}, err
+		}
+	}
+
+	if creds.RoleARN == "" {
+		return cfg, nil
+	}
+
+	stsClient := sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if creds.STSEndpoint != "" {
+			o.BaseEndpoint = aws.String(creds.STSEndpoint)
+		}
+	})
+
+	sessionName := creds.RoleSessionName
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("yogaya-%d", time.Now().Unix())
+	}
+
+	provider := stscreds.NewAssumeRoleProvider(stsClient, creds.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = sessionName
+		if creds.ExternalID != "" {
+			o.ExternalID = aws.String(creds.ExternalID)
+		}
+	})
+	cfg.Credentials = aws.NewCredentialsCache(provider)
+
+	return cfg, nil
+}
+
+// sourceAWSCredentials returns the credentials of the account that a role is assumed from
+func (cm *CredentialManager) sourceAWSCredentials(idOrAlias string) (*AWSCredentials, error) {
+	account, err := cm.FindAccount(idOrAlias)
+	if err != nil {
+		return nil, err
+	}
+	if account.Provider != "aws" {
+		return nil, fmt.Errorf("source account %s is not an AWS account", idOrAlias)
+	}
+	if err := cm.loadCredentials(account); err != nil {
+		return nil, err
+	}
+
+	return account.awsCredentials()
+}
+
+// applyAWSRoleOptions sets the assume-role settings given on the command line
+func (cm *CredentialManager) applyAWSRoleOptions(creds *AWSCredentials, opts AddOptions) error {
+	if opts.SourceAccount != "" {
+		source, err := cm.FindAccount(opts.SourceAccount)
+		if err != nil {
+			return err
+		}
+		if source.Provider != "aws" {
+			return fmt.Errorf("source account %s is not an AWS account", opts.SourceAccount)
+		}
+		creds.SourceAccountID = source.ID
+	}
+	if opts.RoleARN != "" {
+		creds.RoleARN = opts.RoleARN
+	}
+	if opts.ExternalID != "" {
+		creds.ExternalID = opts.ExternalID
+	}
+	if opts.RoleSessionName != "" {
+		creds.RoleSessionName = opts.RoleSessionName
+	}
+	if opts.STSEndpoint != "" {
+		creds.STSEndpoint = opts.STSEndpoint
+	}
+	if opts.Region != "" {
+		creds.Region = opts.Region
+	}
+
+	if creds.SourceAccountID != "" && creds.RoleARN == "" {
+		return fmt.Errorf("--source-account requires --role-arn")
+	}
+	return nil
+}
+
+// awsBaseConfig builds the configuration for the base credentials of an account,
+// before any role is assumed
+func awsBaseConfig(ctx context.Context, creds *AWSCredentials, region string) (aws.Config, error) {
+	var provider aws.CredentialsProvider
+
+	switch creds.Type {
+	case awsCredentialProcess:
+		provider = processcreds.NewProvider(creds.CredentialProcess)
+
+	case awsCredentialSSO:
+		ssoCfg, err := config.LoadDefaultConfig(ctx,
+			config.WithRegion(creds.SSORegion),
+			config.WithCredentialsProvider(aws.AnonymousCredentials{}),
+		)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("failed to load AWS SSO configuration: %v", err)
+		}
+
+		// The token cached by `aws sso login` is keyed by the session name, or by the start URL for legacy profiles
+		cacheKey := creds.SSOStartURL
+		if creds.SSOSession != "" {
+			cacheKey = creds.SSOSession
+		}
+		tokenPath, err := ssocreds.StandardCachedTokenFilepath(cacheKey)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("failed to locate the AWS SSO token cache: %v", err)
+		}
+
+		provider = ssocreds.New(sso.NewFromConfig(ssoCfg), creds.SSOAccountID, creds.SSORoleName, creds.SSOStartURL, func(o *ssocreds.Options) {
+			o.CachedTokenFilepath = tokenPath
+			if creds.SSOSession != "" {
+				o.SSOTokenProvider = ssocreds.NewSSOTokenProvider(ssooidc.NewFromConfig(ssoCfg), tokenPath)
+			}
+		})
+
+	default:
+		provider = credentials.NewStaticCredentialsProvider(
+			creds.AccessKeyID,
+			creds.SecretAccessKey,
+			creds.SessionToken,
+		)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(region),
+		config.WithCredentialsProvider(provider),
+	)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS configuration: %v", err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,552 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stsCall is one request received by stsStub
+type stsCall struct {
+	Action string
+	// AccessKeyID is the key that signed the request
+	AccessKeyID string
+	RoleARN     string
+	ExternalID  string
+}
+
+// stsStub is an STS endpoint that issues temporary credentials named after the assumed
+// role, so the key that signed each request shows which step of a role chain sent it
+type stsStub struct {
+	// denied lists the actions and role ARNs that are refused with AccessDenied
+	denied map[string]bool
+
+	mu    sync.Mutex
+	calls []stsCall
+}
+
+func (s *stsStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	call := stsCall{
+		Action:      r.Form.Get("Action"),
+		AccessKeyID: signingAccessKey(r.Header.Get("Authorization")),
+		RoleARN:     r.Form.Get("RoleArn"),
+		ExternalID:  r.Form.Get("ExternalId"),
+	}
+	s.mu.Lock()
+	s.calls = append(s.calls, call)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/xml")
+	if s.denied[call.Action] || s.denied[call.RoleARN] {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><Error><Type>Sender</Type><Code>AccessDenied</Code><Message>not authorized</Message></Error><RequestId>stub</RequestId></ErrorResponse>`)
+		return
+	}
+
+	switch call.Action {
+	case "AssumeRole":
+		role := call.RoleARN[strings.LastIndex(call.RoleARN, "/")+1:]
+		fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials><AccessKeyId>ASIA-%s</AccessKeyId><SecretAccessKey>secret-%s</SecretAccessKey><SessionToken>token-%s</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration></Credentials><AssumedRoleUser><Arn>%s/session</Arn><AssumedRoleId>AROA:session</AssumedRoleId></AssumedRoleUser></AssumeRoleResult><ResponseMetadata><RequestId>stub</RequestId></ResponseMetadata></AssumeRoleResponse>`, role, role, role, call.RoleARN)
+	case "GetCallerIdentity":
+		fmt.Fprint(w, `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><GetCallerIdentityResult><Arn>arn:aws:sts::123456789012:assumed-role/stub/session</Arn><UserId>AROA:session</UserId><Account>123456789012</Account></GetCallerIdentityResult><ResponseMetadata><RequestId>stub</RequestId></ResponseMetadata></GetCallerIdentityResponse>`)
+	default:
+		http.Error(w, "unsupported action "+call.Action, http.StatusBadRequest)
+	}
+}
+
+// signingAccessKey returns the access key of a SigV4 Authorization header
+func signingAccessKey(authorization string) string {
+	_, credential, ok := strings.Cut(authorization, "Credential=")
+	if !ok {
+		return ""
+	}
+	key, _, _ := strings.Cut(credential, "/")
+	return key
+}
+
+// isolateAWSEnvironment keeps the SDK from reading the shared files and environment of the machine
+func isolateAWSEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	for _, name := range []string{"AWS_PROFILE", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_ENDPOINT_URL", "AWS_ENDPOINT_URL_STS"} {
+		t.Setenv(name, "")
+	}
+}
+
+func staticAWSAccount(id, endpoint string) CloudAccount {
+	return CloudAccount{ID: id, Provider: "aws", Credentials: &AWSCredentials{
+		AccessKeyID:     "AKID-" + id,
+		SecretAccessKey: "secret",
+		Region:          "us-east-1",
+		STSEndpoint:     endpoint,
+	}}
+}
+
+func roleAWSCredentials(role, source, endpoint string) *AWSCredentials {
+	return &AWSCredentials{
+		RoleARN:         "arn:aws:iam::123456789012:role/" + role,
+		RoleSessionName: "yogaya-test",
+		SourceAccountID: source,
+		Region:          "us-east-1",
+		STSEndpoint:     endpoint,
+	}
+}
+
+func roleAWSAccount(id, source, endpoint string) CloudAccount {
+	return CloudAccount{ID: id, Provider: "aws", Credentials: roleAWSCredentials(id, source, endpoint)}
+}
+
+// sourceChain returns n source accounts: s0 with static keys and s1..s(n-1) assuming a
+// role with the credentials of the previous one
+func sourceChain(n int, endpoint string) []CloudAccount {
+	accounts := []CloudAccount{staticAWSAccount("s0", endpoint)}
+	for i := 1; i < n; i++ {
+		accounts = append(accounts, roleAWSAccount(fmt.Sprintf("s%d", i), fmt.Sprintf("s%d", i-1), endpoint))
+	}
+	return accounts
+}
+
+func roleARN(role string) string {
+	return "arn:aws:iam::123456789012:role/" + role
+}
+
+func TestValidateAwsCredentialsThroughSTSEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		accounts func(endpoint string) []CloudAccount
+		creds    func(endpoint string) *AWSCredentials
+		denied   []string
+		// wantCalls is nil when the calls are not checked
+		wantCalls []stsCall
+		wantErr   string
+	}{
+		{
+			name: "static keys",
+			creds: func(endpoint string) *AWSCredentials {
+				return staticAWSAccount("base", endpoint).Credentials.(*AWSCredentials)
+			},
+			wantCalls: []stsCall{{Action: "GetCallerIdentity", AccessKeyID: "AKID-base"}},
+		},
+		{
+			name: "role assumed with the keys of the account",
+			creds: func(endpoint string) *AWSCredentials {
+				creds := staticAWSAccount("base", endpoint).Credentials.(*AWSCredentials)
+				creds.RoleARN = roleARN("reader")
+				creds.ExternalID = "ext-1"
+				return creds
+			},
+			wantCalls: []stsCall{
+				{Action: "AssumeRole", AccessKeyID: "AKID-base", RoleARN: roleARN("reader"), ExternalID: "ext-1"},
+				{Action: "GetCallerIdentity", AccessKeyID: "ASIA-reader"},
+			},
+		},
+		{
+			name: "role chained through source accounts",
+			accounts: func(endpoint string) []CloudAccount {
+				return []CloudAccount{staticAWSAccount("base", endpoint), roleAWSAccount("hub", "base", endpoint)}
+			},
+			creds: func(endpoint string) *AWSCredentials {
+				return roleAWSCredentials("member", "hub", endpoint)
+			},
+			wantCalls: []stsCall{
+				{Action: "AssumeRole", AccessKeyID: "AKID-base", RoleARN: roleARN("hub")},
+				{Action: "AssumeRole", AccessKeyID: "ASIA-hub", RoleARN: roleARN("member")},
+				{Action: "GetCallerIdentity", AccessKeyID: "ASIA-member"},
+			},
+		},
+		{
+			name:     "longest allowed source chain",
+			accounts: func(endpoint string) []CloudAccount { return sourceChain(maxAWSSourceChain, endpoint) },
+			creds: func(endpoint string) *AWSCredentials {
+				return roleAWSCredentials("member", fmt.Sprintf("s%d", maxAWSSourceChain-1), endpoint)
+			},
+		},
+		{
+			name:     "source chain above the limit",
+			accounts: func(endpoint string) []CloudAccount { return sourceChain(maxAWSSourceChain+1, endpoint) },
+			creds: func(endpoint string) *AWSCredentials {
+				return roleAWSCredentials("member", fmt.Sprintf("s%d", maxAWSSourceChain), endpoint)
+			},
+			wantCalls: []stsCall{},
+			wantErr:   fmt.Sprintf("source account chain is longer than %d accounts", maxAWSSourceChain),
+		},
+		{
+			name: "source accounts referencing each other",
+			accounts: func(endpoint string) []CloudAccount {
+				return []CloudAccount{roleAWSAccount("a", "b", endpoint), roleAWSAccount("b", "a", endpoint)}
+			},
+			creds: func(endpoint string) *AWSCredentials {
+				return roleAWSCredentials("member", "a", endpoint)
+			},
+			wantCalls: []stsCall{},
+			wantErr:   "source account chain is longer than",
+		},
+		{
+			name: "missing source account",
+			creds: func(endpoint string) *AWSCredentials {
+				return roleAWSCredentials("member", "missing", endpoint)
+			},
+			wantCalls: []stsCall{},
+			wantErr:   "account not found: missing",
+		},
+		{
+			name: "source account of another provider",
+			accounts: func(endpoint string) []CloudAccount {
+				return []CloudAccount{{ID: "project", Provider: "gcp", Credentials: &GCPCloudCredentials{ProjectID: "project"}}}
+			},
+			creds: func(endpoint string) *AWSCredentials {
+				return roleAWSCredentials("member", "project", endpoint)
+			},
+			wantCalls: []stsCall{},
+			wantErr:   "source account project is not an AWS account",
+		},
+		{
+			name: "role refused",
+			accounts: func(endpoint string) []CloudAccount {
+				return []CloudAccount{staticAWSAccount("base", endpoint)}
+			},
+			creds: func(endpoint string) *AWSCredentials {
+				return roleAWSCredentials("member", "base", endpoint)
+			},
+			denied: []string{roleARN("member")},
+			wantCalls: []stsCall{
+				{Action: "AssumeRole", AccessKeyID: "AKID-base", RoleARN: roleARN("member")},
+			},
+			wantErr: "AccessDenied",
+		},
+		{
+			name: "role of a source account refused",
+			accounts: func(endpoint string) []CloudAccount {
+				return []CloudAccount{staticAWSAccount("base", endpoint), roleAWSAccount("hub", "base", endpoint)}
+			},
+			creds: func(endpoint string) *AWSCredentials {
+				return roleAWSCredentials("member", "hub", endpoint)
+			},
+			denied: []string{roleARN("hub")},
+			wantCalls: []stsCall{
+				{Action: "AssumeRole", AccessKeyID: "AKID-base", RoleARN: roleARN("hub")},
+			},
+			wantErr: "AccessDenied",
+		},
+		{
+			name: "caller identity refused",
+			creds: func(endpoint string) *AWSCredentials {
+				return staticAWSAccount("base", endpoint).Credentials.(*AWSCredentials)
+			},
+			denied:    []string{"GetCallerIdentity"},
+			wantCalls: []stsCall{{Action: "GetCallerIdentity", AccessKeyID: "AKID-base"}},
+			wantErr:   "failed to validate AWS credentials",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolateAWSEnvironment(t)
+
+			stub := &stsStub{denied: map[string]bool{}}
+			for _, denied := range tt.denied {
+				stub.denied[denied] = true
+			}
+			server := httptest.NewServer(stub)
+			defer server.Close()
+
+			cm := &CredentialManager{config: CloudAccountsConfig{Accounts: []CloudAccount{}}}
+			if tt.accounts != nil {
+				cm.config.Accounts = tt.accounts(server.URL)
+			}
+
+			err := cm.validateAwsCredentials(*tt.creds(server.URL))
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("validateAwsCredentials() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("validateAwsCredentials() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+
+			if tt.wantCalls != nil {
+				stub.mu.Lock()
+				calls := append([]stsCall{}, stub.calls...)
+				stub.mu.Unlock()
+				if !reflect.DeepEqual(calls, tt.wantCalls) {
+					t.Errorf("STS calls = %+v, want %+v", calls, tt.wantCalls)
+				}
+			}
+		})
+	}
+}
+
+func TestAwsConfigChainRetrievesAssumedCredentials(t *testing.T) {
+	isolateAWSEnvironment(t)
+
+	stub := &stsStub{denied: map[string]bool{}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	cm := &CredentialManager{config: CloudAccountsConfig{Accounts: sourceChain(3, server.URL)}}
+	cfg, err := cm.awsConfig(context.Background(), roleAWSCredentials("member", "s2", server.URL))
+	if err != nil {
+		t.Fatalf("awsConfig() error = %v", err)
+	}
+
+	value, err := cfg.Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if value.AccessKeyID != "ASIA-member" || value.SessionToken != "token-member" {
+		t.Errorf("credentials = %s/%s, want the ones issued for role member", value.AccessKeyID, value.SessionToken)
+	}
+
+	want := []stsCall{
+		{Action: "AssumeRole", AccessKeyID: "AKID-s0", RoleARN: roleARN("s1")},
+		{Action: "AssumeRole", AccessKeyID: "ASIA-s1", RoleARN: roleARN("s2")},
+		{Action: "AssumeRole", AccessKeyID: "ASIA-s2", RoleARN: roleARN("member")},
+	}
+	if !reflect.DeepEqual(stub.calls, want) {
+		t.Errorf("STS calls = %+v, want %+v", stub.calls, want)
+	}
+}
+
+func TestParseINI(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]map[string]string
+		wantErr string
+	}{
+		{
+			name: "sections, comments and spacing",
+			data: "# comment\n; other comment\n[default]\naws_access_key_id = AKID\n\n[profile   dev ]\r\nregion=eu-west-1\r\n",
+			want: map[string]map[string]string{
+				"default":     {"aws_access_key_id": "AKID"},
+				"profile dev": {"region": "eu-west-1"},
+			},
+		},
+		{
+			name: "repeated section is merged",
+			data: "[dev]\nregion = us-east-1\n[other]\n[dev]\nregion = eu-west-1\noutput = json\n",
+			want: map[string]map[string]string{
+				"dev":   {"region": "eu-west-1", "output": "json"},
+				"other": {},
+			},
+		},
+		{
+			name: "indented sub-settings are skipped",
+			data: "[dev]\ns3 =\n  max_concurrent_requests = 20\n\tsignature_version = s3v4\nregion = eu-west-1\n",
+			want: map[string]map[string]string{
+				"dev": {"s3": "", "region": "eu-west-1"},
+			},
+		},
+		{
+			name: "value containing an equals sign",
+			data: "[dev]\ncredential_process = tool --arg=value\n",
+			want: map[string]map[string]string{
+				"dev": {"credential_process": "tool --arg=value"},
+			},
+		},
+		{name: "unterminated section header", data: "[dev\n", wantErr: "line 1: unterminated section header"},
+		{name: "empty section name", data: "[ ]\n", wantErr: "line 1: empty section name"},
+		{name: "line without equals sign", data: "[dev]\nregion\n", wantErr: "line 2: expected key = value"},
+		{name: "setting outside of a section", data: "region = eu-west-1\n", wantErr: "line 1: setting outside of a section"},
+		{name: "empty key", data: "[dev]\n = value\n", wantErr: "line 2: empty key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseINI([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseINI() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseINI() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseINI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAWSCredentials(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials string
+		config      string
+		profile     string
+		want        *AWSCredentials
+		wantErr     string
+	}{
+		{
+			name:        "default profile with static keys",
+			credentials: "[default]\naws_access_key_id = AKID\naws_secret_access_key = secret\naws_session_token = token\n",
+			config:      "[default]\nregion = eu-west-1\n",
+			profile:     "default",
+			want: &AWSCredentials{Type: awsCredentialStatic, Profile: "default", Region: "eu-west-1",
+				AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"},
+		},
+		{
+			name:        "credentials file takes precedence over config file",
+			credentials: "[dev]\naws_access_key_id = AKID\naws_secret_access_key = secret\nregion = us-west-2\n",
+			config:      "[profile dev]\nregion = eu-west-1\n",
+			profile:     "dev",
+			want: &AWSCredentials{Type: awsCredentialStatic, Profile: "dev", Region: "us-west-2",
+				AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		},
+		{
+			name:        "legacy security token",
+			credentials: "[dev]\naws_access_key_id = AKID\naws_secret_access_key = secret\naws_security_token = legacy\n",
+			profile:     "dev",
+			want: &AWSCredentials{Type: awsCredentialStatic, Profile: "dev",
+				AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "legacy"},
+		},
+		{
+			name:    "keys in the config file",
+			config:  "[profile dev]\naws_access_key_id = AKID\naws_secret_access_key = secret\nregion = eu-west-1\n",
+			profile: "dev",
+			want: &AWSCredentials{Type: awsCredentialStatic, Profile: "dev", Region: "eu-west-1",
+				AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		},
+		{
+			name:        "config file given as credentials file",
+			credentials: "[profile dev]\naws_access_key_id = AKID\naws_secret_access_key = secret\n",
+			profile:     "dev",
+			want: &AWSCredentials{Type: awsCredentialStatic, Profile: "dev",
+				AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		},
+		{
+			name:        "role profile takes the keys of its source profile",
+			credentials: "[base]\naws_access_key_id = AKID\naws_secret_access_key = secret\n",
+			config: "[profile base]\nregion = us-east-1\n" +
+				"[profile reader]\nrole_arn = arn:aws:iam::123456789012:role/reader\nsource_profile = base\n" +
+				"external_id = ext-1\nrole_session_name = audit\nregion = eu-west-1\n",
+			profile: "reader",
+			want: &AWSCredentials{Type: awsCredentialStatic, Profile: "reader", Region: "eu-west-1",
+				RoleARN: "arn:aws:iam::123456789012:role/reader", ExternalID: "ext-1", RoleSessionName: "audit",
+				AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		},
+		{
+			name:        "role profile with a credential_process source",
+			credentials: "[base]\ncredential_process = vault-aws --role base\n",
+			config:      "[profile reader]\nrole_arn = arn:aws:iam::123456789012:role/reader\nsource_profile = base\n",
+			profile:     "reader",
+			want: &AWSCredentials{Type: awsCredentialProcess, Profile: "reader",
+				RoleARN: "arn:aws:iam::123456789012:role/reader", CredentialProcess: "vault-aws --role base"},
+		},
+		{
+			name: "sso session profile",
+			config: "[profile sso]\nsso_session = corp\nsso_account_id = 123456789012\nsso_role_name = ReadOnly\n" +
+				"[sso-session corp]\nsso_start_url = https://corp.awsapps.com/start\nsso_region = eu-west-1\n",
+			profile: "sso",
+			want: &AWSCredentials{Type: awsCredentialSSO, Profile: "sso", SSOSession: "corp",
+				SSOStartURL: "https://corp.awsapps.com/start", SSORegion: "eu-west-1",
+				SSOAccountID: "123456789012", SSORoleName: "ReadOnly"},
+		},
+		{
+			name:    "incomplete sso profile",
+			config:  "[profile sso]\nsso_start_url = https://corp.awsapps.com/start\nsso_region = eu-west-1\n",
+			profile: "sso",
+			wantErr: "profile sso: incomplete SSO settings",
+		},
+		{
+			name:    "missing sso session",
+			config:  "[profile sso]\nsso_session = corp\n",
+			profile: "sso",
+			wantErr: "sso-session corp not found",
+		},
+		{
+			name:        "role profile without source profile",
+			credentials: "[reader]\nrole_arn = arn:aws:iam::123456789012:role/reader\n",
+			profile:     "reader",
+			wantErr:     "profile reader has role_arn but no source_profile",
+		},
+		{
+			name:        "source profile that is a role profile",
+			credentials: "[base]\nrole_arn = arn:aws:iam::123456789012:role/base\nsource_profile = root\n",
+			config:      "[profile reader]\nrole_arn = arn:aws:iam::123456789012:role/reader\nsource_profile = base\n",
+			profile:     "reader",
+			wantErr:     "source_profile base is itself a role profile",
+		},
+		{
+			name:    "missing source profile",
+			config:  "[profile reader]\nrole_arn = arn:aws:iam::123456789012:role/reader\nsource_profile = base\n",
+			profile: "reader",
+			wantErr: "profile base not found",
+		},
+		{
+			name:        "missing profile",
+			credentials: "[default]\naws_access_key_id = AKID\naws_secret_access_key = secret\n",
+			profile:     "dev",
+			wantErr:     "profile dev not found",
+		},
+		{
+			name:        "mfa profile",
+			credentials: "[dev]\naws_access_key_id = AKID\naws_secret_access_key = secret\nmfa_serial = arn:aws:iam::123456789012:mfa/user\n",
+			profile:     "dev",
+			wantErr:     "profile dev requires MFA",
+		},
+		{
+			name:        "missing access key",
+			credentials: "[dev]\nregion = eu-west-1\n",
+			profile:     "dev",
+			wantErr:     "profile dev: no aws_access_key_id",
+		},
+		{
+			name:        "missing secret key",
+			credentials: "[dev]\naws_access_key_id = AKID\n",
+			profile:     "dev",
+			wantErr:     "profile dev: no aws_secret_access_key",
+		},
+		{
+			name:        "malformed credentials file",
+			credentials: "[dev\n",
+			profile:     "dev",
+			wantErr:     "malformed AWS credentials file",
+		},
+		{
+			name:        "malformed config file",
+			credentials: "[dev]\naws_access_key_id = AKID\naws_secret_access_key = secret\n",
+			config:      "[profile dev\n",
+			profile:     "dev",
+			wantErr:     "malformed AWS config file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAWSCredentials(&awsProfileSource{
+				CredentialsData: []byte(tt.credentials),
+				ConfigData:      []byte(tt.config),
+				Profile:         tt.profile,
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseAWSCredentials() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAWSCredentials() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAWSCredentials() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
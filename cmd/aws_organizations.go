@@ -0,0 +1,201 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// AddAWSOrganization registers the member accounts of an AWS organization as assume-role
+// accounts chained from the management (or delegated administrator) account.
+// The management account is added from credentialsPath unless --source-account names it.
+func (cm *CredentialManager) AddAWSOrganization(credentialsPath string, opts AddOptions) (*Discovery, *SyncResult, error) {
+	if opts.RoleTemplate == "" {
+		return nil, nil, fmt.Errorf("aws-org requires --role-template, the role to assume in each member account (e.g. YogayaReadOnly)")
+	}
+
+	source, err := cm.discoverySource("aws", credentialsPath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx := context.Background()
+	client, err := cm.awsOrganizationsClient(ctx, source.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	org, err := client.DescribeOrganization(ctx, &organizations.DescribeOrganizationInput{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to describe the organization: %v", err)
+	}
+
+	return cm.addDiscovery(Discovery{
+		ID:              aws.ToString(org.Organization.Id),
+		Kind:            discoveryAWSOrganization,
+		SourceAccountID: source.ID,
+		RoleTemplate:    opts.RoleTemplate,
+		ExternalID:      opts.ExternalID,
+		Region:          opts.Region,
+		AllowWrite:      opts.AllowWrite,
+	}, opts.Sync)
+}
+
+// awsOrganizationsClient returns an Organizations client with the credentials of a configured account.
+// The Organizations API is only served from us-east-1.
+func (cm *CredentialManager) awsOrganizationsClient(ctx context.Context, accountID string) (*organizations.Client, error) {
+	account, err := cm.FindAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if err := cm.loadCredentials(account); err != nil {
+		return nil, err
+	}
+	creds, err := account.awsCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := cm.awsConfig(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+	return organizations.NewFromConfig(cfg, func(o *organizations.Options) {
+		o.Region = defaultAWSRegion
+	}), nil
+}
+
+// listAWSOrganizationAccounts walks the organization tree and returns every member
+// account with its OU path, except the account the listing runs as
+func (cm *CredentialManager) listAWSOrganizationAccounts(discovery *Discovery) ([]discoveredAccount, error) {
+	ctx := context.Background()
+	client, err := cm.awsOrganizationsClient(ctx, discovery.SourceAccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	callerAccountID, err := cm.awsCallerAccountID(ctx, discovery.SourceAccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := []types.Root{}
+	rootPages := organizations.NewListRootsPaginator(client, &organizations.ListRootsInput{})
+	for rootPages.HasMorePages() {
+		page, err := rootPages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list organization roots: %v", err)
+		}
+		roots = append(roots, page.Roots...)
+	}
+
+	members := []discoveredAccount{}
+	for _, root := range roots {
+		if err := walkAWSOrganizationUnit(ctx, client, aws.ToString(root.Id), aws.ToString(root.Name), func(account types.Account, ouPath string) {
+			accountID := aws.ToString(account.Id)
+			if accountID == callerAccountID {
+				return
+			}
+			members = append(members, discoveredAccount{
+				ProviderAccountID: accountID,
+				Name:              aws.ToString(account.Name),
+				Labels: map[string]string{
+					"ou":             ouPath,
+					"aws-account-id": accountID,
+				},
+				Active:      account.Status == types.AccountStatusActive,
+				Credentials: awsMemberCredentials(discovery, accountID),
+			})
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Found %d member accounts in organization %s", len(members), discovery.ID)
+	return members, nil
+}
+
+// walkAWSOrganizationUnit calls visit for every account below a root or OU
+func walkAWSOrganizationUnit(ctx context.Context, client *organizations.Client, parentID, path string, visit func(types.Account, string)) error {
+	accountPages := organizations.NewListAccountsForParentPaginator(client, &organizations.ListAccountsForParentInput{ParentId: aws.String(parentID)})
+	for accountPages.HasMorePages() {
+		page, err := accountPages.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list accounts of %s: %v", path, err)
+		}
+		for _, account := range page.Accounts {
+			visit(account, path)
+		}
+	}
+
+	units := []types.OrganizationalUnit{}
+	unitPages := organizations.NewListOrganizationalUnitsForParentPaginator(client, &organizations.ListOrganizationalUnitsForParentInput{ParentId: aws.String(parentID)})
+	for unitPages.HasMorePages() {
+		page, err := unitPages.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list organizational units of %s: %v", path, err)
+		}
+		units = append(units, page.OrganizationalUnits...)
+	}
+
+	for _, unit := range units {
+		if err := walkAWSOrganizationUnit(ctx, client, aws.ToString(unit.Id), path+"/"+aws.ToString(unit.Name), visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// awsCallerAccountID returns the AWS account ID of a configured account
+func (cm *CredentialManager) awsCallerAccountID(ctx context.Context, accountID string) (string, error) {
+	account, err := cm.FindAccount(accountID)
+	if err != nil {
+		return "", err
+	}
+	creds, err := account.awsCredentials()
+	if err != nil {
+		return "", err
+	}
+	cfg, err := cm.awsConfig(ctx, creds)
+	if err != nil {
+		return "", err
+	}
+
+	stsClient := sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if creds.STSEndpoint != "" {
+			o.BaseEndpoint = aws.String(creds.STSEndpoint)
+		}
+	})
+	identity, err := stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	return aws.ToString(identity.Account), nil
+}
+
+// awsMemberCredentials returns the assume-role credentials of a member account
+func awsMemberCredentials(discovery *Discovery, accountID string) *AWSCredentials {
+	return &AWSCredentials{
+		RoleARN:         awsMemberRoleARN(discovery.RoleTemplate, accountID),
+		ExternalID:      discovery.ExternalID,
+		SourceAccountID: discovery.SourceAccountID,
+		Region:          discovery.Region,
+	}
+}
+
+// awsMemberRoleARN expands the role template for a member account. A template without
+// {account_id} is a role name, e.g. "YogayaReadOnly".
+func awsMemberRoleARN(template, accountID string) string {
+	if !strings.Contains(template, "{account_id}") {
+		template = "arn:aws:iam::{account_id}:role/" + template
+	}
+	return strings.ReplaceAll(template, "{account_id}", accountID)
+}
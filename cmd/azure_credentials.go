@@ -0,0 +1,133 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// Ways an Azure account authenticates
+const (
+	azureAuthCLI               = "cli"
+	azureAuthClientSecret      = "client_secret"
+	azureAuthClientCertificate = "client_certificate"
+)
+
+// azureServicePrincipalFile accepts both the output of `az ad sp create-for-rbac`
+// (appId, password, tenant) and explicitly named fields
+type azureServicePrincipalFile struct {
+	AppID    string `json:"appId"`
+	Password string `json:"password"`
+	Tenant   string `json:"tenant"`
+
+	ClientID                  string `json:"client_id"`
+	ClientSecret              string `json:"client_secret"`
+	ClientCertificatePath     string `json:"client_certificate_path"`
+	ClientCertificatePassword string `json:"client_certificate_password"`
+	TenantID                  string `json:"tenant_id"`
+	SubscriptionID            string `json:"subscription_id"`
+	Environment               string `json:"environment"`
+}
+
+// parseAzureServicePrincipal parses a service principal file in JSON format
+func parseAzureServicePrincipal(data []byte, opts AddOptions) (*AzureCredentials, error) {
+	var sp azureServicePrincipalFile
+	if err := json.Unmarshal(data, &sp); err != nil {
+		return nil, fmt.Errorf("unable to decode Azure service principal JSON: %v", err)
+	}
+
+	creds := &AzureCredentials{
+		ClientID:                  firstNonEmpty(sp.ClientID, sp.AppID),
+		ClientSecret:              firstNonEmpty(sp.ClientSecret, sp.Password),
+		ClientCertificatePath:     sp.ClientCertificatePath,
+		ClientCertificatePassword: sp.ClientCertificatePassword,
+		TenantID:                  firstNonEmpty(sp.TenantID, sp.Tenant),
+		SubscriptionID:            firstNonEmpty(opts.AzureSubscription, sp.SubscriptionID),
+		Environment:               firstNonEmpty(sp.Environment, "AzureCloud"),
+	}
+
+	switch {
+	case creds.ClientCertificatePath != "":
+		creds.AuthType = azureAuthClientCertificate
+		creds.ClientSecret = ""
+	case creds.ClientSecret != "":
+		creds.AuthType = azureAuthClientSecret
+	default:
+		return nil, fmt.Errorf("service principal file has neither a client secret nor a client certificate")
+	}
+
+	if creds.ClientID == "" {
+		return nil, fmt.Errorf("service principal file has no client_id (appId)")
+	}
+	if creds.TenantID == "" {
+		return nil, fmt.Errorf("service principal file has no tenant_id (tenant)")
+	}
+	if creds.SubscriptionID == "" {
+		return nil, fmt.Errorf("service principal file has no subscription_id; pass --subscription")
+	}
+	creds.Name = creds.SubscriptionID
+
+	return creds, nil
+}
+
+// azureTokenCredential returns the Azure credential for the account's authentication type
+func azureTokenCredential(creds *AzureCredentials) (azcore.TokenCredential, error) {
+	switch creds.AuthType {
+	case azureAuthClientSecret:
+		return azidentity.NewClientSecretCredential(creds.TenantID, creds.ClientID, creds.ClientSecret, nil)
+	case azureAuthClientCertificate:
+		data, err := os.ReadFile(creds.ClientCertificatePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client certificate: %v", err)
+		}
+		certs, key, err := azidentity.ParseCertificates(data, []byte(creds.ClientCertificatePassword))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse client certificate: %v", err)
+		}
+		return azidentity.NewClientCertificateCredential(creds.TenantID, creds.ClientID, certs, key, nil)
+	case "", azureAuthCLI:
+		return azidentity.NewDefaultAzureCredential(nil)
+	default:
+		return nil, fmt.Errorf("unsupported Azure authentication type: %s", creds.AuthType)
+	}
+}
+
+// terraformerEnv returns the ARM_* variables that authenticate Terraformer as the account
+func (creds *AzureCredentials) terraformerEnv() []string {
+	env := []string{
+		"ARM_SUBSCRIPTION_ID=" + creds.SubscriptionID,
+		"ARM_TENANT_ID=" + creds.TenantID,
+	}
+
+	switch creds.AuthType {
+	case azureAuthClientSecret:
+		env = append(env,
+			"ARM_CLIENT_ID="+creds.ClientID,
+			"ARM_CLIENT_SECRET="+creds.ClientSecret)
+	case azureAuthClientCertificate:
+		env = append(env,
+			"ARM_CLIENT_ID="+creds.ClientID,
+			"ARM_CLIENT_CERTIFICATE_PATH="+creds.ClientCertificatePath)
+		if creds.ClientCertificatePassword != "" {
+			env = append(env, "ARM_CLIENT_CERTIFICATE_PASSWORD="+creds.ClientCertificatePassword)
+		}
+	}
+
+	return env
+}
+
+// firstNonEmpty returns the first of the values that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,226 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+)
+
+// AddAzureSubscriptions registers every subscription of the tenant that the identity can see
+// as its own account, optionally limited to a management group and a name pattern.
+// The subscriptions share the credentials of the account that lists them.
+func (cm *CredentialManager) AddAzureSubscriptions(credentialsPath string, opts AddOptions) (*Discovery, *SyncResult, error) {
+	if _, err := path.Match(opts.SubscriptionPattern, ""); err != nil {
+		return nil, nil, fmt.Errorf("invalid --subscription-pattern %q: %v", opts.SubscriptionPattern, err)
+	}
+
+	source, err := cm.discoverySource("azure", credentialsPath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cm.loadCredentials(source); err != nil {
+		return nil, nil, err
+	}
+	sourceCreds, err := source.azureCredentials()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id := sourceCreds.TenantID
+	if opts.ManagementGroup != "" {
+		id = sourceCreds.TenantID + "/" + opts.ManagementGroup
+	}
+
+	return cm.addDiscovery(Discovery{
+		ID:              id,
+		Kind:            discoveryAzureSubscriptions,
+		SourceAccountID: source.ID,
+		ManagementGroup: opts.ManagementGroup,
+		NamePattern:     opts.SubscriptionPattern,
+		AllowWrite:      opts.AllowWrite,
+	}, opts.Sync)
+}
+
+// azureAccountCredentials returns the credentials of an Azure account with the secrets
+// of its source account filled in when it shares that account's credentials
+func (cm *CredentialManager) azureAccountCredentials(creds *AzureCredentials) (*AzureCredentials, error) {
+	if creds.SourceAccountID == "" {
+		return creds, nil
+	}
+
+	source, err := cm.FindAccount(creds.SourceAccountID)
+	if err != nil {
+		return nil, fmt.Errorf("source account of Azure subscription %s: %v", creds.SubscriptionID, err)
+	}
+	if err := cm.loadCredentials(source); err != nil {
+		return nil, err
+	}
+	sourceCreds, err := source.azureCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if sourceCreds.SourceAccountID != "" {
+		return nil, fmt.Errorf("source account %s shares the credentials of another account", source.ID)
+	}
+
+	resolved := *sourceCreds
+	resolved.SubscriptionID = creds.SubscriptionID
+	resolved.Name = creds.Name
+	return &resolved, nil
+}
+
+// azureSubscriptionList is the list response of /subscriptions
+type azureSubscriptionList struct {
+	Value []struct {
+		SubscriptionID string `json:"subscriptionId"`
+		TenantID       string `json:"tenantId"`
+		DisplayName    string `json:"displayName"`
+		State          string `json:"state"`
+	} `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+// azureManagementGroupDescendants is the list response of a management group's descendants
+type azureManagementGroupDescendants struct {
+	Value []struct {
+		Name       string `json:"name"`
+		Type       string `json:"type"`
+		Properties struct {
+			Parent struct {
+				ID string `json:"id"`
+			} `json:"parent"`
+		} `json:"properties"`
+	} `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+// listAzureSubscriptions returns the subscriptions of the source account's tenant that
+// match the discovery's management group and name pattern
+func (cm *CredentialManager) listAzureSubscriptions(discovery *Discovery) ([]discoveredAccount, error) {
+	ctx := context.Background()
+
+	source, err := cm.FindAccount(discovery.SourceAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if err := cm.loadCredentials(source); err != nil {
+		return nil, err
+	}
+	sourceCreds, err := source.azureCredentials()
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := cm.azureAccountCredentials(sourceCreds)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := azureTokenCredential(resolved)
+	if err != nil {
+		return nil, err
+	}
+	client, err := arm.NewClient("yogaya", "v0.0.1", credential, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure client: %v", err)
+	}
+
+	// Management group of each subscription, when the discovery is limited to one
+	var groups map[string]string
+	if discovery.ManagementGroup != "" {
+		groups, err = azureManagementGroupSubscriptions(ctx, client, discovery.ManagementGroup)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	members := []discoveredAccount{}
+	next := client.Endpoint() + "/subscriptions?api-version=2022-12-01"
+	for next != "" {
+		var page azureSubscriptionList
+		if err := azureGet(ctx, client, next, &page); err != nil {
+			return nil, fmt.Errorf("failed to list subscriptions: %v", err)
+		}
+		next = page.NextLink
+
+		for _, subscription := range page.Value {
+			// The source account already covers its own subscription
+			if subscription.TenantID != sourceCreds.TenantID || subscription.SubscriptionID == sourceCreds.SubscriptionID {
+				continue
+			}
+			group, inGroup := groups[subscription.SubscriptionID]
+			if groups != nil && !inGroup {
+				continue
+			}
+			if discovery.NamePattern != "" {
+				if matched, _ := path.Match(discovery.NamePattern, subscription.DisplayName); !matched {
+					continue
+				}
+			}
+
+			labels := map[string]string{"azure-subscription-id": subscription.SubscriptionID}
+			if group != "" {
+				labels["management-group"] = group
+			}
+			members = append(members, discoveredAccount{
+				ProviderAccountID: subscription.SubscriptionID,
+				Name:              subscription.DisplayName,
+				Labels:            labels,
+				Active:            azureSubscriptionActive(subscription.State),
+				Credentials: &AzureCredentials{
+					AuthType:        sourceCreds.AuthType,
+					SubscriptionID:  subscription.SubscriptionID,
+					TenantID:        sourceCreds.TenantID,
+					Name:            subscription.DisplayName,
+					Environment:     sourceCreds.Environment,
+					ClientID:        sourceCreds.ClientID,
+					SourceAccountID: source.ID,
+				},
+			})
+		}
+	}
+
+	log.Printf("Found %d subscriptions in %s", len(members), discovery.ID)
+	return members, nil
+}
+
+// azureManagementGroupSubscriptions maps the subscriptions below a management group,
+// including nested groups, to the group directly above them
+func azureManagementGroupSubscriptions(ctx context.Context, client *arm.Client, group string) (map[string]string, error) {
+	subscriptions := map[string]string{}
+	next := fmt.Sprintf("%s/providers/Microsoft.Management/managementGroups/%s/descendants?api-version=2020-05-01", client.Endpoint(), url.PathEscape(group))
+	for next != "" {
+		var page azureManagementGroupDescendants
+		if err := azureGet(ctx, client, next, &page); err != nil {
+			return nil, fmt.Errorf("failed to list management group %s: %v", group, err)
+		}
+		next = page.NextLink
+
+		for _, descendant := range page.Value {
+			if !strings.HasSuffix(descendant.Type, "/subscriptions") {
+				continue
+			}
+			parent := descendant.Properties.Parent.ID
+			subscriptions[descendant.Name] = parent[strings.LastIndex(parent, "/")+1:]
+		}
+	}
+	return subscriptions, nil
+}
+
+// azureSubscriptionActive reports whether resources of a subscription in the given state can be read.
+// Warned and PastDue subscriptions still work; Disabled and Deleted ones do not.
+func azureSubscriptionActive(state string) bool {
+	switch state {
+	case "Enabled", "Warned", "PastDue":
+		return true
+	default:
+		return false
+	}
+}
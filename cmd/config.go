@@ -0,0 +1,237 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the cloud_accounts.conf file",
+}
+
+// configEncryptCmd represents the config encrypt command
+var configEncryptCmd = &cobra.Command{
+	Use:   "encrypt [.yogaya/cloud_accounts.conf-file-path]",
+	Short: "Encrypt the credentials stored in cloud_accounts.conf",
+	Long: "Encrypt the credentials stored in cloud_accounts.conf\n\n" +
+		"The encryption key is derived from the passphrase in $" + passphraseEnv + "\n" +
+		"or from the file named by $" + keyfileEnv + ". The same value must be set\n" +
+		"for every later command that reads the file.\n",
+	Run: configEncryptCommand,
+}
+
+// configStoreCmd represents the config store command
+var configStoreCmd = &cobra.Command{
+	Use:   "store [.yogaya/cloud_accounts.conf-file-path] [file|command|env] [(opt)helper-command]",
+	Short: "Select where account credentials are stored",
+	Long: "Select where account credentials are stored\n\n" +
+		"  file     credentials are kept in cloud_accounts.conf (default)\n" +
+		"  command  credentials are kept by an external helper, invoked as\n" +
+		"           `<helper-command> get|store|erase <ref>` with the secret on stdin/stdout\n" +
+		"  env      credentials are read from the environment variable\n" +
+		"           " + defaultEnvPrefix + "<ACCOUNT-ID> and are never written\n\n" +
+		"With the command and env stores, cloud_accounts.conf only holds references.\n",
+	Run: configStoreCommand,
+}
+
+// configMigrateCmd represents the config migrate command
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate [.yogaya/cloud_accounts.conf-file-path]",
+	Short: "Upgrade cloud_accounts.conf to the current schema version",
+	Long: "Upgrade cloud_accounts.conf to the current schema version\n\n" +
+		"Files written by older versions are also upgraded automatically when they\n" +
+		"are loaded. A backup named cloud_accounts.conf.v<old-version>.bak is written first.\n",
+	Run: configMigrateCommand,
+}
+
+// configOutputCmd represents the config output command
+var configOutputCmd = &cobra.Command{
+	Use:   "output [.yogaya/cloud_accounts.conf-file-path] [--root dir] [--path-template template]",
+	Short: "Set where generate writes Terraform code",
+	Long: "Set where generate writes Terraform code\n\n" +
+		"The root is the directory all code is written to; a relative root is relative to\n" +
+		"the workspace directory, and the root must stay inside the workspace so generate\n" +
+		"can commit the code to its history. The path template names the directory of each\n" +
+		"account below the root and may use these placeholders:\n\n" +
+		"  {provider}    aws, gcp or azure\n" +
+		"  {alias}       the account alias, or its ID when it has none\n" +
+		"  {account_id}  the account ID\n" +
+		"  {region}      the region (empty for Azure)\n" +
+		"  {service}     the Terraformer service; each service gets its own directory\n\n" +
+		"The default template is " + defaultPathTemplate + ". Without flags the current settings are shown.\n",
+	Run: configOutputCommand,
+}
+
+var (
+	configMigrateDryRun bool
+
+	configOutputRoot     string
+	configOutputTemplate string
+
+	configStoreMigrate   bool
+	configStoreEnvPrefix string
+)
+
+func init() {
+	configStoreCmd.Flags().String("backend", "", "credential store backend (file|command|env)")
+	configStoreCmd.Flags().String("helper", "", "helper command of the command store")
+	configStoreCmd.Flags().BoolVar(&configStoreMigrate, "migrate", false, "move the credentials of existing accounts into the selected store")
+	configStoreCmd.Flags().StringVar(&configStoreEnvPrefix, "env-prefix", "", "prefix of the environment variables read by the env store")
+
+	configMigrateCmd.Flags().BoolVar(&configMigrateDryRun, "dry-run", false, "list the pending migrations without changing the file")
+
+	configOutputCmd.Flags().StringVar(&configOutputRoot, "root", "", "directory generated code is written to")
+	configOutputCmd.Flags().StringVar(&configOutputTemplate, "path-template", "", "directory of each account's code below the root")
+
+	configCmd.AddCommand(configEncryptCmd)
+	configCmd.AddCommand(configMigrateCmd)
+	configCmd.AddCommand(configOutputCmd)
+	configCmd.AddCommand(configStoreCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+// configEncryptCommand migrates a plaintext cloud_accounts.conf to encrypted credentials
+func configEncryptCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "config")
+	if len(args) != 1 {
+		fmt.Println("Usage: yogaya config encrypt [.yogaya/cloud_accounts.conf-file-path]")
+		return
+	}
+
+	configPath := args[0]
+
+	cm, err := NewCredentialManager(configPath)
+	if err != nil {
+		fmt.Printf("Error initializing credential manager: %v\n", err)
+		return
+	}
+
+	if err := cm.EnableEncryption(); err != nil {
+		fmt.Printf("Error encrypting credentials: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Successfully encrypted credentials for %d accounts in %s\n", len(cm.config.Accounts), configPath)
+	cm.commitHistory(fmt.Sprintf("config encrypt: %d accounts", len(cm.config.Accounts)))
+}
+
+// configStoreCommand selects the credential store backend
+func configStoreCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "config", "backend")
+	if helper, _ := cmd.Flags().GetString("helper"); helper != "" {
+		args = append(args, helper)
+	}
+	if len(args) < 2 {
+		fmt.Println("Usage: yogaya config store [.yogaya/cloud_accounts.conf-file-path] <file|command|env> [helper-command] [--migrate]")
+		return
+	}
+
+	configPath, backend := args[0], args[1]
+	settings := CredentialStoreConfig{
+		Backend:   backend,
+		Command:   strings.Join(args[2:], " "),
+		EnvPrefix: configStoreEnvPrefix,
+	}
+
+	cm, err := NewCredentialManager(configPath)
+	if err != nil {
+		fmt.Printf("Error initializing credential manager: %v\n", err)
+		return
+	}
+
+	if err := cm.SetCredentialStore(settings, configStoreMigrate); err != nil {
+		fmt.Printf("Error configuring credential store: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Credential store set to %s\n", backend)
+	cm.commitHistory(fmt.Sprintf("config store: %s", backend))
+}
+
+// configMigrateCommand upgrades cloud_accounts.conf to the current schema version
+func configMigrateCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "config")
+	if len(args) != 1 {
+		fmt.Println("Usage: yogaya config migrate [.yogaya/cloud_accounts.conf-file-path] [--dry-run]")
+		return
+	}
+
+	configPath := args[0]
+
+	version, pending, err := PlanConfigMigrations(configPath)
+	if err != nil {
+		fmt.Printf("Error reading %s: %v\n", configPath, err)
+		return
+	}
+	if len(pending) == 0 {
+		fmt.Printf("%s is already at schema version %d\n", configPath, version)
+		return
+	}
+
+	if configMigrateDryRun {
+		fmt.Printf("%s is at schema version %d. Pending migrations:\n", configPath, version)
+		for _, migration := range pending {
+			fmt.Printf("  %d: %s\n", migration.Version, migration.Description)
+		}
+		return
+	}
+
+	// Loading the file applies the migrations and saves the result
+	cm, err := NewCredentialManager(configPath)
+	if err != nil {
+		fmt.Printf("Error migrating %s: %v\n", configPath, err)
+		return
+	}
+
+	fmt.Printf("Successfully migrated %s from schema version %d to %d\n", configPath, version, currentSchemaVersion)
+	cm.commitHistory(fmt.Sprintf("config migrate: schema version %d to %d", version, currentSchemaVersion))
+}
+
+// configOutputCommand shows or sets the output root and path template of generate
+func configOutputCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "config")
+	if len(args) != 1 {
+		fmt.Println("Usage: yogaya config output [.yogaya/cloud_accounts.conf-file-path] [--root dir] [--path-template template]")
+		return
+	}
+
+	configPath := args[0]
+
+	cm, err := NewCredentialManager(configPath)
+	if err != nil {
+		fmt.Printf("Error initializing credential manager: %v\n", err)
+		return
+	}
+
+	if configOutputRoot == "" && configOutputTemplate == "" {
+		layout, err := cm.outputLayout(false, "")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Output root:   %s\n", layout.Root)
+		fmt.Printf("Path template: %s\n", layout.PathTemplate)
+		return
+	}
+
+	if err := cm.SetOutputConfig(configOutputRoot, configOutputTemplate); err != nil {
+		fmt.Printf("Error configuring output: %v\n", err)
+		return
+	}
+
+	layout, err := cm.outputLayout(false, "")
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	fmt.Printf("Generated code will be written to %s\n", filepath.Join(layout.Root, layout.PathTemplate))
+	cm.commitHistory(fmt.Sprintf("config output: %s", filepath.Join(layout.Root, layout.PathTemplate)))
+}
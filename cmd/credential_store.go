@@ -0,0 +1,288 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	fileStoreBackend    = "file"
+	commandStoreBackend = "command"
+	envStoreBackend     = "env"
+
+	defaultEnvPrefix = "YOGAYA_CREDENTIALS_"
+)
+
+// CredentialStore persists the credentials of a cloud account
+type CredentialStore interface {
+	// Load fills account.Credentials from the store
+	Load(account *CloudAccount) error
+	// Save persists account.Credentials to the store and records the reference on the account
+	Save(account *CloudAccount) error
+	// Delete removes the credentials of the account from the store
+	Delete(account *CloudAccount) error
+	// Inline reports whether the credentials are kept in cloud_accounts.conf itself
+	Inline() bool
+	// Persistent reports whether Save keeps the secrets, so that they can be removed from
+	// the store they were migrated from
+	Persistent() bool
+}
+
+// CredentialStoreConfig selects the backend used for newly stored credentials
+type CredentialStoreConfig struct {
+	Backend   string `json:"backend"`
+	Command   string `json:"command,omitempty"`
+	EnvPrefix string `json:"env_prefix,omitempty"`
+}
+
+// credentialStore returns the store for the named backend
+func (cm *CredentialManager) credentialStore(backend string) (CredentialStore, error) {
+	settings := CredentialStoreConfig{}
+	if cm.config.CredentialStore != nil {
+		settings = *cm.config.CredentialStore
+	}
+
+	switch backend {
+	case "", fileStoreBackend:
+		return fileStore{}, nil
+	case commandStoreBackend:
+		if settings.Command == "" {
+			return nil, fmt.Errorf("command credential store has no helper command configured")
+		}
+		return commandStore{command: strings.Fields(settings.Command), tenantKey: cm.tenantKey}, nil
+	case envStoreBackend:
+		prefix := settings.EnvPrefix
+		if prefix == "" {
+			prefix = defaultEnvPrefix
+		}
+		return envStore{prefix: prefix}, nil
+	default:
+		return nil, fmt.Errorf("unsupported credential store backend: %s", backend)
+	}
+}
+
+// defaultCredentialStore returns the store that newly added accounts are saved to
+func (cm *CredentialManager) defaultCredentialStore() (CredentialStore, error) {
+	if cm.config.CredentialStore == nil {
+		return fileStore{}, nil
+	}
+	return cm.credentialStore(cm.config.CredentialStore.Backend)
+}
+
+// loadCredentials fills in the credentials of an account kept outside cloud_accounts.conf
+func (cm *CredentialManager) loadCredentials(account *CloudAccount) error {
+	// Accounts are generated concurrently and may load the same source account
+	cm.credentialsMu.Lock()
+	defer cm.credentialsMu.Unlock()
+
+	if account.Credentials != nil {
+		return nil
+	}
+
+	store, err := cm.credentialStore(account.CredentialStore)
+	if err != nil {
+		return err
+	}
+	return store.Load(account)
+}
+
+// SetCredentialStore changes the default backend and optionally moves existing accounts into it.
+// The config is saved with the new references before the old copies are removed, so an
+// interrupted migration leaves every secret reachable.
+func (cm *CredentialManager) SetCredentialStore(settings CredentialStoreConfig, migrate bool) error {
+	// Stores holding the accounts before the switch, resolved with the old settings
+	oldStores := make([]CredentialStore, len(cm.config.Accounts))
+	if migrate {
+		// Load every secret through the old settings before switching
+		for i := range cm.config.Accounts {
+			account := &cm.config.Accounts[i]
+			if err := cm.loadCredentials(account); err != nil {
+				return fmt.Errorf("failed to load credentials for account %s: %v", account.ID, err)
+			}
+			oldStore, err := cm.credentialStore(account.CredentialStore)
+			if err != nil {
+				return err
+			}
+			oldStores[i] = oldStore
+		}
+	}
+
+	previousSettings := cm.config.CredentialStore
+	cm.config.CredentialStore = &settings
+
+	store, err := cm.credentialStore(settings.Backend)
+	if err != nil {
+		cm.config.CredentialStore = previousSettings
+		return err
+	}
+	if migrate && !store.Persistent() {
+		// Migrating would remove the only copy of the secrets
+		cm.config.CredentialStore = previousSettings
+		return fmt.Errorf("cannot migrate credentials to the %s store: it does not persist secrets. Export them as described in the guide and run without --migrate", settings.Backend)
+	}
+	if !migrate {
+		return cm.saveConfig()
+	}
+
+	previous := make([]CloudAccount, len(cm.config.Accounts))
+	for i := range cm.config.Accounts {
+		account := &cm.config.Accounts[i]
+		previous[i] = *account
+		if err := store.Save(account); err != nil {
+			return fmt.Errorf("failed to store credentials for account %s: %v", account.ID, err)
+		}
+	}
+	if err := cm.saveConfig(); err != nil {
+		return err
+	}
+
+	// The config no longer refers to the old copies; failing to remove them is not fatal
+	for i, account := range cm.config.Accounts {
+		if previous[i].CredentialStore == account.CredentialStore {
+			continue
+		}
+		if err := oldStores[i].Delete(&previous[i]); err != nil {
+			log.Printf("⚠️ Warning: failed to remove old credentials for account %s: %v", account.ID, err)
+		}
+	}
+	return nil
+}
+
+// fileStore keeps credentials inline in cloud_accounts.conf, encrypted when encryption is enabled
+type fileStore struct{}
+
+func (fileStore) Load(account *CloudAccount) error {
+	if account.Credentials == nil {
+		return fmt.Errorf("no credentials stored for account %s", account.ID)
+	}
+	return nil
+}
+
+func (fileStore) Save(account *CloudAccount) error {
+	account.CredentialStore = ""
+	account.CredentialRef = ""
+	return nil
+}
+
+func (fileStore) Delete(account *CloudAccount) error {
+	return nil
+}
+
+func (fileStore) Inline() bool {
+	return true
+}
+
+func (fileStore) Persistent() bool {
+	return true
+}
+
+// commandStore delegates to an external helper invoked as `<command> get|store|erase <ref>`.
+// Secrets are written to the helper's stdin on store and read from its stdout on get.
+type commandStore struct {
+	command []string
+	// tenantKey keeps the references of different workspaces apart in a shared helper
+	tenantKey string
+}
+
+func (s commandStore) Load(account *CloudAccount) error {
+	output, err := s.run("get", account.CredentialRef, nil)
+	if err != nil {
+		return err
+	}
+	return decodeStoredCredentials(account, output)
+}
+
+func (s commandStore) Save(account *CloudAccount) error {
+	ref := "yogaya/" + account.ID
+	if s.tenantKey != "" {
+		ref = "yogaya/" + s.tenantKey[:min(len(s.tenantKey), 16)] + "/" + account.ID
+	}
+	data, err := json.Marshal(account.Credentials)
+	if err != nil {
+		return err
+	}
+	if _, err := s.run("store", ref, data); err != nil {
+		return err
+	}
+	account.CredentialStore = commandStoreBackend
+	account.CredentialRef = ref
+	return nil
+}
+
+func (s commandStore) Delete(account *CloudAccount) error {
+	_, err := s.run("erase", account.CredentialRef, nil)
+	return err
+}
+
+func (commandStore) Inline() bool {
+	return false
+}
+
+func (commandStore) Persistent() bool {
+	return true
+}
+
+func (s commandStore) run(action, ref string, input []byte) ([]byte, error) {
+	args := append(append([]string{}, s.command[1:]...), action, ref)
+	helperCmd := exec.Command(s.command[0], args...)
+	helperCmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	helperCmd.Stderr = &stderr
+
+	output, err := helperCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("credential helper %s %s failed: %v: %s", s.command[0], action, err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// envStore reads credentials as JSON from an environment variable named after the account.
+// It never writes secrets; saving only records which variable has to be set.
+type envStore struct {
+	prefix string
+}
+
+func (s envStore) Load(account *CloudAccount) error {
+	value := os.Getenv(account.CredentialRef)
+	if value == "" {
+		return fmt.Errorf("environment variable %s with the credentials of account %s is not set", account.CredentialRef, account.ID)
+	}
+	return decodeStoredCredentials(account, []byte(value))
+}
+
+func (s envStore) Save(account *CloudAccount) error {
+	account.CredentialStore = envStoreBackend
+	account.CredentialRef = s.prefix + strings.ToUpper(account.ID)
+	log.Printf("⚠️ Credentials for account %s are not persisted. Export %s with the credentials JSON before running generate", account.ID, account.CredentialRef)
+	return nil
+}
+
+func (envStore) Delete(account *CloudAccount) error {
+	return nil
+}
+
+func (envStore) Inline() bool {
+	return false
+}
+
+func (envStore) Persistent() bool {
+	return false
+}
+
+// decodeStoredCredentials decodes credentials JSON returned by a store
+func decodeStoredCredentials(account *CloudAccount, data []byte) error {
+	creds, err := decodeCredentials(account.Provider, bytes.TrimSpace(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode stored credentials for account %s: %v", account.ID, err)
+	}
+	account.Credentials = creds
+	return nil
+}
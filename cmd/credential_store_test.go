@@ -0,0 +1,143 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// credentialHelper writes a command store helper that keeps secrets as files in dir.
+// On erase it copies the config file to config-at-erase, so tests can check what the
+// config referred to when the old copy was removed. A failing helper rejects store.
+func credentialHelper(t *testing.T, dir, configPath string, failing bool) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the test credential helper is a shell script")
+	}
+	store := `cat > "$dir/secret-$ref"`
+	if failing {
+		store = `echo "vault sealed" >&2; exit 1`
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+dir=%q
+ref=$(echo "$2" | tr / _)
+case "$1" in
+store) %s ;;
+get) cat "$dir/secret-$ref" ;;
+erase) cp %q "$dir/config-at-erase"; rm "$dir/secret-$ref" ;;
+esac
+`, dir, store, configPath)
+	path := filepath.Join(dir, "helper.sh")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// helperSecrets returns the names of the secrets a credential helper holds
+func helperSecrets(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "secret-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestSetCredentialStoreMigrates(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, cloudAccountsFile)
+	helper := credentialHelper(t, dir, path, false)
+
+	cm := &CredentialManager{configPath: path, config: CloudAccountsConfig{SchemaVersion: currentSchemaVersion}}
+	cm.config.Accounts = []CloudAccount{
+		{ID: "aws-1", Provider: "aws", Credentials: &AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "aws-secret"}},
+		{ID: "azure-1", Provider: "azure", Credentials: &AzureCredentials{SubscriptionID: "sub", TenantID: "tenant", ClientSecret: "azure-secret"}},
+	}
+	if err := cm.saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Into the helper
+	if err := cm.SetCredentialStore(CredentialStoreConfig{Backend: commandStoreBackend, Command: helper}, true); err != nil {
+		t.Fatalf("SetCredentialStore(command) error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"aws-secret", "azure-secret"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("config still holds %s after moving it to the helper", secret)
+		}
+	}
+	if got := helperSecrets(t, dir); len(got) != 2 {
+		t.Fatalf("helper holds %v, want both accounts", got)
+	}
+
+	// And back into the file, loading the secrets through the helper
+	loaded := &CredentialManager{configPath: path}
+	if err := loaded.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.SetCredentialStore(CredentialStoreConfig{Backend: fileStoreBackend}, true); err != nil {
+		t.Fatalf("SetCredentialStore(file) error = %v", err)
+	}
+	if got := helperSecrets(t, dir); len(got) != 0 {
+		t.Errorf("helper still holds %v after moving the secrets out", got)
+	}
+	// The helper's copies were only erased once the config held the secrets itself
+	atErase, err := os.ReadFile(filepath.Join(dir, "config-at-erase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(atErase), "credential_ref") || !strings.Contains(string(atErase), "aws-secret") {
+		t.Errorf("config when the helper erased a secret = %s, want it to hold the secrets inline", atErase)
+	}
+}
+
+func TestSetCredentialStoreKeepsOldCopiesOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, cloudAccountsFile)
+	working := credentialHelper(t, dir, path, false)
+	failingDir := t.TempDir()
+	failing := credentialHelper(t, failingDir, path, true)
+
+	cm := &CredentialManager{configPath: path, config: CloudAccountsConfig{SchemaVersion: currentSchemaVersion}}
+	cm.config.Accounts = []CloudAccount{
+		{ID: "aws-1", Provider: "aws", Credentials: &AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "aws-secret"}},
+	}
+	if err := cm.SetCredentialStore(CredentialStoreConfig{Backend: commandStoreBackend, Command: working}, true); err != nil {
+		t.Fatalf("SetCredentialStore(command) error = %v", err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &CredentialManager{configPath: path}
+	if err := loaded.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	err = loaded.SetCredentialStore(CredentialStoreConfig{Backend: commandStoreBackend, Command: failing}, true)
+	if err == nil || !strings.Contains(err.Error(), "vault sealed") {
+		t.Fatalf("SetCredentialStore(failing) error = %v, want the helper's error", err)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("config changed by a failed migration:\n%s\nwant\n%s", after, before)
+	}
+	if got := helperSecrets(t, dir); len(got) != 1 {
+		t.Errorf("old helper holds %v, want the secret kept", got)
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// cloudAccountJSON has the fields of CloudAccount without its methods, so it can be
+// used inside MarshalJSON and UnmarshalJSON without recursing
+type cloudAccountJSON CloudAccount
+
+// UnmarshalJSON decodes the credentials into the credentials struct of the account's provider,
+// so loaded accounts hold the same types as newly added ones
+func (a *CloudAccount) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		*cloudAccountJSON
+		Credentials json.RawMessage `json:"credentials,omitempty"`
+	}{cloudAccountJSON: (*cloudAccountJSON)(a)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	a.Credentials = nil
+	if len(aux.Credentials) == 0 || bytes.Equal(aux.Credentials, []byte("null")) {
+		return nil
+	}
+
+	creds, err := decodeCredentials(a.Provider, aux.Credentials)
+	if err != nil {
+		return fmt.Errorf("account %s: %v", a.ID, err)
+	}
+	a.Credentials = creds
+	return nil
+}
+
+// MarshalJSON refuses credentials whose type does not match the provider
+func (a CloudAccount) MarshalJSON() ([]byte, error) {
+	if a.Credentials != nil {
+		if err := checkCredentialsType(a.Provider, a.Credentials); err != nil {
+			return nil, fmt.Errorf("account %s: %v", a.ID, err)
+		}
+	}
+	return json.Marshal(cloudAccountJSON(a))
+}
+
+// decodeCredentials decodes credentials JSON into the credentials struct of the provider
+func decodeCredentials(provider string, data []byte) (interface{}, error) {
+	var creds interface{}
+	switch provider {
+	case "aws":
+		creds = &AWSCredentials{}
+	case "gcp":
+		creds = &GCPCloudCredentials{}
+	case "azure":
+		creds = &AzureCredentials{}
+	default:
+		return nil, fmt.Errorf("unsupported provider: %q", provider)
+	}
+
+	if err := json.Unmarshal(data, creds); err != nil {
+		return nil, fmt.Errorf("failed to decode %s credentials: %v", provider, err)
+	}
+	return creds, nil
+}
+
+// checkCredentialsType reports an error unless creds is the credentials struct of the provider
+func checkCredentialsType(provider string, creds interface{}) error {
+	var ok bool
+	switch provider {
+	case "aws":
+		_, ok = creds.(*AWSCredentials)
+	case "gcp":
+		_, ok = creds.(*GCPCloudCredentials)
+	case "azure":
+		_, ok = creds.(*AzureCredentials)
+	default:
+		return fmt.Errorf("unsupported provider: %q", provider)
+	}
+	if !ok {
+		return fmt.Errorf("credentials of type %T do not belong to provider %s", creds, provider)
+	}
+	return nil
+}
+
+// credentialsSourceAccountID returns the account whose credentials are used in place of
+// creds, or "" when creds authenticate on their own
+func credentialsSourceAccountID(creds interface{}) string {
+	switch creds := creds.(type) {
+	case *AWSCredentials:
+		return creds.SourceAccountID
+	case *GCPCloudCredentials:
+		return creds.SourceAccountID
+	case *AzureCredentials:
+		return creds.SourceAccountID
+	default:
+		return ""
+	}
+}
+
+// awsCredentials returns the credentials of an AWS account
+func (a *CloudAccount) awsCredentials() (*AWSCredentials, error) {
+	creds, ok := a.Credentials.(*AWSCredentials)
+	if !ok {
+		return nil, fmt.Errorf("account %s has no AWS credentials loaded", a.ID)
+	}
+	return creds, nil
+}
+
+// gcpCredentials returns the credentials of a GCP account
+func (a *CloudAccount) gcpCredentials() (*GCPCloudCredentials, error) {
+	creds, ok := a.Credentials.(*GCPCloudCredentials)
+	if !ok {
+		return nil, fmt.Errorf("account %s has no GCP credentials loaded", a.ID)
+	}
+	return creds, nil
+}
+
+// azureCredentials returns the credentials of an Azure account
+func (a *CloudAccount) azureCredentials() (*AzureCredentials, error) {
+	creds, ok := a.Credentials.(*AzureCredentials)
+	if !ok {
+		return nil, fmt.Errorf("account %s has no Azure credentials loaded", a.ID)
+	}
+	return creds, nil
+}
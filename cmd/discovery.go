@@ -0,0 +1,377 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Account statuses set by discovery
+const (
+	accountStatusClosed  = "closed"
+	accountStatusRemoved = "removed"
+)
+
+// Kinds of discovery
+const (
+	discoveryAWSOrganization    = "aws-org"
+	discoveryGCPOrganization    = "gcp-org"
+	discoveryAzureSubscriptions = "azure-subscriptions"
+)
+
+// Discovery registers the member accounts of an organization through one of the
+// configured accounts and keeps them in sync
+type Discovery struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// SourceAccountID is the configured account whose credentials list the members
+	SourceAccountID string `json:"source_account_id"`
+	// Parent is the organization or folder whose projects are listed (gcp-org)
+	Parent string `json:"parent,omitempty"`
+	// ManagementGroup and NamePattern narrow the subscriptions that are listed (azure-subscriptions)
+	ManagementGroup string `json:"management_group,omitempty"`
+	NamePattern     string `json:"name_pattern,omitempty"`
+	RoleTemplate    string `json:"role_template,omitempty"`
+	ExternalID      string `json:"external_id,omitempty"`
+	Region          string `json:"region,omitempty"`
+	// AllowWrite keeps --allow-write of the add command for the read-only audit of new members
+	AllowWrite bool      `json:"allow_write,omitempty"`
+	LastSynced time.Time `json:"last_synced"`
+}
+
+// discoveredAccount is a member account reported by a discovery
+type discoveredAccount struct {
+	// ProviderAccountID is the provider's own ID of the account
+	ProviderAccountID string
+	Name              string
+	Labels            map[string]string
+	Active            bool
+	// Credentials are used when the account is registered for the first time
+	Credentials interface{}
+}
+
+// SyncResult lists the accounts changed by a sync
+type SyncResult struct {
+	Added   []string
+	Closed  []string
+	Removed []string
+}
+
+// accountsSyncCmd represents the accounts sync command
+var accountsSyncCmd = &cobra.Command{
+	Use:   "sync [.yogaya/cloud_accounts.conf-file-path] [(opt)discovery-id]",
+	Short: "Add new member accounts of discovered organizations and flag closed ones",
+	Run:   accountsSyncCommand,
+}
+
+func init() {
+	accountsCmd.AddCommand(accountsSyncCmd)
+}
+
+// discoverySource returns the account whose credentials list the members of a discovery.
+// It is the account named by --source-account, or the account added from credentialsPath.
+func (cm *CredentialManager) discoverySource(provider, credentialsPath string, opts AddOptions) (*CloudAccount, error) {
+	if opts.SourceAccount == "" {
+		return cm.AddCredentials(provider, credentialsPath, opts)
+	}
+
+	source, err := cm.FindAccount(opts.SourceAccount)
+	if err != nil {
+		return nil, err
+	}
+	if source.Provider != provider {
+		return nil, fmt.Errorf("source account %s is not an %s account", opts.SourceAccount, strings.ToUpper(provider))
+	}
+	return source, nil
+}
+
+// addDiscovery records a new discovery and runs its first sync.
+// With sync set, a discovery with the same ID is synced instead of being refused.
+func (cm *CredentialManager) addDiscovery(discovery Discovery, sync bool) (*Discovery, *SyncResult, error) {
+	existing, err := cm.FindDiscovery(discovery.ID)
+	switch {
+	case err == nil && !sync:
+		return nil, nil, fmt.Errorf("%s %s is already discovered; pass --sync or run `yogaya accounts sync` to update it", discovery.Kind, discovery.ID)
+	case err != nil:
+		cm.config.Discoveries = append(cm.config.Discoveries, discovery)
+		existing = &cm.config.Discoveries[len(cm.config.Discoveries)-1]
+	default:
+		existing.AllowWrite = discovery.AllowWrite
+	}
+
+	result, err := cm.SyncDiscovery(existing)
+	if err != nil {
+		return nil, nil, err
+	}
+	return existing, result, nil
+}
+
+// FindDiscovery looks up a discovery by ID
+func (cm *CredentialManager) FindDiscovery(id string) (*Discovery, error) {
+	for i := range cm.config.Discoveries {
+		if cm.config.Discoveries[i].ID == id {
+			return &cm.config.Discoveries[i], nil
+		}
+	}
+	return nil, fmt.Errorf("discovery not found: %s", id)
+}
+
+// SyncDiscovery lists the members of a discovery and updates the configured accounts.
+// New active members are added, members that are no longer active are flagged closed
+// and members that disappeared are flagged removed. Flagged accounts are kept so their
+// generated code and history are not lost.
+func (cm *CredentialManager) SyncDiscovery(discovery *Discovery) (*SyncResult, error) {
+	var members []discoveredAccount
+	var err error
+	switch discovery.Kind {
+	case discoveryAWSOrganization:
+		members, err = cm.listAWSOrganizationAccounts(discovery)
+	case discoveryGCPOrganization:
+		members, err = cm.listGCPOrganizationProjects(discovery)
+	case discoveryAzureSubscriptions:
+		members, err = cm.listAzureSubscriptions(discovery)
+	default:
+		return nil, fmt.Errorf("unsupported discovery kind: %s", discovery.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cm.applyDiscoveredMembers(discovery, members)
+}
+
+// applyDiscoveredMembers updates the accounts of a discovery from its listed members
+// and saves the config
+func (cm *CredentialManager) applyDiscoveredMembers(discovery *Discovery, members []discoveredAccount) (*SyncResult, error) {
+	store, err := cm.defaultCredentialStore()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SyncResult{}
+	seen := map[string]bool{}
+	for _, member := range members {
+		seen[member.ProviderAccountID] = true
+
+		account := cm.findDiscoveredAccount(discovery.ID, member.ProviderAccountID)
+		if account == nil {
+			if !member.Active {
+				continue
+			}
+			account, err = cm.addDiscoveredAccount(discovery, member, store)
+			if err != nil {
+				return nil, err
+			}
+			if account == nil {
+				continue
+			}
+			result.Added = append(result.Added, account.displayName())
+			continue
+		}
+
+		if account.Labels == nil {
+			account.Labels = map[string]string{}
+		}
+		for key, value := range member.Labels {
+			account.Labels[key] = value
+		}
+
+		switch {
+		case member.Active:
+			account.Status = ""
+		case account.Status != accountStatusClosed:
+			account.Status = accountStatusClosed
+			result.Closed = append(result.Closed, account.displayName())
+		}
+	}
+
+	for i := range cm.config.Accounts {
+		account := &cm.config.Accounts[i]
+		if account.DiscoveredBy == discovery.ID && !seen[account.ProviderAccountID] && account.Status != accountStatusRemoved {
+			account.Status = accountStatusRemoved
+			result.Removed = append(result.Removed, account.displayName())
+		}
+	}
+
+	discovery.LastSynced = time.Now()
+	return result, cm.saveConfig()
+}
+
+// syncAllDiscoveries syncs every discovery. A failing discovery is reported and skipped
+// so the others are still synced.
+func (cm *CredentialManager) syncAllDiscoveries() {
+	for i := range cm.config.Discoveries {
+		discovery := &cm.config.Discoveries[i]
+		result, err := cm.SyncDiscovery(discovery)
+		if err != nil {
+			log.Printf("⚠️ Failed to sync %s %s: %v", discovery.Kind, discovery.ID, err)
+			continue
+		}
+		printSyncResult(discovery, result)
+		cm.commitHistory(syncCommitMessage("accounts sync", discovery, result))
+	}
+}
+
+// findDiscoveredAccount returns the account registered for a member of a discovery
+func (cm *CredentialManager) findDiscoveredAccount(discoveryID, providerAccountID string) *CloudAccount {
+	for i := range cm.config.Accounts {
+		account := &cm.config.Accounts[i]
+		if account.DiscoveredBy == discoveryID && account.ProviderAccountID == providerAccountID {
+			return account
+		}
+	}
+	return nil
+}
+
+// addDiscoveredAccount registers a new member account after verifying its credentials
+// like `yogaya add` does. It returns nil when the member is already configured outside
+// the discovery, or when its credentials fail the check; such members are tried again
+// on the next sync.
+func (cm *CredentialManager) addDiscoveredAccount(discovery *Discovery, member discoveredAccount, store CredentialStore) (*CloudAccount, error) {
+	provider := strings.SplitN(discovery.Kind, "-", 2)[0]
+	account := &CloudAccount{
+		Provider:          provider,
+		AddedAt:           time.Now(),
+		Credentials:       member.Credentials,
+		DiscoveredBy:      discovery.ID,
+		ProviderAccountID: member.ProviderAccountID,
+		SourceAccountID:   credentialsSourceAccountID(member.Credentials),
+	}
+	if len(member.Labels) > 0 {
+		account.Labels = member.Labels
+	}
+	duplicate, err := cm.isDuplicateAccount(account)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials for member %s: %v", member.ProviderAccountID, err)
+	}
+	if duplicate {
+		log.Printf("⚠️ Skipping member %s: it is already configured as another account", member.ProviderAccountID)
+		return nil, nil
+	}
+	if err := cm.verifyCredentials(provider, account.Credentials, discovery.AllowWrite); err != nil {
+		log.Printf("⚠️ Skipping member %s: %v", member.ProviderAccountID, err)
+		return nil, nil
+	}
+	account.LastValidated = time.Now()
+	account.ID, err = cm.generateAccountID(account)
+	if err != nil {
+		return nil, err
+	}
+	account.Fingerprint = account.ID
+	account.Alias = cm.uniqueAlias(member.Name, member.ProviderAccountID, account.ID)
+
+	if err := store.Save(account); err != nil {
+		return nil, fmt.Errorf("failed to store credentials for account %s: %v", member.ProviderAccountID, err)
+	}
+	cm.config.Accounts = append(cm.config.Accounts, *account)
+	return &cm.config.Accounts[len(cm.config.Accounts)-1], nil
+}
+
+var aliasUnsafeCharacters = regexp.MustCompile(`[^a-z0-9]+`)
+
+// uniqueAlias derives an alias from a member's name that no other account uses. When the
+// name and the provider's account ID are taken, it falls back to the account's own ID.
+func (cm *CredentialManager) uniqueAlias(name, providerAccountID, accountID string) string {
+	alias := strings.Trim(aliasUnsafeCharacters.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if alias == "" {
+		alias = providerAccountID
+	}
+	if cm.checkAliasAvailable(alias, "") == nil {
+		return alias
+	}
+
+	suffix := providerAccountID
+	if len(suffix) > 4 {
+		suffix = suffix[len(suffix)-4:]
+	}
+	alias = alias + "-" + suffix
+	if cm.checkAliasAvailable(alias, "") == nil {
+		return alias
+	}
+	if providerAccountID != "" && cm.checkAliasAvailable(providerAccountID, "") == nil {
+		return providerAccountID
+	}
+	return accountID
+}
+
+// syncCommitMessage describes the changes made by a sync for the workspace history
+func syncCommitMessage(command string, discovery *Discovery, result *SyncResult) string {
+	var message strings.Builder
+	fmt.Fprintf(&message, "%s: %s %s, %d added, %d closed, %d removed\n", command, discovery.Kind, discovery.ID, len(result.Added), len(result.Closed), len(result.Removed))
+	if len(result.Added)+len(result.Closed)+len(result.Removed) > 0 {
+		message.WriteString("\n")
+	}
+	for _, name := range result.Added {
+		fmt.Fprintf(&message, "+ %s\n", name)
+	}
+	for _, name := range result.Closed {
+		fmt.Fprintf(&message, "closed: %s\n", name)
+	}
+	for _, name := range result.Removed {
+		fmt.Fprintf(&message, "removed: %s\n", name)
+	}
+	return message.String()
+}
+
+// printSyncResult reports the changes made by a sync
+func printSyncResult(discovery *Discovery, result *SyncResult) {
+	log.Printf("✅ Synced %s %s: %d added, %d closed, %d removed", discovery.Kind, discovery.ID, len(result.Added), len(result.Closed), len(result.Removed))
+	for _, name := range result.Added {
+		log.Printf("  + %s", name)
+	}
+	for _, name := range result.Closed {
+		log.Printf("⚠️  closed: %s", name)
+	}
+	for _, name := range result.Removed {
+		log.Printf("⚠️  removed: %s", name)
+	}
+}
+
+// accountsSyncCommand syncs one or all discoveries
+func accountsSyncCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "config")
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Println("Usage: yogaya accounts sync [.yogaya/cloud_accounts.conf-file-path] [discovery-id]")
+		return
+	}
+
+	cm, err := NewCredentialManager(args[0])
+	if err != nil {
+		fmt.Printf("Error initializing credential manager: %v\n", err)
+		return
+	}
+
+	discoveries := []*Discovery{}
+	if len(args) == 2 {
+		discovery, err := cm.FindDiscovery(args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		discoveries = append(discoveries, discovery)
+	} else {
+		for i := range cm.config.Discoveries {
+			discoveries = append(discoveries, &cm.config.Discoveries[i])
+		}
+	}
+	if len(discoveries) == 0 {
+		fmt.Println("No discoveries configured. Add one with `yogaya add aws-org`, `yogaya add gcp-org` or `yogaya add azure --all-subscriptions`.")
+		return
+	}
+
+	for _, discovery := range discoveries {
+		result, err := cm.SyncDiscovery(discovery)
+		if err != nil {
+			fmt.Printf("Error syncing %s: %v\n", discovery.ID, err)
+			continue
+		}
+		printSyncResult(discovery, result)
+		cm.commitHistory(syncCommitMessage("accounts sync", discovery, result))
+	}
+}
@@ -0,0 +1,428 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAWSMemberRoleARN(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{template: "YogayaReadOnly", want: "arn:aws:iam::123456789012:role/YogayaReadOnly"},
+		{template: "arn:aws:iam::{account_id}:role/audit/Reader", want: "arn:aws:iam::123456789012:role/audit/Reader"},
+		{template: "arn:aws-us-gov:iam::{account_id}:role/Reader", want: "arn:aws-us-gov:iam::123456789012:role/Reader"},
+	}
+
+	for _, tt := range tests {
+		if got := awsMemberRoleARN(tt.template, "123456789012"); got != tt.want {
+			t.Errorf("awsMemberRoleARN(%q) = %s, want %s", tt.template, got, tt.want)
+		}
+	}
+}
+
+// awsDiscoveryMember returns an active member of an AWS organization discovery
+func awsDiscoveryMember(discovery *Discovery, accountID, name string) discoveredAccount {
+	return discoveredAccount{
+		ProviderAccountID: accountID,
+		Name:              name,
+		Labels:            map[string]string{"ou": "Root", "aws-account-id": accountID},
+		Active:            true,
+		Credentials:       awsMemberCredentials(discovery, accountID),
+	}
+}
+
+// acceptCredentials passes every member in place of the provider checks
+func acceptCredentials(provider string, creds interface{}, allowWrite bool) error {
+	return nil
+}
+
+// discoveryTestManager returns a manager with the source account of an AWS organization discovery
+func discoveryTestManager(t *testing.T) (*CredentialManager, *Discovery) {
+	t.Helper()
+	cm := &CredentialManager{configPath: filepath.Join(t.TempDir(), cloudAccountsFile), verifyAccess: acceptCredentials}
+	cm.config.Accounts = []CloudAccount{
+		{ID: "management", Alias: "management", Provider: "aws", Credentials: &AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}},
+	}
+	cm.config.Discoveries = []Discovery{
+		{ID: "o-example", Kind: discoveryAWSOrganization, SourceAccountID: "management", RoleTemplate: "YogayaReadOnly", Region: "eu-west-1"},
+	}
+	return cm, &cm.config.Discoveries[0]
+}
+
+func TestApplyDiscoveredMembers(t *testing.T) {
+	cm, discovery := discoveryTestManager(t)
+
+	first := []discoveredAccount{
+		awsDiscoveryMember(discovery, "111111111111", "Production"),
+		awsDiscoveryMember(discovery, "222222222222", "Staging Env"),
+		awsDiscoveryMember(discovery, "333333333333", "Sandbox"),
+	}
+	first[2].Active = false
+
+	result, err := cm.applyDiscoveredMembers(discovery, first)
+	if err != nil {
+		t.Fatalf("applyDiscoveredMembers() error = %v", err)
+	}
+	if len(result.Added) != 2 || len(result.Closed) != 0 || len(result.Removed) != 0 {
+		t.Fatalf("first sync = %+v, want 2 added", result)
+	}
+	if discovery.LastSynced.IsZero() {
+		t.Error("LastSynced was not set")
+	}
+
+	production := cm.findDiscoveredAccount(discovery.ID, "111111111111")
+	if production == nil {
+		t.Fatal("member 111111111111 was not added")
+	}
+	if production.Alias != "production" || production.Provider != "aws" || production.Labels["ou"] != "Root" {
+		t.Errorf("added member = %+v", production)
+	}
+	creds, err := production.awsCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &AWSCredentials{
+		RoleARN:         "arn:aws:iam::111111111111:role/YogayaReadOnly",
+		SourceAccountID: "management",
+		Region:          "eu-west-1",
+	}
+	if !reflect.DeepEqual(creds, want) {
+		t.Errorf("member credentials = %+v, want %+v", creds, want)
+	}
+	if staging := cm.findDiscoveredAccount(discovery.ID, "222222222222"); staging == nil || staging.Alias != "staging-env" {
+		t.Errorf("staging member = %+v, want alias staging-env", staging)
+	}
+	if cm.findDiscoveredAccount(discovery.ID, "333333333333") != nil {
+		t.Error("inactive member was added")
+	}
+
+	// Production is suspended, staging left the organization and its OU changed
+	second := []discoveredAccount{
+		awsDiscoveryMember(discovery, "111111111111", "Production"),
+		awsDiscoveryMember(discovery, "333333333333", "Sandbox"),
+	}
+	second[0].Active = false
+	second[0].Labels["ou"] = "Root/Suspended"
+	second[1].Active = false
+
+	result, err = cm.applyDiscoveredMembers(discovery, second)
+	if err != nil {
+		t.Fatalf("applyDiscoveredMembers() error = %v", err)
+	}
+	wantResult := &SyncResult{Closed: []string{"production [" + production.ID + "]"}}
+	staging := cm.findDiscoveredAccount(discovery.ID, "222222222222")
+	wantResult.Removed = []string{"staging-env [" + staging.ID + "]"}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("second sync = %+v, want %+v", result, wantResult)
+	}
+	production = cm.findDiscoveredAccount(discovery.ID, "111111111111")
+	if production.Status != accountStatusClosed || production.Labels["ou"] != "Root/Suspended" {
+		t.Errorf("closed member = %+v", production)
+	}
+	if staging.Status != accountStatusRemoved {
+		t.Errorf("removed member status = %q, want %q", staging.Status, accountStatusRemoved)
+	}
+
+	// Syncing again reports nothing new, and a reactivated member is no longer closed
+	second[0].Active = true
+	result, err = cm.applyDiscoveredMembers(discovery, second)
+	if err != nil {
+		t.Fatalf("applyDiscoveredMembers() error = %v", err)
+	}
+	if len(result.Added)+len(result.Closed)+len(result.Removed) != 0 {
+		t.Errorf("third sync = %+v, want no changes", result)
+	}
+	if production := cm.findDiscoveredAccount(discovery.ID, "111111111111"); production.Status != "" {
+		t.Errorf("reactivated member status = %q, want none", production.Status)
+	}
+
+	// The config was saved
+	loaded := &CredentialManager{configPath: cm.configPath}
+	if err := loaded.loadConfig(); err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if len(loaded.config.Accounts) != 3 {
+		t.Errorf("saved %d accounts, want 3", len(loaded.config.Accounts))
+	}
+}
+
+func TestApplyDiscoveredMembersSkipsConfiguredAccounts(t *testing.T) {
+	cm, discovery := discoveryTestManager(t)
+
+	// The member was added by hand with the same role before the discovery existed
+	manual := &CloudAccount{Provider: "aws", Credentials: awsMemberCredentials(discovery, "111111111111")}
+	id, err := cm.generateAccountID(manual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manual.ID = id
+	manual.Fingerprint = manual.ID
+	cm.config.Accounts = append(cm.config.Accounts, *manual)
+
+	result, err := cm.applyDiscoveredMembers(discovery, []discoveredAccount{awsDiscoveryMember(discovery, "111111111111", "Production")})
+	if err != nil {
+		t.Fatalf("applyDiscoveredMembers() error = %v", err)
+	}
+	if len(result.Added) != 0 {
+		t.Errorf("added %v, want the configured member to be skipped", result.Added)
+	}
+	if len(cm.config.Accounts) != 2 {
+		t.Errorf("%d accounts configured, want 2", len(cm.config.Accounts))
+	}
+}
+
+func TestUniqueAlias(t *testing.T) {
+	cm := &CredentialManager{}
+	cm.config.Accounts = []CloudAccount{
+		{ID: "a", Alias: "production"},
+		{ID: "b", Alias: "shared-9012"},
+		{ID: "c", Alias: "shared"},
+		{ID: "d", Alias: "legacy"},
+		{ID: "e", Alias: "legacy-0001"},
+		{ID: "f", Alias: "000000000001"},
+	}
+
+	tests := []struct {
+		name              string
+		member            string
+		providerAccountID string
+		want              string
+	}{
+		{name: "free alias", member: "Data Lake (EU)", providerAccountID: "123456789012", want: "data-lake-eu"},
+		{name: "taken alias", member: "Production", providerAccountID: "123456789012", want: "production-9012"},
+		{name: "taken with suffix", member: "Shared", providerAccountID: "123456789012", want: "123456789012"},
+		{name: "provider ID taken too", member: "Legacy", providerAccountID: "000000000001", want: "acct-id"},
+		{name: "no usable name", member: "***", providerAccountID: "123456789012", want: "123456789012"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cm.uniqueAlias(tt.member, tt.providerAccountID, "acct-id"); got != tt.want {
+				t.Errorf("uniqueAlias(%q) = %q, want %q", tt.member, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGCPCredentialsJSON(t *testing.T) {
+	document := json.RawMessage(`{"type":"service_account","project_id":"admin"}`)
+	cm := &CredentialManager{}
+	cm.config.Accounts = []CloudAccount{
+		{ID: "admin", Provider: "gcp", Credentials: &GCPCloudCredentials{Type: gcpServiceAccount, ProjectID: "admin", Document: document}},
+		{ID: "project-a", Provider: "gcp", Credentials: &GCPCloudCredentials{ProjectID: "project-a", SourceAccountID: "admin"}},
+	}
+
+	tests := []struct {
+		name    string
+		creds   *GCPCloudCredentials
+		want    string
+		wantErr string
+	}{
+		{name: "own document", creds: &GCPCloudCredentials{ProjectID: "admin", Document: document}, want: string(document)},
+		{name: "shared from the source", creds: &GCPCloudCredentials{ProjectID: "project-b", SourceAccountID: "admin"}, want: string(document)},
+		{name: "missing source", creds: &GCPCloudCredentials{ProjectID: "project-b", SourceAccountID: "gone"}, wantErr: "source account of GCP project project-b"},
+		{name: "chained source", creds: &GCPCloudCredentials{ProjectID: "project-b", SourceAccountID: "project-a"}, wantErr: "shares the credentials of another account"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cm.gcpCredentialsJSON(tt.creds)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("gcpCredentialsJSON() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("gcpCredentialsJSON() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("gcpCredentialsJSON() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyDiscoveredMembersRefusesWriteAccess(t *testing.T) {
+	cm, discovery := discoveryTestManager(t)
+	// The role in 222222222222 was created with administrator access
+	cm.verifyAccess = func(provider string, creds interface{}, allowWrite bool) error {
+		if creds.(*AWSCredentials).RoleARN == "arn:aws:iam::222222222222:role/YogayaReadOnly" && !allowWrite {
+			return fmt.Errorf("arn:aws:iam::222222222222:role/YogayaReadOnly can modify infrastructure (ec2:RunInstances); use read-only credentials or pass --allow-write")
+		}
+		return nil
+	}
+	members := []discoveredAccount{
+		awsDiscoveryMember(discovery, "111111111111", "Production"),
+		awsDiscoveryMember(discovery, "222222222222", "Admin"),
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	result, err := cm.applyDiscoveredMembers(discovery, members)
+	if err != nil {
+		t.Fatalf("applyDiscoveredMembers() error = %v", err)
+	}
+	if len(result.Added) != 1 || cm.findDiscoveredAccount(discovery.ID, "222222222222") != nil {
+		t.Fatalf("added %v, want the write-capable member refused", result.Added)
+	}
+	if production := cm.findDiscoveredAccount(discovery.ID, "111111111111"); production.LastValidated.IsZero() {
+		t.Error("LastValidated of the verified member was not set")
+	}
+	if !strings.Contains(logs.String(), "Skipping member 222222222222") || !strings.Contains(logs.String(), "can modify infrastructure") {
+		t.Errorf("logs = %q, want the refused member reported", logs.String())
+	}
+
+	// With --allow-write recorded on the discovery, the next sync adds it
+	discovery.AllowWrite = true
+	result, err = cm.applyDiscoveredMembers(discovery, members)
+	if err != nil {
+		t.Fatalf("applyDiscoveredMembers() error = %v", err)
+	}
+	if len(result.Added) != 1 || cm.findDiscoveredAccount(discovery.ID, "222222222222") == nil {
+		t.Errorf("added %v, want the member allowed to write", result.Added)
+	}
+}
+
+func TestApplyDiscoveredGCPProjects(t *testing.T) {
+	cm := &CredentialManager{configPath: filepath.Join(t.TempDir(), cloudAccountsFile), verifyAccess: acceptCredentials}
+	cm.config.Accounts = []CloudAccount{
+		{ID: "admin", Provider: "gcp", Credentials: &GCPCloudCredentials{Type: gcpServiceAccount, ProjectID: "admin", Principal: "sa@admin.iam.gserviceaccount.com"}},
+	}
+	cm.config.Discoveries = []Discovery{
+		{ID: "folders/123", Kind: discoveryGCPOrganization, SourceAccountID: "admin", Parent: "folders/123"},
+	}
+	discovery := &cm.config.Discoveries[0]
+
+	members := []discoveredAccount{}
+	for _, project := range []string{"project-a", "project-b"} {
+		members = append(members, discoveredAccount{
+			ProviderAccountID: project,
+			Name:              project,
+			Labels:            map[string]string{"folder": "Team", "gcp-project-id": project},
+			Active:            true,
+			Credentials:       &GCPCloudCredentials{Type: gcpServiceAccount, ProjectID: project, Principal: "sa@admin.iam.gserviceaccount.com", SourceAccountID: "admin"},
+		})
+	}
+
+	result, err := cm.applyDiscoveredMembers(discovery, members)
+	if err != nil {
+		t.Fatalf("applyDiscoveredMembers() error = %v", err)
+	}
+	// Projects sharing one principal are still separate accounts
+	if len(result.Added) != 2 {
+		t.Fatalf("added %v, want both projects", result.Added)
+	}
+	account := cm.findDiscoveredAccount(discovery.ID, "project-b")
+	if account == nil || account.Provider != "gcp" || account.Alias != "project-b" {
+		t.Fatalf("project-b account = %+v", account)
+	}
+	creds, err := account.gcpCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.SourceAccountID != "admin" || len(creds.Document) != 0 {
+		t.Errorf("project-b credentials = %+v, want them shared from admin", creds)
+	}
+}
+
+func TestDiscoverySource(t *testing.T) {
+	cm := &CredentialManager{}
+	cm.config.Accounts = []CloudAccount{
+		{ID: "aws-1", Alias: "management", Provider: "aws"},
+		{ID: "gcp-1", Alias: "admin", Provider: "gcp"},
+	}
+
+	source, err := cm.discoverySource("gcp", "", AddOptions{SourceAccount: "admin"})
+	if err != nil || source.ID != "gcp-1" {
+		t.Errorf("discoverySource(admin) = %v, %v; want gcp-1", source, err)
+	}
+	if _, err := cm.discoverySource("gcp", "", AddOptions{SourceAccount: "management"}); err == nil || !strings.Contains(err.Error(), "is not an GCP account") {
+		t.Errorf("discoverySource(management) error = %v, want a provider mismatch", err)
+	}
+	if _, err := cm.discoverySource("gcp", "", AddOptions{SourceAccount: "missing"}); err == nil {
+		t.Error("discoverySource(missing) succeeded, want an error")
+	}
+}
+
+func TestAddDiscoveryTwice(t *testing.T) {
+	cm, discovery := discoveryTestManager(t)
+
+	_, _, err := cm.addDiscovery(Discovery{ID: discovery.ID, Kind: discovery.Kind, SourceAccountID: "management"}, false)
+	if err == nil || !strings.Contains(err.Error(), "is already discovered") {
+		t.Errorf("addDiscovery() error = %v, want already discovered", err)
+	}
+	if len(cm.config.Discoveries) != 1 {
+		t.Errorf("%d discoveries, want 1", len(cm.config.Discoveries))
+	}
+}
+
+func TestAzureAccountCredentials(t *testing.T) {
+	tenant := &AzureCredentials{AuthType: "client_secret", SubscriptionID: "sub-admin", TenantID: "tenant", Name: "Admin", ClientID: "app", ClientSecret: "secret"}
+	cm := &CredentialManager{}
+	cm.config.Accounts = []CloudAccount{
+		{ID: "admin", Provider: "azure", Credentials: tenant},
+		{ID: "shared", Provider: "azure", Credentials: &AzureCredentials{SubscriptionID: "sub-shared", TenantID: "tenant", SourceAccountID: "admin"}},
+	}
+
+	creds, err := cm.azureAccountCredentials(&AzureCredentials{SubscriptionID: "sub-a", TenantID: "tenant", Name: "Team A", ClientID: "app", SourceAccountID: "admin"})
+	if err != nil {
+		t.Fatalf("azureAccountCredentials() error = %v", err)
+	}
+	want := *tenant
+	want.SubscriptionID, want.Name = "sub-a", "Team A"
+	if !reflect.DeepEqual(creds, &want) {
+		t.Errorf("azureAccountCredentials() = %+v, want %+v", creds, &want)
+	}
+	if tenant.SubscriptionID != "sub-admin" {
+		t.Error("azureAccountCredentials() modified the source account's credentials")
+	}
+
+	if own, err := cm.azureAccountCredentials(tenant); err != nil || own != tenant {
+		t.Errorf("azureAccountCredentials(own) = %v, %v; want the credentials unchanged", own, err)
+	}
+	if _, err := cm.azureAccountCredentials(&AzureCredentials{SubscriptionID: "sub-b", SourceAccountID: "shared"}); err == nil || !strings.Contains(err.Error(), "shares the credentials of another account") {
+		t.Errorf("azureAccountCredentials(chained) error = %v", err)
+	}
+}
+
+func TestAzureSubscriptionActive(t *testing.T) {
+	for state, want := range map[string]bool{"Enabled": true, "Warned": true, "PastDue": true, "Disabled": false, "Deleted": false, "Expired": false} {
+		if got := azureSubscriptionActive(state); got != want {
+			t.Errorf("azureSubscriptionActive(%s) = %v, want %v", state, got, want)
+		}
+	}
+}
+
+func TestCredentialsSourceAccountID(t *testing.T) {
+	tests := []struct {
+		creds interface{}
+		want  string
+	}{
+		{creds: &AWSCredentials{RoleARN: "arn:aws:iam::1:role/R", SourceAccountID: "aws-src"}, want: "aws-src"},
+		{creds: &GCPCloudCredentials{ProjectID: "p", SourceAccountID: "gcp-src"}, want: "gcp-src"},
+		{creds: &AzureCredentials{SubscriptionID: "s", SourceAccountID: "azure-src"}, want: "azure-src"},
+		{creds: &AzureCredentials{SubscriptionID: "s"}, want: ""},
+		{creds: nil, want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := credentialsSourceAccountID(tt.creds); got != tt.want {
+			t.Errorf("credentialsSourceAccountID(%+v) = %q, want %q", tt.creds, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// Environment variables used to supply the encryption key material
+	passphraseEnv = "YOGAYA_PASSPHRASE"
+	keyfileEnv    = "YOGAYA_KEYFILE"
+
+	encryptionCipher = "aes-256-gcm"
+	encryptionKDF    = "scrypt"
+
+	// keyCheckPlaintext is sealed into the config so a wrong key is detected on load
+	keyCheckPlaintext = "yogaya-key-check"
+
+	// Bounds of the scrypt parameters read from the config, so an edited file cannot
+	// make loading it take unbounded time or memory
+	maxScryptN      = 1 << 20
+	maxScryptRP     = 1 << 30
+	maxScryptMemory = 1 << 30
+)
+
+// EncryptionConfig describes how the credentials in cloud_accounts.conf are sealed
+type EncryptionConfig struct {
+	Cipher string             `json:"cipher"`
+	KDF    string             `json:"kdf"`
+	Salt   string             `json:"salt"`
+	N      int                `json:"n"`
+	R      int                `json:"r"`
+	P      int                `json:"p"`
+	Check  *SealedCredentials `json:"check"`
+}
+
+// SealedCredentials holds an encrypted credentials payload
+type SealedCredentials struct {
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// newEncryptionConfig creates encryption settings with a fresh salt and derives the key for them
+func newEncryptionConfig() (*EncryptionConfig, []byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	enc := &EncryptionConfig{
+		Cipher: encryptionCipher,
+		KDF:    encryptionKDF,
+		Salt:   base64.StdEncoding.EncodeToString(salt),
+		N:      1 << 15,
+		R:      8,
+		P:      1,
+	}
+
+	key, err := enc.deriveKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	enc.Check, err = sealData(key, []byte(keyCheckPlaintext), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, key, nil
+}
+
+// deriveKey derives the encryption key from the configured passphrase or keyfile
+func (enc *EncryptionConfig) deriveKey() ([]byte, error) {
+	if enc.Cipher != encryptionCipher || enc.KDF != encryptionKDF {
+		return nil, fmt.Errorf("unsupported encryption settings: cipher=%s kdf=%s", enc.Cipher, enc.KDF)
+	}
+
+	if err := enc.validateScryptParams(); err != nil {
+		return nil, err
+	}
+
+	secret, err := readKeyMaterial()
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(enc.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption salt: %v", err)
+	}
+
+	return scrypt.Key(secret, salt, enc.N, enc.R, enc.P, 32)
+}
+
+// validateScryptParams rejects scrypt parameters that scrypt does not accept or that
+// would need more than maxScryptMemory bytes
+func (enc *EncryptionConfig) validateScryptParams() error {
+	if enc.N <= 1 || enc.N > maxScryptN || enc.N&(enc.N-1) != 0 {
+		return fmt.Errorf("invalid encryption settings: n=%d must be a power of two between 2 and %d", enc.N, maxScryptN)
+	}
+	if enc.R < 1 || enc.P < 1 || enc.R >= maxScryptRP || enc.P >= maxScryptRP || uint64(enc.R)*uint64(enc.P) >= maxScryptRP {
+		return fmt.Errorf("invalid encryption settings: r=%d and p=%d must be at least 1 and r*p below %d", enc.R, enc.P, maxScryptRP)
+	}
+	if enc.R > maxScryptMemory/(128*enc.N) {
+		return fmt.Errorf("invalid encryption settings: n=%d and r=%d need more than %d MiB", enc.N, enc.R, maxScryptMemory>>20)
+	}
+	return nil
+}
+
+// verifyKey checks that the key opens the sealed check value
+func (enc *EncryptionConfig) verifyKey(key []byte) error {
+	if enc.Check == nil {
+		return fmt.Errorf("encryption settings are missing the key check")
+	}
+	plaintext, err := openData(key, enc.Check, nil)
+	if err != nil || string(plaintext) != keyCheckPlaintext {
+		return fmt.Errorf("wrong passphrase or keyfile for encrypted cloud_accounts.conf")
+	}
+	return nil
+}
+
+// readKeyMaterial reads the key material from the keyfile or passphrase environment variables
+func readKeyMaterial() ([]byte, error) {
+	if keyfile := os.Getenv(keyfileEnv); keyfile != "" {
+		data, err := os.ReadFile(keyfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keyfile: %v", err)
+		}
+		secret := strings.TrimSpace(string(data))
+		if secret == "" {
+			return nil, fmt.Errorf("keyfile %s is empty", keyfile)
+		}
+		return []byte(secret), nil
+	}
+
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	return nil, fmt.Errorf("cloud_accounts.conf encryption requires %s or %s to be set", passphraseEnv, keyfileEnv)
+}
+
+// sealCredentials encrypts credentials, binding the ciphertext to the account ID
+func sealCredentials(key []byte, accountID string, creds interface{}) (*SealedCredentials, error) {
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return nil, err
+	}
+	return sealData(key, plaintext, []byte(accountID))
+}
+
+// openCredentials decrypts credentials sealed by sealCredentials and returns their JSON
+func openCredentials(key []byte, accountID string, sealed *SealedCredentials) ([]byte, error) {
+	plaintext, err := openData(key, sealed, []byte(accountID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credentials for account %s: %v", accountID, err)
+	}
+	return plaintext, nil
+}
+
+// sealData encrypts plaintext with AES-256-GCM
+func sealData(key, plaintext, additionalData []byte) (*SealedCredentials, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	return &SealedCredentials{
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, additionalData)),
+	}, nil
+}
+
+// openData decrypts and authenticates data sealed by sealData
+func openData(key []byte, sealed *SealedCredentials, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(sealed.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %v", err)
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length")
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %v", err)
+	}
+
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,210 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// normalizedJSON decodes the JSON encoding of v into generic values, so credentials
+// can be compared whether they are held as structs or as decoded maps
+func normalizedJSON(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		t.Fatal(err)
+	}
+	return normalized
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	accounts := []CloudAccount{
+		{ID: "aws-1", Provider: "aws", Credentials: &AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "aws-secret", Region: "eu-west-1"}},
+		{ID: "azure-1", Provider: "azure", Credentials: &AzureCredentials{SubscriptionID: "sub", TenantID: "tenant"}},
+		{ID: "gcp-1", Provider: "gcp", Credentials: &GCPCloudCredentials{ProjectID: "project", PrivateKey: "gcp-secret", ClientEmail: "sa@project.iam.gserviceaccount.com"}},
+	}
+
+	tests := []struct {
+		name string
+		// sealEnv and openEnv are the key material when encrypting and when loading;
+		// "keyfile:<content>" writes a keyfile with that content
+		sealEnv string
+		openEnv string
+		// tamper edits the raw saved file before it is loaded
+		tamper  func(config map[string]interface{})
+		wantErr string
+	}{
+		{name: "passphrase", sealEnv: "correct horse", openEnv: "correct horse"},
+		{name: "keyfile", sealEnv: "keyfile:key material\n", openEnv: "keyfile:key material"},
+		{name: "keyfile and passphrase with the same secret", sealEnv: "keyfile:same", openEnv: "same"},
+		{name: "wrong passphrase", sealEnv: "correct horse", openEnv: "battery staple", wantErr: "wrong passphrase or keyfile"},
+		{name: "wrong keyfile", sealEnv: "keyfile:one", openEnv: "keyfile:two", wantErr: "wrong passphrase or keyfile"},
+		{name: "empty keyfile", sealEnv: "correct horse", openEnv: "keyfile:\n", wantErr: "is empty"},
+		{name: "no key material", sealEnv: "correct horse", openEnv: "", wantErr: "encryption requires " + passphraseEnv},
+		{
+			name:    "credentials moved to another account",
+			sealEnv: "correct horse",
+			openEnv: "correct horse",
+			tamper: func(config map[string]interface{}) {
+				accounts := config["accounts"].([]interface{})
+				first, second := accounts[0].(map[string]interface{}), accounts[1].(map[string]interface{})
+				first["sealed_credentials"], second["sealed_credentials"] = second["sealed_credentials"], first["sealed_credentials"]
+			},
+			wantErr: "failed to decrypt credentials for account aws-1",
+		},
+		{
+			name:    "scrypt parameters out of bounds",
+			sealEnv: "correct horse",
+			openEnv: "correct horse",
+			tamper: func(config map[string]interface{}) {
+				config["encryption"].(map[string]interface{})["n"] = 1 << 40
+			},
+			wantErr: "invalid encryption settings",
+		},
+		{
+			name:    "missing key check",
+			sealEnv: "correct horse",
+			openEnv: "correct horse",
+			tamper: func(config map[string]interface{}) {
+				delete(config["encryption"].(map[string]interface{}), "check")
+			},
+			wantErr: "missing the key check",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			setKeyMaterial := func(value string) {
+				t.Setenv(passphraseEnv, "")
+				t.Setenv(keyfileEnv, "")
+				if content, ok := strings.CutPrefix(value, "keyfile:"); ok {
+					keyfile := filepath.Join(dir, "keyfile")
+					if err := os.WriteFile(keyfile, []byte(content), 0600); err != nil {
+						t.Fatal(err)
+					}
+					t.Setenv(keyfileEnv, keyfile)
+					return
+				}
+				t.Setenv(passphraseEnv, value)
+			}
+
+			path := filepath.Join(dir, "cloud_accounts.conf")
+			// A current file, so loading it does not migrate the credentials
+			cm := &CredentialManager{configPath: path, config: CloudAccountsConfig{SchemaVersion: currentSchemaVersion}}
+			cm.config.Accounts = append(cm.config.Accounts, accounts...)
+
+			setKeyMaterial(tt.sealEnv)
+			if err := cm.EnableEncryption(); err != nil {
+				t.Fatalf("EnableEncryption() error = %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, secret := range []string{"aws-secret", "gcp-secret"} {
+				if strings.Contains(string(data), secret) {
+					t.Errorf("encrypted file contains %s in clear text", secret)
+				}
+			}
+
+			if tt.tamper != nil {
+				raw := map[string]interface{}{}
+				if err := json.Unmarshal(data, &raw); err != nil {
+					t.Fatal(err)
+				}
+				tt.tamper(raw)
+				if data, err = json.Marshal(raw); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, data, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			setKeyMaterial(tt.openEnv)
+			loaded := &CredentialManager{configPath: path}
+			err = loaded.loadConfig()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadConfig() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+
+			if len(loaded.config.Accounts) != len(accounts) {
+				t.Fatalf("loaded %d accounts, want %d", len(loaded.config.Accounts), len(accounts))
+			}
+			for i, account := range loaded.config.Accounts {
+				if account.SealedCredentials != nil {
+					t.Errorf("account %s still has sealed credentials after loading", account.ID)
+				}
+				got, want := normalizedJSON(t, account.Credentials), normalizedJSON(t, accounts[i].Credentials)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("credentials of %s = %v, want %v", account.ID, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEnableEncryptionTwice(t *testing.T) {
+	t.Setenv(passphraseEnv, "correct horse")
+	t.Setenv(keyfileEnv, "")
+
+	cm := &CredentialManager{configPath: filepath.Join(t.TempDir(), "cloud_accounts.conf")}
+	if err := cm.EnableEncryption(); err != nil {
+		t.Fatalf("EnableEncryption() error = %v", err)
+	}
+	if err := cm.EnableEncryption(); err == nil || !strings.Contains(err.Error(), "already encrypted") {
+		t.Errorf("second EnableEncryption() error = %v, want already encrypted", err)
+	}
+}
+
+func TestValidateScryptParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		n, r, p int
+		wantErr string
+	}{
+		{name: "defaults", n: 1 << 15, r: 8, p: 1},
+		{name: "largest n", n: 1 << 20, r: 8, p: 1},
+		{name: "n not a power of two", n: 1<<15 + 1, r: 8, p: 1, wantErr: "must be a power of two"},
+		{name: "n too large", n: 1 << 21, r: 8, p: 1, wantErr: "must be a power of two"},
+		{name: "n of one", n: 1, r: 8, p: 1, wantErr: "must be a power of two"},
+		{name: "zero r", n: 1 << 15, r: 0, p: 1, wantErr: "must be at least 1"},
+		{name: "negative p", n: 1 << 15, r: 8, p: -1, wantErr: "must be at least 1"},
+		{name: "r times p too large", n: 2, r: 1 << 15, p: 1 << 15, wantErr: "r*p below"},
+		{name: "too much memory", n: 1 << 20, r: 16, p: 1, wantErr: "need more than 1024 MiB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc := &EncryptionConfig{N: tt.n, R: tt.r, P: tt.p}
+			err := enc.validateScryptParams()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateScryptParams() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateScryptParams() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// GenerateFilters narrows the accounts, regions and resource types that generate processes.
+// Regions may be globs such as `eu-*`; resource types are Terraformer resource names.
+type GenerateFilters struct {
+	Providers        []string
+	Accounts         []string
+	Regions          []string
+	ExcludeRegions   []string
+	Resources        []string
+	ExcludeResources []string
+}
+
+var generateFilters GenerateFilters
+
+// validate rejects unknown providers and malformed globs
+func (f *GenerateFilters) validate() error {
+	for _, provider := range f.Providers {
+		switch provider {
+		case "aws", "gcp", "azure":
+		default:
+			return fmt.Errorf("unsupported provider in --provider: %s", provider)
+		}
+	}
+	for _, pattern := range append(append([]string{}, f.Regions...), f.ExcludeRegions...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid region pattern %q: %v", pattern, err)
+		}
+	}
+	for _, pattern := range append(append([]string{}, f.Resources...), f.ExcludeResources...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid resource pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// filterAccounts returns the accounts matching --provider and --account.
+// Every --account must name a configured account.
+func (f *GenerateFilters) filterAccounts(cm *CredentialManager, accounts []CloudAccount) ([]CloudAccount, error) {
+	ids := map[string]bool{}
+	for _, idOrAlias := range f.Accounts {
+		account, err := cm.FindAccount(idOrAlias)
+		if err != nil {
+			return nil, err
+		}
+		ids[account.ID] = true
+	}
+
+	filtered := []CloudAccount{}
+	for _, account := range accounts {
+		if len(f.Providers) > 0 && !containsString(f.Providers, account.Provider) {
+			continue
+		}
+		if len(ids) > 0 && !ids[account.ID] {
+			continue
+		}
+		filtered = append(filtered, account)
+	}
+	return filtered, nil
+}
+
+// validateResourceNames rejects globs in --resources and --exclude-resources when accounts
+// of providers whose resources are selected by Terraformer itself (AWS and GCP) are
+// generated: Terraformer takes exact names, and yogaya has no list to expand globs against.
+func (f *GenerateFilters) validateResourceNames(accounts []CloudAccount) error {
+	for _, account := range accounts {
+		if account.Provider != "aws" && account.Provider != "gcp" {
+			continue
+		}
+		for _, name := range append(append([]string{}, f.Resources...), f.ExcludeResources...) {
+			if strings.ContainsAny(name, `*?[\`) {
+				return fmt.Errorf("resource pattern %q is a glob, which only Azure accounts support; list the %s resource types by name or narrow the accounts with --provider", name, account.Provider)
+			}
+		}
+	}
+	return nil
+}
+
+// selectsRegions reports whether only some regions are generated
+func (f *GenerateFilters) selectsRegions() bool {
+	return len(f.Regions)+len(f.ExcludeRegions) > 0
+}
+
+// selectsResources reports whether only some resource types are generated
+func (f *GenerateFilters) selectsResources() bool {
+	return len(f.Resources)+len(f.ExcludeResources) > 0
+}
+
+// partial reports whether a run only regenerates part of an account: some of its regions
+// or some of its resource types. The code of everything else has to be kept.
+func (f *GenerateFilters) partial() bool {
+	return f.selectsRegions() || f.selectsResources()
+}
+
+// affectsService reports whether a run imports a service, so that its previous code is replaced
+func (f *GenerateFilters) affectsService(service string) bool {
+	return len(filterNames([]string{service}, f.Resources, f.ExcludeResources)) == 1
+}
+
+// filterRegions returns the regions matching --region and not matching --exclude-region
+func (f *GenerateFilters) filterRegions(regions []string) []string {
+	return filterNames(regions, f.Regions, f.ExcludeRegions)
+}
+
+// filterServices returns the services matching --resources and not matching --exclude-resources.
+// It is used where the runner knows the full list of services (Azure).
+func (f *GenerateFilters) filterServices(services []string) []string {
+	return filterNames(services, f.Resources, f.ExcludeResources)
+}
+
+// terraformerResourceArgs returns the --resources and --excludes arguments of a Terraformer import
+// for providers whose services are selected by Terraformer itself (AWS and GCP)
+func (f *GenerateFilters) terraformerResourceArgs() []string {
+	resources := "*"
+	if len(f.Resources) > 0 {
+		resources = strings.Join(f.Resources, ",")
+	}
+	args := []string{"--resources=" + resources}
+	if len(f.ExcludeResources) > 0 {
+		args = append(args, "--excludes="+strings.Join(f.ExcludeResources, ","))
+	}
+	return args
+}
+
+// filterNames keeps the names matching any include pattern (all names without includes)
+// and no exclude pattern
+func filterNames(names, include, exclude []string) []string {
+	filtered := []string{}
+	for _, name := range names {
+		if len(include) > 0 && !matchesAny(include, name) {
+			continue
+		}
+		if matchesAny(exclude, name) {
+			continue
+		}
+		filtered = append(filtered, name)
+	}
+	return filtered
+}
+
+// matchesAny reports whether name matches any of the glob patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFilterNames(t *testing.T) {
+	regions := []string{"eu-west-1", "eu-west-2", "eu-central-1", "us-east-1", "us-west-2", "ap-southeast-1"}
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{name: "no patterns", want: regions},
+		{name: "exact name", include: []string{"us-east-1"}, want: []string{"us-east-1"}},
+		{name: "prefix glob", include: []string{"eu-*"}, want: []string{"eu-west-1", "eu-west-2", "eu-central-1"}},
+		{name: "several includes", include: []string{"eu-west-?", "us-*"}, want: []string{"eu-west-1", "eu-west-2", "us-east-1", "us-west-2"}},
+		{name: "character class", include: []string{"eu-west-[2-9]"}, want: []string{"eu-west-2"}},
+		{name: "exclude only", exclude: []string{"eu-*", "ap-*"}, want: []string{"us-east-1", "us-west-2"}},
+		{name: "exclude wins over include", include: []string{"eu-*"}, exclude: []string{"*-central-*"}, want: []string{"eu-west-1", "eu-west-2"}},
+		{name: "glob spanning dashes", include: []string{"*west*"}, want: []string{"eu-west-1", "eu-west-2", "us-west-2"}},
+		{name: "no match", include: []string{"sa-*"}, want: []string{}},
+		{name: "glob must match the whole name", include: []string{"eu-west"}, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filterNames(regions, tt.include, tt.exclude); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterNames(%v, %v) = %v, want %v", tt.include, tt.exclude, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateFiltersValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters GenerateFilters
+		wantErr string
+	}{
+		{name: "empty", filters: GenerateFilters{}},
+		{name: "globs", filters: GenerateFilters{Providers: []string{"aws", "azure"}, Regions: []string{"eu-*"}, ExcludeResources: []string{"network_*"}}},
+		{name: "unknown provider", filters: GenerateFilters{Providers: []string{"oci"}}, wantErr: "unsupported provider in --provider: oci"},
+		{name: "malformed region glob", filters: GenerateFilters{ExcludeRegions: []string{"eu-[west"}}, wantErr: `invalid region pattern "eu-[west"`},
+		{name: "malformed resource glob", filters: GenerateFilters{Resources: []string{"vpc", "sub[net"}}, wantErr: `invalid resource pattern "sub[net"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.filters.validate()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("validate() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("validate() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateResourceNames(t *testing.T) {
+	aws := CloudAccount{ID: "a1", Provider: "aws"}
+	gcp := CloudAccount{ID: "g1", Provider: "gcp"}
+	azure := CloudAccount{ID: "z1", Provider: "azure"}
+
+	tests := []struct {
+		name     string
+		filters  GenerateFilters
+		accounts []CloudAccount
+		wantErr  string
+	}{
+		{name: "names for aws", filters: GenerateFilters{Resources: []string{"vpc", "subnet"}}, accounts: []CloudAccount{aws}},
+		{name: "globs for azure", filters: GenerateFilters{Resources: []string{"network_*"}, ExcludeResources: []string{"?ns"}}, accounts: []CloudAccount{azure}},
+		{name: "star for aws", filters: GenerateFilters{Resources: []string{"ec2_*"}}, accounts: []CloudAccount{azure, aws}, wantErr: `resource pattern "ec2_*" is a glob`},
+		{name: "question mark for gcp", filters: GenerateFilters{Resources: []string{"gc?"}}, accounts: []CloudAccount{gcp}, wantErr: "list the gcp resource types by name"},
+		{name: "character class in excludes", filters: GenerateFilters{ExcludeResources: []string{"[a-z]*"}}, accounts: []CloudAccount{aws}, wantErr: "is a glob"},
+		{name: "escape", filters: GenerateFilters{Resources: []string{`s\3`}}, accounts: []CloudAccount{gcp}, wantErr: "is a glob"},
+		{name: "no accounts", filters: GenerateFilters{Resources: []string{"*"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.filters.validateResourceNames(tt.accounts)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("validateResourceNames() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("validateResourceNames() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateFiltersPartial(t *testing.T) {
+	tests := []struct {
+		name     string
+		filters  GenerateFilters
+		partial  bool
+		affected map[string]bool
+	}{
+		{
+			name:     "whole account",
+			filters:  GenerateFilters{Providers: []string{"aws"}, Accounts: []string{"prod"}},
+			affected: map[string]bool{"vpc": true, "s3": true},
+		},
+		{
+			name:     "regions",
+			filters:  GenerateFilters{Regions: []string{"eu-*"}},
+			partial:  true,
+			affected: map[string]bool{"vpc": true, "s3": true},
+		},
+		{
+			name:     "excluded regions",
+			filters:  GenerateFilters{ExcludeRegions: []string{"us-east-1"}},
+			partial:  true,
+			affected: map[string]bool{"vpc": true},
+		},
+		{
+			name:     "resources",
+			filters:  GenerateFilters{Resources: []string{"vpc", "network_*"}},
+			partial:  true,
+			affected: map[string]bool{"vpc": true, "network_interface": true, "s3": false},
+		},
+		{
+			name:     "excluded resources",
+			filters:  GenerateFilters{Resources: []string{"network_*"}, ExcludeResources: []string{"network_watcher"}},
+			partial:  true,
+			affected: map[string]bool{"network_interface": true, "network_watcher": false, "vpc": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filters.partial(); got != tt.partial {
+				t.Errorf("partial() = %v, want %v", got, tt.partial)
+			}
+			for service, want := range tt.affected {
+				if got := tt.filters.affectsService(service); got != want {
+					t.Errorf("affectsService(%q) = %v, want %v", service, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTerraformerResourceArgs(t *testing.T) {
+	tests := []struct {
+		filters GenerateFilters
+		want    []string
+	}{
+		{filters: GenerateFilters{}, want: []string{"--resources=*"}},
+		{filters: GenerateFilters{Resources: []string{"vpc", "subnet"}}, want: []string{"--resources=vpc,subnet"}},
+		{filters: GenerateFilters{ExcludeResources: []string{"s3", "iam"}}, want: []string{"--resources=*", "--excludes=s3,iam"}},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filters.terraformerResourceArgs(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("terraformerResourceArgs() of %+v = %v, want %v", tt.filters, got, tt.want)
+		}
+	}
+}
+
+func TestFilterAccounts(t *testing.T) {
+	accounts := []CloudAccount{
+		{ID: "a1", Alias: "prod", Provider: "aws"},
+		{ID: "a2", Provider: "aws"},
+		{ID: "g1", Provider: "gcp"},
+		{ID: "z1", Provider: "azure"},
+	}
+	cm := &CredentialManager{config: CloudAccountsConfig{Accounts: accounts}}
+
+	tests := []struct {
+		name    string
+		filters GenerateFilters
+		want    []string
+		wantErr string
+	}{
+		{name: "no filters", want: []string{"a1", "a2", "g1", "z1"}},
+		{name: "providers", filters: GenerateFilters{Providers: []string{"aws", "azure"}}, want: []string{"a1", "a2", "z1"}},
+		{name: "accounts by ID and alias", filters: GenerateFilters{Accounts: []string{"prod", "g1"}}, want: []string{"a1", "g1"}},
+		{name: "providers and accounts", filters: GenerateFilters{Providers: []string{"gcp"}, Accounts: []string{"prod", "g1"}}, want: []string{"g1"}},
+		{name: "unknown account", filters: GenerateFilters{Accounts: []string{"staging"}}, wantErr: "account not found: staging"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filtered, err := tt.filters.filterAccounts(cm, accounts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("filterAccounts() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("filterAccounts() error = %v", err)
+			}
+			got := []string{}
+			for _, account := range filtered {
+				got = append(got, account.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterAccounts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
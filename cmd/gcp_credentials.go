@@ -0,0 +1,144 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Google credential document types
+const (
+	gcpServiceAccount             = "service_account"
+	gcpAuthorizedUser             = "authorized_user"
+	gcpExternalAccount            = "external_account"
+	gcpImpersonatedServiceAccount = "impersonated_service_account"
+)
+
+// gcpCredentialDocument holds the fields of a Google credential document that identify it
+type gcpCredentialDocument struct {
+	Type                           string `json:"type"`
+	ProjectID                      string `json:"project_id"`
+	QuotaProjectID                 string `json:"quota_project_id"`
+	ClientEmail                    string `json:"client_email"`
+	ClientID                       string `json:"client_id"`
+	RefreshToken                   string `json:"refresh_token"`
+	Audience                       string `json:"audience"`
+	ServiceAccountImpersonationURL string `json:"service_account_impersonation_url"`
+}
+
+// parseGCPCredentials parses a Google credential document from JSON format.
+// The document is kept unchanged so every credential type can be passed on as is.
+func parseGCPCredentials(data []byte, opts AddOptions) (*GCPCloudCredentials, error) {
+	var doc gcpCredentialDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("unable to decode GCP credentials JSON: %v", err)
+	}
+
+	principal, err := gcpPrincipal(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	projectID := opts.GCPProject
+	if projectID == "" {
+		projectID = doc.ProjectID
+	}
+	if projectID == "" {
+		projectID = doc.QuotaProjectID
+	}
+	if projectID == "" {
+		return nil, fmt.Errorf("%s credentials do not name a project; pass --project", doc.Type)
+	}
+
+	return &GCPCloudCredentials{
+		Type:      doc.Type,
+		ProjectID: projectID,
+		Principal: principal,
+		Document:  json.RawMessage(data),
+	}, nil
+}
+
+// gcpPrincipal returns a stable, non-secret identifier of the identity behind a credential document
+func gcpPrincipal(doc *gcpCredentialDocument) (string, error) {
+	switch doc.Type {
+	case gcpServiceAccount:
+		if doc.ClientEmail == "" {
+			return "", fmt.Errorf("service_account credentials have no client_email")
+		}
+		return doc.ClientEmail, nil
+	case gcpAuthorizedUser:
+		if doc.RefreshToken == "" {
+			return "", fmt.Errorf("authorized_user credentials have no refresh_token")
+		}
+		// gcloud uses one client ID for every user, so the refresh token tells users apart
+		hash := sha256.Sum256([]byte(doc.RefreshToken))
+		return doc.ClientID + ":" + hex.EncodeToString(hash[:])[:12], nil
+	case gcpExternalAccount:
+		if doc.Audience == "" {
+			return "", fmt.Errorf("external_account credentials have no audience")
+		}
+		if email := impersonatedServiceAccountEmail(doc.ServiceAccountImpersonationURL); email != "" {
+			return email, nil
+		}
+		return doc.Audience, nil
+	case gcpImpersonatedServiceAccount:
+		email := impersonatedServiceAccountEmail(doc.ServiceAccountImpersonationURL)
+		if email == "" {
+			return "", fmt.Errorf("impersonated_service_account credentials have no service_account_impersonation_url")
+		}
+		return email, nil
+	case "":
+		return "", fmt.Errorf("GCP credentials have no type")
+	default:
+		return "", fmt.Errorf("unsupported GCP credentials type: %s", doc.Type)
+	}
+}
+
+// impersonatedServiceAccountEmail extracts the service account from
+// .../serviceAccounts/<email>:generateAccessToken
+func impersonatedServiceAccountEmail(url string) string {
+	_, rest, found := strings.Cut(url, "/serviceAccounts/")
+	if !found {
+		return ""
+	}
+	email, _, _ := strings.Cut(rest, ":")
+	return email
+}
+
+// credentialsJSON returns the Google credential document of the account.
+// Accounts added before documents were kept only have the service account fields.
+func (creds *GCPCloudCredentials) credentialsJSON() ([]byte, error) {
+	if len(creds.Document) > 0 {
+		return creds.Document, nil
+	}
+
+	if creds.PrivateKey == "" || creds.ClientEmail == "" {
+		return nil, fmt.Errorf("GCP credentials have neither a credential document nor a service account key")
+	}
+
+	return json.MarshalIndent(map[string]string{
+		"type":                        gcpServiceAccount,
+		"project_id":                  creds.ProjectID,
+		"private_key_id":              creds.PrivateKeyID,
+		"private_key":                 creds.PrivateKey,
+		"client_email":                creds.ClientEmail,
+		"client_id":                   creds.ClientID,
+		"auth_uri":                    "https://accounts.google.com/o/oauth2/auth",
+		"token_uri":                   "https://oauth2.googleapis.com/token",
+		"auth_provider_x509_cert_url": "https://www.googleapis.com/oauth2/v1/certs",
+		"client_x509_cert_url":        "https://www.googleapis.com/robot/v1/metadata/x509/" + creds.ClientEmail,
+	}, "", "  ")
+}
+
+// principal returns the identity of the account, falling back to the legacy client email
+func (creds *GCPCloudCredentials) principal() string {
+	if creds.Principal != "" {
+		return creds.Principal
+	}
+	return creds.ClientEmail
+}
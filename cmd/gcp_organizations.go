@@ -0,0 +1,149 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/option"
+)
+
+// AddGCPOrganization registers every project below an organization or folder as its own
+// account. The projects share the credentials of the account that lists them.
+func (cm *CredentialManager) AddGCPOrganization(credentialsPath string, opts AddOptions) (*Discovery, *SyncResult, error) {
+	if !strings.HasPrefix(opts.GCPParent, "organizations/") && !strings.HasPrefix(opts.GCPParent, "folders/") {
+		return nil, nil, fmt.Errorf("gcp-org requires --parent organizations/<id> or folders/<id>")
+	}
+
+	source, err := cm.discoverySource("gcp", credentialsPath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cm.addDiscovery(Discovery{
+		ID:              opts.GCPParent,
+		Kind:            discoveryGCPOrganization,
+		SourceAccountID: source.ID,
+		Parent:          opts.GCPParent,
+		AllowWrite:      opts.AllowWrite,
+	}, opts.Sync)
+}
+
+// gcpCredentialsJSON returns the credential document of a GCP account,
+// taken from its source account when it shares that account's credentials
+func (cm *CredentialManager) gcpCredentialsJSON(creds *GCPCloudCredentials) ([]byte, error) {
+	if creds.SourceAccountID == "" {
+		return creds.credentialsJSON()
+	}
+
+	source, err := cm.FindAccount(creds.SourceAccountID)
+	if err != nil {
+		return nil, fmt.Errorf("source account of GCP project %s: %v", creds.ProjectID, err)
+	}
+	if err := cm.loadCredentials(source); err != nil {
+		return nil, err
+	}
+	sourceCreds, err := source.gcpCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if sourceCreds.SourceAccountID != "" {
+		return nil, fmt.Errorf("source account %s shares the credentials of another account", source.ID)
+	}
+	return sourceCreds.credentialsJSON()
+}
+
+// listGCPOrganizationProjects walks the folders below the discovery's parent and
+// returns every project with its folder path
+func (cm *CredentialManager) listGCPOrganizationProjects(discovery *Discovery) ([]discoveredAccount, error) {
+	ctx := context.Background()
+
+	source, err := cm.FindAccount(discovery.SourceAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if err := cm.loadCredentials(source); err != nil {
+		return nil, err
+	}
+	sourceCreds, err := source.gcpCredentials()
+	if err != nil {
+		return nil, err
+	}
+	credJSON, err := cm.gcpCredentialsJSON(sourceCreds)
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := cloudresourcemanager.NewService(ctx, option.WithCredentialsJSON(credJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Resource Manager client: %v", err)
+	}
+
+	members := []discoveredAccount{}
+	err = walkGCPFolder(ctx, service, discovery.Parent, "", func(project *cloudresourcemanager.Project, folderPath string) {
+		// The source account already covers its own project
+		if project.ProjectId == sourceCreds.ProjectID {
+			return
+		}
+		members = append(members, discoveredAccount{
+			ProviderAccountID: project.ProjectId,
+			Name:              project.ProjectId,
+			Labels: map[string]string{
+				"folder":         folderPath,
+				"gcp-project-id": project.ProjectId,
+			},
+			Active: project.State == "ACTIVE",
+			Credentials: &GCPCloudCredentials{
+				Type:            sourceCreds.Type,
+				ProjectID:       project.ProjectId,
+				Principal:       sourceCreds.principal(),
+				SourceAccountID: source.ID,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Found %d projects under %s", len(members), discovery.Parent)
+	return members, nil
+}
+
+// walkGCPFolder calls visit for every project below an organization or folder.
+// path holds the display names of the folders between the discovery's parent and the project.
+func walkGCPFolder(ctx context.Context, service *cloudresourcemanager.Service, parent, path string, visit func(*cloudresourcemanager.Project, string)) error {
+	err := service.Projects.List().Parent(parent).ShowDeleted(true).Pages(ctx, func(page *cloudresourcemanager.ListProjectsResponse) error {
+		for _, project := range page.Projects {
+			visit(project, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list projects of %s: %v", parent, err)
+	}
+
+	folders := []*cloudresourcemanager.Folder{}
+	err = service.Folders.List().Parent(parent).Pages(ctx, func(page *cloudresourcemanager.ListFoldersResponse) error {
+		folders = append(folders, page.Folders...)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list folders of %s: %v", parent, err)
+	}
+
+	for _, folder := range folders {
+		folderPath := folder.DisplayName
+		if path != "" {
+			folderPath = path + "/" + folder.DisplayName
+		}
+		if err := walkGCPFolder(ctx, service, folder.Name, folderPath, visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,11 +4,14 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -17,22 +20,77 @@ import (
 var generateCmd = &cobra.Command{
 	Use:   "generate [.sample/cloud_accounts.conf-file-path]",
 	Short: "Generate Terraform code from cloud resources",
-	Run:   generateCommand,
+	Long: "Generate Terraform code from cloud resources\n\n" +
+		"Code is written to <workspace>/generated, e.g. .yogaya/generated, or to the root set\n" +
+		"with `yogaya config output` or --output-dir. Earlier versions wrote the code of the\n" +
+		"default workspace to generated next to .yogaya, outside its history; that directory\n" +
+		"is no longer updated.\n",
+	PreRunE: validateGenerateFlags,
+	Run:     generateCommand,
 }
 
+var (
+	generateSelector     string
+	generateNoSync       bool
+	generatePathTemplate string
+	generateRetryDelay   time.Duration
+)
+
 func init() {
-	rootCmd.DisableFlagParsing = true
+	generateCmd.Flags().StringVarP(&generateSelector, "selector", "l", "", "only generate accounts matching the label selector (e.g. env=prod,provider=aws)")
+	generateCmd.Flags().StringSliceVar(&generateFilters.Providers, "provider", nil, "only generate accounts of these providers (aws|gcp|azure)")
+	generateCmd.Flags().StringSliceVar(&generateFilters.Accounts, "account", nil, "only generate these accounts, by ID or alias")
+	generateCmd.Flags().StringSliceVar(&generateFilters.Regions, "region", nil, "only generate these regions; globs such as 'eu-*' are allowed (AWS and GCP)")
+	generateCmd.Flags().StringSliceVar(&generateFilters.ExcludeRegions, "exclude-region", nil, "skip these regions; globs are allowed (AWS and GCP)")
+	generateCmd.Flags().StringSliceVar(&generateFilters.Resources, "resources", nil, "only import these Terraformer resource types (e.g. vpc,subnet); globs are allowed for Azure only")
+	generateCmd.Flags().StringSliceVar(&generateFilters.ExcludeResources, "exclude-resources", nil, "skip these Terraformer resource types; globs are allowed for Azure only")
+	generateCmd.Flags().StringVar(&generatePathTemplate, "path-template", "", "directory of each account's code below the output root, e.g. '{provider}/{alias}/{region}' (default from `yogaya config output`)")
+	generateCmd.Flags().IntVar(&schedulerLimits.Global, "concurrency", defaultConcurrency, "number of regions and services imported at once across all accounts")
+	generateCmd.Flags().StringToIntVar(&schedulerLimits.Providers, "provider-concurrency", nil, "limit the imports running at once per provider (e.g. aws=4,azure=2)")
+	generateCmd.Flags().DurationVar(&initPolicy.Timeout, "init-timeout", initPolicy.Timeout, "time limit of one terraform init attempt (0 for none)")
+	generateCmd.Flags().IntVar(&initPolicy.Retries, "init-retries", initPolicy.Retries, "retries of a terraform init that failed because of a transient error")
+	generateCmd.Flags().DurationVar(&importPolicy.Timeout, "import-timeout", importPolicy.Timeout, "time limit of one terraformer import attempt (0 for none)")
+	generateCmd.Flags().IntVar(&importPolicy.Retries, "import-retries", importPolicy.Retries, "retries of a terraformer import that was throttled, timed out or hit a network failure")
+	generateCmd.Flags().DurationVar(&generateRetryDelay, "retry-delay", importPolicy.Delay, "backoff before the first retry; it doubles with every further retry, up to 5m")
+	generateCmd.Flags().StringArrayVar(&retryPatterns, "retry-pattern", nil, "also retry steps whose output matches this regular expression")
+	generateCmd.Flags().BoolVar(&generateNoSync, "no-sync", false, "do not sync discovered organizations and subscriptions before generating")
 	rootCmd.AddCommand(generateCmd)
 }
 
+// validateGenerateFlags rejects invalid filters, limits and retry settings before any
+// account is touched, and compiles the --retry-pattern expressions
+func validateGenerateFlags(cmd *cobra.Command, args []string) error {
+	if err := generateFilters.validate(); err != nil {
+		return err
+	}
+	if err := schedulerLimits.validate(); err != nil {
+		return err
+	}
+	initPolicy.Delay, importPolicy.Delay = generateRetryDelay, generateRetryDelay
+	if err := initPolicy.validate("terraform init"); err != nil {
+		return err
+	}
+	if err := importPolicy.validate("terraformer import"); err != nil {
+		return err
+	}
+	return compileRetryPatterns()
+}
+
 // runGenerate handles the main generation process
 func generateCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "config")
 	if len(args) != 1 {
-		fmt.Println("Usage: sample generate <.sample/cloud_accounts.conf-file-path>")
+		fmt.Println("Usage: yogaya generate [.yogaya/cloud_accounts.conf-file-path]")
 		return
 	}
 
 	credFilePath := args[0]
+
+	selector, err := parseSelector(generateSelector)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
 	log.Printf("Starting Terraform code generation using credentials from: %s", credFilePath)
 
 	// Load the credentials file
@@ -43,56 +101,163 @@ func generateCommand(cmd *cobra.Command, args []string) {
 	}
 	log.Printf("✅ Successfully loaded credentials for %d accounts", len(cm.config.Accounts))
 
+	layout, err := cm.outputLayout(cmd.Flags().Changed("output-dir"), generatePathTemplate)
+	if err != nil {
+		log.Fatalf("❌ Error: %v", err)
+		return
+	}
+	debugf("Writing generated code to %s with path template %s", layout.Root, layout.PathTemplate)
+	if legacy := legacyOutputDir(workspaceOfConfig(credFilePath)); legacy != "" && !isWithinDir(layout.Root, legacy) {
+		log.Printf("⚠️ Generated code is now written to %s so the workspace history records it; %s from earlier versions is no longer updated and can be moved or deleted", layout.Root, legacy)
+	}
+
+	// Pick up accounts created or closed since the last run
+	if !generateNoSync && len(cm.config.Discoveries) > 0 {
+		cm.syncAllDiscoveries()
+	}
+
+	accounts, err := generateFilters.filterAccounts(cm, cm.SelectAccounts(selector))
+	if err != nil {
+		log.Fatalf("❌ Error: %v", err)
+		return
+	}
+	if err := generateFilters.validateResourceNames(accounts); err != nil {
+		log.Fatalf("❌ Error: %v", err)
+		return
+	}
+	if len(accounts) < len(cm.config.Accounts) {
+		log.Printf("Selected %d of %d accounts", len(accounts), len(cm.config.Accounts))
+	}
+
 	homeDir, err := os.UserHomeDir()
 	pluginDir := filepath.Join(homeDir, ".terraform.d", "plugins", "darwin_arm64")
 	// Create directory with all parent directories if they don't exist
 	err = os.MkdirAll(pluginDir, 0755)
 
 	errFlag := false
+	started := time.Now()
+
+	// Accounts run side by side; their regions and services share the scheduler's workers
+	ctx := cmd.Context()
+	scheduler := NewScheduler(ctx, schedulerLimits)
+	results := make([]*GenerateRecord, len(accounts))
+	var wg sync.WaitGroup
+	for i, account := range accounts {
+		if account.Status != "" {
+			log.Printf("⚠️ Skipping account %s: %s", account.displayName(), account.Status)
+			continue
+		}
 
-	// Iterate over each cloud account and run Terraformer
-	for i, account := range cm.config.Accounts {
-		if i > 0 {
-			log.Println("------------------------------------------------------------")
+		wg.Add(1)
+		go func(i int, account CloudAccount) {
+			defer wg.Done()
+			log.Printf("Processing account %d/%d: %s (%s)", i+1, len(accounts), account.displayName(), account.Provider)
+			results[i] = generateAccount(ctx, cm, account, layout, scheduler)
+		}(i, account)
+	}
+	wg.Wait()
+	scheduler.Close()
+
+	records := []GenerateRecord{}
+	for _, record := range results {
+		if record == nil {
+			continue
 		}
-		log.Printf("Processing account %d/%d: %s (%s)", i+1, len(cm.config.Accounts), account.ID, account.Provider)
-
-		switch account.Provider {
-		case "aws":
-			if err := runTerraformerAWS(account); err != nil {
-				errFlag = true
-				log.Printf("❌ Error generating Terraform code for AWS account %s: %v", account.ID, err)
-			} else {
-				log.Printf("✅ Successfully generated Terraform code for AWS account %s", account.ID)
-			}
-		case "gcp":
-			if err := runTerraformerGCP(account); err != nil {
-				errFlag = true
-				log.Printf("❌ Error generating Terraform code for GCP account %s: %v", account.ID, err)
-			} else {
-				log.Printf("✅ Successfully generated Terraform code for GCP account %s", account.ID)
-			}
-		case "azure":
-			if err := runTerraformerAzure(account); err != nil {
-				errFlag = true
-				log.Printf("❌ Error generating Terraform code for Azure account %s: %v", account.ID, err)
-			} else {
-				log.Printf("✅ Successfully generated Terraform code for Azure account %s", account.ID)
-			}
-		default:
-			log.Printf("⚠️ Skipping unsupported provider: %s", account.Provider)
+		if record.Err != nil {
+			errFlag = true
 		}
+		records = append(records, *record)
 	}
-	if !errFlag {
+	cancelled := ctx.Err() != nil
+	if cancelled {
+		// Interrupted accounts were restored; only the accounts that finished changed the output
+		completed := []GenerateRecord{}
+		for _, record := range records {
+			if record.Err == nil {
+				completed = append(completed, record)
+			}
+		}
+		records = completed
+		log.Printf("⚠️ Generation cancelled; the previous code of interrupted accounts was restored and %d completed accounts are recorded in the history", len(records))
+	} else if !errFlag {
 		log.Println("Generation process completed")
 	}
+
+	if len(records) > 0 {
+		cm.commitHistory(generateCommitMessage(records, cancelled, time.Since(started)))
+	}
 }
 
-// RenameDirWithBackup renames a directory by adding "_bk" suffix if it already exists
-func RenameDirWithBackup(dirPath string) error {
+// generateAccount runs Terraformer for one account. It returns nil for accounts of unsupported providers.
+// When ctx is cancelled before the account has finished, its previous output is restored.
+func generateAccount(ctx context.Context, cm *CredentialManager, account CloudAccount, layout *OutputLayout, scheduler *Scheduler) *GenerateRecord {
+	record := &GenerateRecord{Account: account}
+	accountStarted := time.Now()
+
+	// The attempts of every step of the account are recorded with it
+	steps := &stepLog{}
+	ctx = withStepLog(ctx, steps)
+
+	if err := cm.loadCredentials(&account); err != nil {
+		log.Printf("❌ Error loading credentials for account %s: %v", account.ID, err)
+		record.Err = err
+		return record
+	}
+
+	output, err := newAccountOutput(layout, account, &generateFilters)
+	if err != nil {
+		log.Printf("❌ Error preparing the output of account %s: %v", account.ID, err)
+		record.Err = err
+		return record
+	}
+	switch account.Provider {
+	case "aws":
+		if err := runTerraformerAWS(ctx, cm, account, output, scheduler); err != nil {
+			record.Err = err
+			log.Printf("❌ Error generating Terraform code for AWS account %s: %v", account.ID, err)
+		} else {
+			log.Printf("✅ Successfully generated Terraform code for AWS account %s", account.ID)
+		}
+	case "gcp":
+		if err := runTerraformerGCP(ctx, cm, account, output, scheduler); err != nil {
+			record.Err = err
+			log.Printf("❌ Error generating Terraform code for GCP account %s: %v", account.ID, err)
+		} else {
+			log.Printf("✅ Successfully generated Terraform code for GCP account %s", account.ID)
+		}
+	case "azure":
+		if err := runTerraformerAzure(ctx, cm, account, output, scheduler); err != nil {
+			record.Err = err
+			log.Printf("❌ Error generating Terraform code for Azure account %s: %v", account.ID, err)
+		} else {
+			log.Printf("✅ Successfully generated Terraform code for Azure account %s", account.ID)
+		}
+	default:
+		log.Printf("⚠️ Skipping unsupported provider: %s", account.Provider)
+		return nil
+	}
+
+	// A cancelled account is put back the way it was; finished accounts keep their new code
+	if record.Err != nil && ctx.Err() != nil {
+		if err := output.rollback(); err != nil {
+			log.Printf("❌ Error restoring the previous output of account %s: %v", account.ID, err)
+		} else {
+			log.Printf("⚠️ Generation of account %s was cancelled; its previous output was restored", account.ID)
+		}
+	}
+
+	record.Duration = time.Since(accountStarted)
+	record.Regions, record.Resources = output.summary()
+	record.Retries = steps.retried()
+	return record
+}
+
+// RenameDirWithBackup renames a directory by adding "_bk" suffix if it already exists.
+// It returns the path of the backup, or "" when there was nothing to back up.
+func RenameDirWithBackup(dirPath string) (string, error) {
 	// Check if directory exists
 	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-		return nil
+		return "", nil
 	}
 
 	// Generate new path name
@@ -111,26 +276,10 @@ func RenameDirWithBackup(dirPath string) error {
 
 	// Execute rename operation
 	if err := os.Rename(dirPath, backupPath); err != nil {
-		return fmt.Errorf("failed to rename %v directory: %v", dirPath, err)
+		return "", fmt.Errorf("failed to rename %v directory: %v", dirPath, err)
 	}
 	log.Printf("✅ %v move to %v\n", dirPath, backupPath)
-	return nil
-}
-
-func removedWorkDir(workingFile, regionDir, provider string) error {
-	workingDir := filepath.Join(regionDir, provider)
-	// Remove the work directory
-	// if err := os.RemoveAll(workingDir); err != nil {
-	// 	return fmt.Errorf("error removing work directory %s: %v", workingDir, err)
-	// }
-	os.RemoveAll(workingDir)
-
-	// if err := os.Remove(workingFile); err != nil {
-	// 	return fmt.Errorf("error removing work file %s: %v", workingFile, err)
-	// }
-	os.Remove(workingFile)
-
-	return nil
+	return backupPath, nil
 }
 
 // mergeFiles consolidates all `.tf` files in the specified directory into a single output file.
@@ -192,9 +341,11 @@ func mergeFiles(regionDir, outputFileName string) error {
 			// 	}
 			default:
 				// Add all other `.tf` files to resourceContent
-				resourceContent.WriteString(fmt.Sprintf("# Start of %s\n\n", filepath.Base(path)))
+				// Sections name their service, so a partial run can carry over the others
+				section := filepath.Base(filepath.Dir(path)) + "/" + filepath.Base(path)
+				resourceContent.WriteString(fmt.Sprintf("# Start of %s\n\n", section))
 				resourceContent.Write(content)
-				resourceContent.WriteString(fmt.Sprintf("\n# End of %s\n\n", filepath.Base(path)))
+				resourceContent.WriteString(fmt.Sprintf("\n# End of %s\n\n", section))
 			}
 		}
 		return nil
@@ -238,8 +389,13 @@ func mergeFilesOfRefion(baseDir, provider string) error {
 			return fmt.Errorf("error accessing path %s: %w", path, err)
 		}
 
-		// Process only region directories with `<region>/google/<project-id>` structure
-		if info.IsDir() && strings.Contains(path, provider) && !strings.Contains(path, ".terraform") {
+		// Process only region directories with `<region>/google/<project-id>` structure.
+		// Only the path below baseDir is checked; the directories above it may contain the provider name.
+		rel, err := filepath.Rel(baseDir, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && strings.HasPrefix(rel, provider+string(filepath.Separator)) && !strings.Contains(rel, ".terraform") {
 			projectDir := filepath.Dir(path) // Get project directory
 			regionDir := filepath.Dir(projectDir)
 			region := filepath.Base(regionDir)
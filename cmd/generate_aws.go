@@ -17,139 +17,136 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 )
 
-// runTerraformerAWS executes Terraformer for AWS to generate resources for each region
-func runTerraformerAWS(account CloudAccount) error {
+// runTerraformerAWS executes Terraformer for AWS to generate resources for each region.
+// Each region is a job of the scheduler.
+func runTerraformerAWS(ctx context.Context, cm *CredentialManager, account CloudAccount, output *accountOutput, scheduler *Scheduler) error {
 	log.Printf("Starting process for account: %s", account.ID)
 
 	// Process AWS credentials
 	log.Println("Processing AWS credentials...")
-	credMap, ok := account.Credentials.(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("❌ invalid credentials type for AWS account %s", account.ID)
+	awsCreds, err := account.awsCredentials()
+	if err != nil {
+		return fmt.Errorf("❌ invalid credentials for AWS account %s: %v", account.ID, err)
 	}
 
-	// Extract AWS credentials from the map
-	accessKeyID, ok := credMap["access_key_id"].(string)
-	if !ok {
-		return fmt.Errorf("❌ invalid or missing access_key_id for AWS account %s", account.ID)
+	// Role accounts obtain temporary credentials from STS, refreshed as they expire
+	cfg, err := cm.awsConfig(ctx, awsCreds)
+	if err != nil {
+		return fmt.Errorf("❌ failed to resolve credentials for AWS account %s: %v", account.ID, err)
 	}
-
-	secretAccessKey, ok := credMap["secret_access_key"].(string)
-	if !ok {
-		return fmt.Errorf("❌ invalid or missing secret_access_key for AWS account %s", account.ID)
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		return fmt.Errorf("❌ failed to obtain credentials for AWS account %s: %v", account.ID, err)
 	}
 
 	log.Println("✅ AWS credentials processed successfully")
 
-	// Create base output directory
-	baseOutputDir := fmt.Sprintf("generated/aws-%s", account.ID)
-	RenameDirWithBackup(baseOutputDir)
-	if err := os.MkdirAll(baseOutputDir, 0755); err != nil {
-		return fmt.Errorf("error creating base output directory: %v", err)
-	}
-
 	// Get AWS regions
-	regions := getAWSRegions()
+	regions := generateFilters.filterRegions(getAWSRegions(ctx))
+	if len(regions) == 0 {
+		return fmt.Errorf("no AWS regions match --region and --exclude-region")
+	}
 	// regions := []string{"ap-northeast-1"} // for debug
 	// log.Printf("Processing %d AWS regions: %v\n", len(regions), regions)
 
-	// Define maximum number of concurrent workers
-	maxConcurrency := 7 // Max Threads
-	sem := make(chan struct{}, maxConcurrency)
-	var wg sync.WaitGroup
-	var mu sync.Mutex // To protect shared resources like log output
-	errors := []error{}
-
+	var mu sync.Mutex // To protect the count of completed regions
 	outputCompletedServiceCount := 0
 
-	for i, region := range regions {
-		wg.Add(1)
-		go func(region string, index int) {
-			defer wg.Done()
-
-			// Acquire a slot in the semaphore
-			sem <- struct{}{}
-			defer func() { <-sem }() // Release the slot when done
-
+	jobs := []GenerateJob{}
+	for _, region := range regions {
+		region := region
+		jobs = append(jobs, GenerateJob{Region: region, Run: func() error {
 			log.Printf("Processing %v region...\n", region)
 
-			regionDir := filepath.Join(baseOutputDir, region)
-			if err := os.MkdirAll(regionDir, 0755); err != nil {
-				mu.Lock()
-				errors = append(errors, fmt.Errorf("error creating directory for region %s: %v", region, err))
-				mu.Unlock()
-				return
+			workDir, cleanup, err := newWorkDir(region)
+			if err != nil {
+				return fmt.Errorf("error creating directory for region %s: %v", region, err)
 			}
+			defer cleanup()
 
-			if err := createMainTF("aws", regionDir, []string{region}); err != nil {
-				fmt.Printf("error writing global main.tf: %v", err)
-				return
+			if err := createMainTF("aws", workDir, []string{region}); err != nil {
+				return fmt.Errorf("error writing main.tf for region %s: %v", region, err)
 			}
 
-			// log.Printf("Running terraform init in %s", regionDir)
-			terraformInitCmd := exec.Command("terraform", "init", "--upgrade")
-			terraformInitCmd.Dir = regionDir
-			initOutput, err := terraformInitCmd.CombinedOutput()
+			debugf("Running terraform init in %s", workDir)
+			initOutput, err := runStep(ctx, initPolicy, "terraform init for "+account.ID+"/"+region, func(ctx context.Context) (*exec.Cmd, error) {
+				terraformInitCmd := commandContext(ctx, "terraform", "init", "--upgrade")
+				terraformInitCmd.Dir = workDir
+				return terraformInitCmd, nil
+			})
 			if err != nil {
 				log.Printf("Terraform init output:\n%s", string(initOutput))
-				fmt.Printf("error running terraform init: %v", err)
-				return
+				return fmt.Errorf("error running terraform init for region %s: %v", region, err)
 			}
-			// If there seems to be a problem with Terraform itself, enable it.
-			// log.Printf("Terraform init output:\n%s", string(initOutput))
-			// log.Printf("✅ Terraform initialization successful")
-
-			terraformerImportCmd := exec.Command("terraformer", "import", "aws",
-				"--resources=*",
-				"--regions="+region,
-				"--path-output=./",
-				"--compact")
-			terraformerImportCmd.Dir = regionDir
-			terraformerImportCmd.Env = append(os.Environ(),
-				"AWS_ACCESS_KEY_ID="+accessKeyID,
-				"AWS_SECRET_ACCESS_KEY="+secretAccessKey)
-
-			importOutput, err := terraformerImportCmd.CombinedOutput()
+			debugf("Terraform init output for region %s:\n%s", region, string(initOutput))
+
+			importArgs := append([]string{"import", "aws"}, generateFilters.terraformerResourceArgs()...)
+			importOutput, err := runStep(ctx, importPolicy, "terraformer import for "+account.ID+"/"+region, func(ctx context.Context) (*exec.Cmd, error) {
+				// Every attempt starts without the code of the previous one
+				if err := os.RemoveAll(filepath.Join(workDir, "aws")); err != nil {
+					return nil, err
+				}
+
+				terraformerImportCmd := commandContext(ctx, "terraformer", append(importArgs,
+					"--regions="+region,
+					"--path-output=./",
+					"--compact")...)
+				terraformerImportCmd.Dir = workDir
+				// Role credentials are refreshed for retries that start after they expired
+				awsCredentials, err := cfg.Credentials.Retrieve(ctx)
+				if err != nil {
+					return nil, fmt.Errorf("error obtaining credentials for region %s: %v", region, err)
+				}
+				terraformerImportCmd.Env = append(os.Environ(),
+					"AWS_ACCESS_KEY_ID="+awsCredentials.AccessKeyID,
+					"AWS_SECRET_ACCESS_KEY="+awsCredentials.SecretAccessKey)
+				if awsCredentials.SessionToken != "" {
+					terraformerImportCmd.Env = append(terraformerImportCmd.Env, "AWS_SESSION_TOKEN="+awsCredentials.SessionToken)
+				}
+				return terraformerImportCmd, nil
+			})
 			if err != nil {
-				mu.Lock()
-				errors = append(errors, fmt.Errorf("error running Terraformer for region %s: %v\nOutput: %s", region, err, string(importOutput)))
-				mu.Unlock()
-				return
+				return fmt.Errorf("error running Terraformer for region %s: %v\nOutput: %s", region, err, string(importOutput))
 			}
 			// fmt.Printf("importOutput\n%v", string(importOutput))
 
-			mergeFilesOfRefion(regionDir, "aws")
-
-			removedWorkDir(filepath.Join(baseOutputDir, "all_resources_in_aws-"+account.ID+".tf"), regionDir, "aws")
-
-			os.RemoveAll(filepath.Join(regionDir, ".terraform"))
-			os.Remove(filepath.Join(regionDir, "main.tf"))
-			os.Remove(filepath.Join(regionDir, ".terraform.lock.hcl"))
+			if output.perService() {
+				err = output.publishServices(filepath.Join(workDir, "aws"), region)
+				if err == nil {
+					err = output.pruneServices(region, generateFilters.Resources)
+				}
+			} else {
+				if err := mergeFilesOfRefion(workDir, "aws"); err != nil {
+					return fmt.Errorf("error merging Terraform code for region %s: %v", region, err)
+				}
+				err = output.publishFile(filepath.Join(workDir, "all_resources_in_"+region+".tf"), region)
+			}
+			if err != nil {
+				return fmt.Errorf("error writing Terraform code for region %s: %v", region, err)
+			}
 
+			mu.Lock()
 			outputCompletedServiceCount++
-			log.Printf("✅ Successfully generated Terraform code for region %s (%v/%v)", region, outputCompletedServiceCount, len(regions))
-		}(region, i)
+			log.Printf("✅ Successfully generated Terraform code for region %s of account %s (%v/%v)", region, account.ID, outputCompletedServiceCount, len(regions))
+			mu.Unlock()
+			return nil
+		}})
 	}
 
-	wg.Wait()
-
 	// Handle errors after all regions are processed
-	if len(errors) > 0 {
+	if errors := scheduler.Run(account, jobs); len(errors) > 0 {
 		return fmt.Errorf("encountered errors during AWS Terraformer process: %v", errors)
 	}
 
-	os.Remove("generated/all_resources_in_generated.tf")
-
 	log.Printf("✅ Completed AWS Terraformer process for account: %s", account.ID)
 	return nil
 }
 
-func getAWSRegions() []string {
+func getAWSRegions(ctx context.Context) []string {
 
 	regions := []string{}
 
 	// Load AWS configuration
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	cfg, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
 		return getAWSRegionsHardCoded()
 	}
@@ -158,7 +155,7 @@ func getAWSRegions() []string {
 	client := ec2.NewFromConfig(cfg)
 
 	// Describe regions
-	resp, err := client.DescribeRegions(context.TODO(), &ec2.DescribeRegionsInput{
+	resp, err := client.DescribeRegions(ctx, &ec2.DescribeRegionsInput{
 		AllRegions: aws.Bool(true),
 	})
 
@@ -4,94 +4,178 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
-// runTerraformerAzure executes Terraformer for Azure to generate resources
-func runTerraformerAzure(account CloudAccount) error {
+// runTerraformerAzure executes Terraformer for Azure to generate resources.
+// Each service is a job of the scheduler.
+func runTerraformerAzure(ctx context.Context, cm *CredentialManager, account CloudAccount, output *accountOutput, scheduler *Scheduler) error {
 	log.Printf("Starting process for account: %s", account.ID)
 
 	// Process Azure credentials
 	log.Println("Processing Azure credentials...")
-	azureCreds, ok := account.Credentials.(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("❌ invalid credentials type for Azure account %s", account.ID)
+	azureCreds, err := account.azureCredentials()
+	if err != nil {
+		return fmt.Errorf("❌ invalid credentials for Azure account %s: %v", account.ID, err)
+	}
+	// Subscriptions added by --all-subscriptions authenticate as their source account
+	azureCreds, err = cm.azureAccountCredentials(azureCreds)
+	if err != nil {
+		return fmt.Errorf("❌ invalid credentials for Azure account %s: %v", account.ID, err)
 	}
 
-	// Extract Azure credentials from the map
-	subscriptionID, ok := azureCreds["subscription_id"].(string)
-	if !ok {
+	if azureCreds.SubscriptionID == "" {
 		return fmt.Errorf("❌ invalid or missing subscription_id for Azure account %s", account.ID)
 	}
-
-	tenantID, ok := azureCreds["tenant_id"].(string)
-	if !ok {
+	if azureCreds.TenantID == "" {
 		return fmt.Errorf("❌ invalid or missing tenant_id for Azure account %s", account.ID)
 	}
 
 	log.Println("✅ Azure credentials processed successfully")
 
-	// Create base output directory
-	baseOutputDir := fmt.Sprintf("generated/azure-%s", account.ID)
-	RenameDirWithBackup(baseOutputDir)
-	if err := os.MkdirAll(baseOutputDir, 0755); err != nil {
-		return fmt.Errorf("error creating base output directory: %v", err)
+	workDir, cleanup, err := newWorkDir("")
+	if err != nil {
+		return err
 	}
+	defer cleanup()
 
-	if err := createMainTF("azure", baseOutputDir, []string{""}); err != nil {
+	if err := createMainTF("azure", workDir, []string{""}); err != nil {
 		return fmt.Errorf("error writing global main.tf: %v", err)
 	}
 
 	// Initialize Terraform
-	terraformInitCmd := exec.Command("terraform", "init", "--upgrade")
-	terraformInitCmd.Dir = baseOutputDir
-	initOutput, err := terraformInitCmd.CombinedOutput()
+	initOutput, err := runStep(ctx, initPolicy, "terraform init for "+azureCreds.SubscriptionID, func(ctx context.Context) (*exec.Cmd, error) {
+		terraformInitCmd := commandContext(ctx, "terraform", "init", "--upgrade")
+		terraformInitCmd.Dir = workDir
+		return terraformInitCmd, nil
+	})
 	if err != nil {
 		log.Printf("Terraform init output:\n%s", string(initOutput))
 		return fmt.Errorf("error running terraform init: %v", err)
 	}
 
-	// Get all available Azure services
-	resources := getAvailableAzureServices()
-	log.Printf("Starting import of all resources across subscription...")
-
-	// Run Terraformer for all resources without specifying resource group
-	terraformerImportCmd := exec.Command("terraformer", "import", "azure",
-		"--resources="+strings.Join(resources, ","),
-		// "--path-pattern={output}/{provider}",
-		"--path-output=./",
-		"--compact")
-	terraformerImportCmd.Dir = baseOutputDir
-	terraformerImportCmd.Env = append(os.Environ(),
-		"ARM_SUBSCRIPTION_ID="+subscriptionID,
-		"ARM_TENANT_ID="+tenantID)
-
-	importOutput, err := terraformerImportCmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("error running Terraformer: %v\nOutput: %s", err, string(importOutput))
+	// Get all available Azure services. Azure is imported for the whole subscription,
+	// so region filters do not apply.
+	resources := generateFilters.filterServices(getAvailableAzureServices())
+	if len(resources) == 0 {
+		return fmt.Errorf("no Azure services match --resources and --exclude-resources")
+	}
+	if generateFilters.selectsRegions() {
+		debugf("Region filters do not apply to Azure account %s", account.ID)
 	}
+	log.Printf("Starting import of %d resource types across subscription...", len(resources))
+
+	// Each service is a job of the scheduler. The jobs share the providers of the init above
+	// and collect their code in <workDir>/azurerm.
+	var mu sync.Mutex // To protect the count of completed services
+	completedServiceCount := 0
+
+	jobs := []GenerateJob{}
+	for _, service := range resources {
+		service := service
+		jobs = append(jobs, GenerateJob{Service: service, Run: func() error {
+			serviceDir := filepath.Join(workDir, "services", service)
+			if err := linkTerraformInit(workDir, serviceDir); err != nil {
+				return fmt.Errorf("error preparing directory for Azure service %s: %v", service, err)
+			}
 
-	// Merge all resource files into a single file
-	mergedFilePath := filepath.Join(baseOutputDir, fmt.Sprintf("all_resources_in_azure-%s.tf", azureCreds["name"].(string)))
-	if err := mergeAzureFiles(filepath.Join(baseOutputDir, "azurerm"), mergedFilePath); err != nil {
-		return fmt.Errorf("error merging files: %v", err)
+			importOutput, err := runStep(ctx, importPolicy, "terraformer import for "+azureCreds.SubscriptionID+"/"+service, func(ctx context.Context) (*exec.Cmd, error) {
+				// Every attempt starts without the code of the previous one
+				if err := os.RemoveAll(filepath.Join(serviceDir, "azurerm")); err != nil {
+					return nil, err
+				}
+
+				// Run Terraformer without specifying resource group
+				terraformerImportCmd := commandContext(ctx, "terraformer", "import", "azure",
+					"--resources="+service,
+					// "--path-pattern={output}/{provider}",
+					"--path-output=./",
+					"--compact")
+				terraformerImportCmd.Dir = serviceDir
+				terraformerImportCmd.Env = append(os.Environ(), azureCreds.terraformerEnv()...)
+				return terraformerImportCmd, nil
+			})
+			if err != nil {
+				return fmt.Errorf("error running Terraformer for Azure service %s: %v\nOutput: %s", service, err, string(importOutput))
+			}
+			if err := moveDirEntries(filepath.Join(serviceDir, "azurerm"), filepath.Join(workDir, "azurerm")); err != nil {
+				return fmt.Errorf("error collecting Terraform code of Azure service %s: %v", service, err)
+			}
+
+			mu.Lock()
+			completedServiceCount++
+			debugf("Imported Azure service %s of subscription %s (%v/%v)", service, azureCreds.SubscriptionID, completedServiceCount, len(resources))
+			mu.Unlock()
+			return nil
+		}})
 	}
 
-	// Cleanup
-	os.RemoveAll(filepath.Join(baseOutputDir, "azurerm"))
-	os.RemoveAll(filepath.Join(baseOutputDir, ".terraform"))
-	os.Remove(filepath.Join(baseOutputDir, ".terraform.lock.hcl"))
-	os.Remove(filepath.Join(baseOutputDir, "main.tf"))
+	if errors := scheduler.Run(account, jobs); len(errors) > 0 {
+		return fmt.Errorf("error running Terraformer: %v", errors)
+	}
+
+	if output.perService() {
+		err = output.publishServices(filepath.Join(workDir, "azurerm"), "")
+		if err == nil {
+			err = output.pruneServices("", resources)
+		}
+	} else {
+		// Merge all resource files into a single file
+		mergedFilePath := filepath.Join(workDir, fmt.Sprintf("all_resources_in_azure-%s.tf", azureCreds.Name))
+		if err := mergeAzureFiles(filepath.Join(workDir, "azurerm"), mergedFilePath); err != nil {
+			return fmt.Errorf("error merging files: %v", err)
+		}
+		err = output.publishFile(mergedFilePath, "")
+	}
+	if err != nil {
+		return fmt.Errorf("error writing Terraform code: %v", err)
+	}
 
 	log.Printf("✅ Completed Azure Terraformer process for account: %s", account.ID)
 	return nil
 }
 
+// linkTerraformInit prepares dir to run Terraformer with the providers that terraform init
+// installed in initDir, so the provider is downloaded once per account instead of once per service
+func linkTerraformInit(initDir, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, name := range []string{"main.tf", ".terraform.lock.hcl"} {
+		if err := copyFile(filepath.Join(initDir, name), filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Symlink(filepath.Join(initDir, ".terraform"), filepath.Join(dir, ".terraform"))
+}
+
+// moveDirEntries moves the entries of src into dst. A missing src has nothing to move.
+func moveDirEntries(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // mergeAzureFiles consolidates all Azure resource files into a single file
 func mergeAzureFiles(azureDir, outputFile string) error {
 	var providerContent strings.Builder
@@ -122,6 +206,14 @@ func mergeAzureFiles(azureDir, outputFile string) error {
 		}
 
 		fileName := filepath.Base(path)
+		// Sections name their service, so a partial run can carry over the others
+		section := filepath.Base(filepath.Dir(path)) + "/" + fileName
+		writeSection := func(builder *strings.Builder, header string) {
+			builder.WriteString(header)
+			builder.WriteString(fmt.Sprintf("# Start of %s\n\n", section))
+			builder.Write(content)
+			builder.WriteString(fmt.Sprintf("\n# End of %s\n\n", section))
+		}
 		switch fileName {
 		case "provider.tf":
 			if !providerWritten {
@@ -131,22 +223,14 @@ func mergeAzureFiles(azureDir, outputFile string) error {
 				providerWritten = true
 			}
 		case "variables.tf":
-			variableContent.WriteString("# Variable Definitions\n\n")
-			variableContent.Write(content)
-			variableContent.WriteString("\n")
+			writeSection(&variableContent, "# Variable Definitions\n\n")
 		case "outputs.tf":
-			outputContent.WriteString("# Output Definitions\n\n")
-			outputContent.Write(content)
-			outputContent.WriteString("\n")
+			writeSection(&outputContent, "# Output Definitions\n\n")
 		case "resources.tf":
-			resourceContent.WriteString("# Resource Definitions\n\n")
-			resourceContent.Write(content)
-			resourceContent.WriteString("\n")
+			writeSection(&resourceContent, "# Resource Definitions\n\n")
 		default:
 			if fileName != "terraform.tfstate" {
-				resourceContent.WriteString(fmt.Sprintf("# Additional Resources from %s\n\n", path))
-				resourceContent.Write(content)
-				resourceContent.WriteString("\n")
+				writeSection(&resourceContent, "# Additional Resources\n\n")
 			}
 		}
 
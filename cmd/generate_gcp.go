@@ -2,39 +2,35 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"sync"
 
 	compute "cloud.google.com/go/compute/apiv1"
 	"cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/api/option"
 )
 
-// runTerraformerGCP executes Terraformer for GCP to generate resources for each region
-func runTerraformerGCP(account CloudAccount) error {
+// runTerraformerGCP executes Terraformer for GCP to generate resources for each region.
+// Each region is a job of the scheduler.
+func runTerraformerGCP(ctx context.Context, cm *CredentialManager, account CloudAccount, output *accountOutput, scheduler *Scheduler) error {
 	log.Printf("Starting process for account: %s", account.ID)
 
 	// Process GCP credentials
 	log.Println("Processing GCP credentials...")
-	gcpCreds, ok := account.Credentials.(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("❌ invalid credentials type for GCP account %s", account.ID)
-	}
-
-	var gcpCloudCreds GCPCloudCredentials
-	credBytes, err := json.Marshal(gcpCreds)
+	gcpCloudCreds, err := account.gcpCredentials()
 	if err != nil {
-		return fmt.Errorf("❌ failed to marshal GCP credentials: %v", err)
+		return fmt.Errorf("❌ invalid credentials for GCP account %s: %v", account.ID, err)
 	}
 
-	err = json.Unmarshal(credBytes, &gcpCloudCreds)
+	// The original credential document is passed to Terraformer unchanged.
+	// Projects added by gcp-org use the document of their source account.
+	gcpCredsJSON, err := cm.gcpCredentialsJSON(gcpCloudCreds)
 	if err != nil {
-		return fmt.Errorf("❌ failed to unmarshal GCP credentials: %v", err)
+		return fmt.Errorf("❌ invalid credentials for GCP account %s: %v", account.ID, err)
 	}
 	log.Println("✅ GCP credentials processed successfully")
 
@@ -52,124 +48,98 @@ func runTerraformerGCP(account CloudAccount) error {
 		}
 	}()
 
-	baseOutputDir := fmt.Sprintf("generated/gcp-%s", account.ID)
-	RenameDirWithBackup(baseOutputDir)
-	if err := os.MkdirAll(baseOutputDir, 0755); err != nil {
-		return fmt.Errorf("error creating base output directory: %v", err)
-	}
-
 	// Write credentials to temporary file
-	gcpCredsJSON := fmt.Sprintf(`{
-        "type": "service_account",
-        "project_id": "%s",
-        "private_key_id": "%s",
-        "private_key": "%s",
-        "client_email": "%s",
-        "client_id": "%s",
-        "auth_uri": "https://accounts.google.com/o/oauth2/auth",
-        "token_uri": "https://oauth2.googleapis.com/token",
-        "auth_provider_x509_cert_url": "https://www.googleapis.com/oauth2/v1/certs",
-        "client_x509_cert_url": "https://www.googleapis.com/robot/v1/metadata/x509/%s"
-    }`, gcpCloudCreds.ProjectID, gcpCloudCreds.PrivateKeyID, escapeNewlines(gcpCloudCreds.PrivateKey),
-		gcpCloudCreds.ClientEmail, gcpCloudCreds.ClientID, gcpCloudCreds.ClientEmail)
-
-	if err := os.WriteFile(tempFile.Name(), []byte(gcpCredsJSON), 0600); err != nil {
+	if err := os.WriteFile(tempFile.Name(), gcpCredsJSON, 0600); err != nil {
 		return fmt.Errorf("❌ error writing GCP credentials to temporary file: %v", err)
 	}
 	// log.Printf("✅ Created temporary credentials file at: %s", tempFile.Name())
 
-	regions := getGCPRegions(gcpCloudCreds.ProjectID)
+	regions := generateFilters.filterRegions(getGCPRegions(ctx, gcpCloudCreds.ProjectID, gcpCredsJSON))
+	if len(regions) == 0 {
+		return fmt.Errorf("no GCP regions match --region and --exclude-region")
+	}
 	// regions := []string{"asia-southeast2", "africa-south1"} // for debug
 	// log.Printf("Processing %d GCP regions: %v", len(regions), regions)
 
-	// Define maximum number of concurrent workers
-	maxConcurrency := 7 // Max Threads
-	sem := make(chan struct{}, maxConcurrency)
-	var wg sync.WaitGroup
-	var mu sync.Mutex // To protect shared resources like log output
-	errors := []error{}
-
+	var mu sync.Mutex // To protect the count of completed regions
 	outputCompletedServiceCount := 0
 
-	for i, region := range regions {
-		wg.Add(1)
-		go func(region string, index int) {
-			defer wg.Done()
-
-			// Acquire a slot in the semaphore
-			sem <- struct{}{}
-			defer func() { <-sem }() // Release the slot when done
-
+	jobs := []GenerateJob{}
+	for _, region := range regions {
+		region := region
+		jobs = append(jobs, GenerateJob{Region: region, Run: func() error {
 			log.Printf("Processing %v region...\n", region)
 
-			regionDir := filepath.Join(baseOutputDir, region)
-			if err := os.MkdirAll(regionDir, 0755); err != nil {
-				mu.Lock()
-				errors = append(errors, fmt.Errorf("error creating directory for region %s: %v", region, err))
-				mu.Unlock()
-				return
+			workDir, cleanup, err := newWorkDir(region)
+			if err != nil {
+				return fmt.Errorf("error creating directory for region %s: %v", region, err)
 			}
+			defer cleanup()
 
-			if err := createMainTF("gcp", regionDir, []string{gcpCloudCreds.ProjectID, region}); err != nil {
-				fmt.Printf("error writing global main.tf: %v", err)
-				return
+			if err := createMainTF("gcp", workDir, []string{gcpCloudCreds.ProjectID, region}); err != nil {
+				return fmt.Errorf("error writing main.tf for GCP region %s: %v", region, err)
 			}
 
-			// Initialize Terraform in base directory
-			terraformInitCmd := exec.Command("terraform", "init", "-upgrade")
-			terraformInitCmd.Dir = regionDir
-			initOutput, err := terraformInitCmd.CombinedOutput()
+			// Initialize Terraform in the work directory
+			initOutput, err := runStep(ctx, initPolicy, "terraform init for "+gcpCloudCreds.ProjectID+"/"+region, func(ctx context.Context) (*exec.Cmd, error) {
+				terraformInitCmd := commandContext(ctx, "terraform", "init", "-upgrade")
+				terraformInitCmd.Dir = workDir
+				return terraformInitCmd, nil
+			})
 			if err != nil {
 				log.Printf("Terraform init output:\n%s", string(initOutput))
-				fmt.Printf("error running terraform init: %v", err)
-				return
-
+				return fmt.Errorf("error running terraform init for GCP region %s: %v", region, err)
 			}
-			// If there seems to be a problem with Terraform itself, enable it.
-			// log.Printf("Terraform init output:\n%s", string(initOutput))
-			// log.Printf("✅ Terraform initialization successful")
-
-			terraformerImportCmd := exec.Command("terraformer", "import", "google",
-				"--resources=*",
-				"--regions="+region,
-				"--projects="+gcpCloudCreds.ProjectID,
-				"--path-output=./",
-				"--compact")
-			terraformerImportCmd.Dir = regionDir
-			terraformerImportCmd.Env = append(os.Environ(),
-				"GOOGLE_APPLICATION_CREDENTIALS="+tempFile.Name(),
-				"GOOGLE_CLOUD_PROJECT="+gcpCloudCreds.ProjectID)
-
-			importOutput, err := terraformerImportCmd.CombinedOutput()
+			debugf("Terraform init output for region %s:\n%s", region, string(initOutput))
+
+			importArgs := append([]string{"import", "google"}, generateFilters.terraformerResourceArgs()...)
+			importOutput, err := runStep(ctx, importPolicy, "terraformer import for "+gcpCloudCreds.ProjectID+"/"+region, func(ctx context.Context) (*exec.Cmd, error) {
+				// Every attempt starts without the code of the previous one
+				if err := os.RemoveAll(filepath.Join(workDir, "google")); err != nil {
+					return nil, err
+				}
+
+				terraformerImportCmd := commandContext(ctx, "terraformer", append(importArgs,
+					"--regions="+region,
+					"--projects="+gcpCloudCreds.ProjectID,
+					"--path-output=./",
+					"--compact")...)
+				terraformerImportCmd.Dir = workDir
+				terraformerImportCmd.Env = append(os.Environ(),
+					"GOOGLE_APPLICATION_CREDENTIALS="+tempFile.Name(),
+					"GOOGLE_CLOUD_PROJECT="+gcpCloudCreds.ProjectID)
+				return terraformerImportCmd, nil
+			})
 			if err != nil {
-				mu.Lock()
-				errors = append(errors, fmt.Errorf("error running Terraformer for GCP region %s: %v\nOutput: %s", region, err, string(importOutput)))
-				mu.Unlock()
-				return
+				return fmt.Errorf("error running Terraformer for GCP region %s: %v\nOutput: %s", region, err, string(importOutput))
 			}
 			// fmt.Printf("importOutput\n%v", string(importOutput))
 
-			if err := mergeFilesOfRefion(regionDir, "google"); err != nil {
-				fmt.Printf("Internal error: %v\n", err)
+			if output.perService() {
+				err = output.publishServices(filepath.Join(workDir, "google"), region)
+				if err == nil {
+					err = output.pruneServices(region, generateFilters.Resources)
+				}
+			} else {
+				if err := mergeFilesOfRefion(workDir, "google"); err != nil {
+					return fmt.Errorf("error merging Terraform code for GCP region %s: %v", region, err)
+				}
+				err = output.publishFile(filepath.Join(workDir, "all_resources_in_"+region+".tf"), region)
+			}
+			if err != nil {
+				return fmt.Errorf("error writing Terraform code for GCP region %s: %v", region, err)
 			}
 
-			removedWorkDir(filepath.Join(baseOutputDir, "all_resources_in_gcp-"+account.ID+".tf"), regionDir, "google")
-
-			os.RemoveAll(filepath.Join(regionDir, ".terraform"))
-
-			os.Remove(filepath.Join(regionDir, ".terraform.lock.hcl"))
-
-			os.Remove(filepath.Join(regionDir, "main.tf"))
-
+			mu.Lock()
 			outputCompletedServiceCount++
-			log.Printf("✅ Successfully generated Terraform code for region %s (%v/%v)", region, outputCompletedServiceCount, len(regions))
-		}(region, i)
+			log.Printf("✅ Successfully generated Terraform code for region %s of project %s (%v/%v)", region, gcpCloudCreds.ProjectID, outputCompletedServiceCount, len(regions))
+			mu.Unlock()
+			return nil
+		}})
 	}
 
-	wg.Wait()
-
 	// Handle errors after all regions are processed
-	if len(errors) > 0 {
+	if errors := scheduler.Run(account, jobs); len(errors) > 0 {
 		return fmt.Errorf("encountered errors during GCP Terraformer process: %v", errors)
 	}
 
@@ -177,14 +147,11 @@ func runTerraformerGCP(account CloudAccount) error {
 	return nil
 }
 
-func getGCPRegions(projectID string) []string {
+func getGCPRegions(ctx context.Context, projectID string, credentialsJSON []byte) []string {
 	regions := []string{}
 
-	// Create a context
-	ctx := context.Background()
-
 	// Create a client for the Compute Engine API
-	client, err := compute.NewRegionsRESTClient(ctx)
+	client, err := compute.NewRegionsRESTClient(ctx, option.WithCredentialsJSON(credentialsJSON))
 	if err != nil {
 		return getGCPRegionsHardCoded()
 	}
@@ -255,8 +222,3 @@ func getGCPRegionsHardCoded() []string {
 		"us-west4",
 	}
 }
-
-// escapeNewlines escapes newlines in the private key so that it can be inserted into a JSON string
-func escapeNewlines(input string) string {
-	return strings.ReplaceAll(input, "\n", "\\n")
-}
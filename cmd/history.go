@@ -0,0 +1,245 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// historyIgnores are never committed: config backups made before migrations may hold
+// plaintext credentials, Terraform state may hold secrets of the imported resources and
+// _bk directories duplicate what the history already records
+var historyIgnores = []string{
+	"*.bak",
+	"*.tfstate",
+	"*.tfstate.backup",
+	".terraform/",
+	".terraform.lock.hcl",
+	"*_bk/",
+	"*_bk[0-9]*/",
+}
+
+// GenerateRecord is the outcome of generating one account
+type GenerateRecord struct {
+	Account   CloudAccount
+	Regions   []string
+	Resources int
+	Duration  time.Duration
+	Err       error
+	// Retries are the attempts of terraform init and terraformer import that were retried
+	Retries []StepAttempt
+}
+
+// commitHistory records the workspace of the config in its Git repository.
+// Credentials are only committed when they are encrypted or kept in a credential store;
+// otherwise cloud_accounts.conf is left out. Failures are logged, never returned, so a
+// missing or broken repository does not fail the command that changed the workspace.
+func (cm *CredentialManager) commitHistory(message string) {
+	if globalOptions.NoCommit {
+		return
+	}
+
+	workspace := workspaceOfConfig(cm.configPath)
+	if !isDir(filepath.Join(workspace.Dir, ".git")) {
+		debugf("%s is not a Git repository; not recording history", workspace.Dir)
+		return
+	}
+
+	if err := cm.prepareHistory(workspace.Dir); err != nil {
+		log.Printf("⚠️ Failed to record history: %v", err)
+		return
+	}
+
+	if _, err := runGit(workspace.Dir, "add", "-A"); err != nil {
+		log.Printf("⚠️ Failed to record history: %v", err)
+		return
+	}
+	// Nothing staged means nothing changed
+	if _, err := runGit(workspace.Dir, "diff", "--cached", "--quiet"); err == nil {
+		return
+	}
+	if _, err := runGit(workspace.Dir, append(gitIdentityArgs(workspace.Dir), "commit", "--quiet", "-m", message)...); err != nil {
+		log.Printf("⚠️ Failed to record history: %v", err)
+		return
+	}
+	debugf("Recorded history in %s: %s", workspace.Dir, strings.SplitN(message, "\n", 2)[0])
+}
+
+// prepareHistory writes the ignore rules of the repository and keeps cloud_accounts.conf
+// out of it while it holds plaintext credentials
+func (cm *CredentialManager) prepareHistory(repoDir string) error {
+	for _, pattern := range historyIgnores {
+		if err := ignoreInGit(repoDir, pattern); err != nil {
+			return err
+		}
+	}
+
+	configName := filepath.Base(cm.configPath)
+	if !cm.hasPlaintextCredentials() {
+		return unignoreInGit(repoDir, configName)
+	}
+
+	if err := ignoreInGit(repoDir, configName); err != nil {
+		return err
+	}
+	if _, err := runGit(repoDir, "rm", "--cached", "--quiet", "--ignore-unmatch", configName); err != nil {
+		return err
+	}
+	debugf("%s holds plaintext credentials; it is not committed. Run `yogaya config encrypt` or `yogaya config store` to record it", configName)
+	return nil
+}
+
+// hasPlaintextCredentials reports whether cloud_accounts.conf stores any credentials unencrypted
+func (cm *CredentialManager) hasPlaintextCredentials() bool {
+	if cm.config.Encryption != nil {
+		return false
+	}
+	for _, account := range cm.config.Accounts {
+		inline := account.CredentialStore == "" || account.CredentialStore == fileStoreBackend
+		if inline && account.Credentials != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// generateCommitMessage describes a generate run: the accounts, their regions,
+// resource counts and how long it took. A cancelled run lists its completed accounts.
+func generateCommitMessage(records []GenerateRecord, cancelled bool, duration time.Duration) string {
+	regions := map[string]bool{}
+	resources, failed := 0, 0
+	for _, record := range records {
+		for _, region := range record.Regions {
+			regions[record.Account.Provider+"/"+region] = true
+		}
+		resources += record.Resources
+		if record.Err != nil {
+			failed++
+		}
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "generate: %d accounts, %d regions, %d resources in %s", len(records), len(regions), resources, duration.Round(time.Second))
+	if failed > 0 {
+		fmt.Fprintf(&message, " (%d failed)", failed)
+	}
+	if cancelled {
+		message.WriteString(" (cancelled, completed accounts only)")
+	}
+	message.WriteString("\n\n")
+
+	for _, record := range records {
+		status := "ok"
+		if record.Err != nil {
+			status = "failed: " + strings.SplitN(record.Err.Error(), "\n", 2)[0]
+		}
+		fmt.Fprintf(&message, "- %s %s: %s\n", record.Account.Provider, record.Account.displayName(), status)
+		if len(record.Regions) > 0 {
+			fmt.Fprintf(&message, "  regions: %s\n", strings.Join(record.Regions, ", "))
+		}
+		fmt.Fprintf(&message, "  resources: %d\n", record.Resources)
+		fmt.Fprintf(&message, "  duration: %s\n", record.Duration.Round(time.Second))
+		if len(record.Retries) > 0 {
+			fmt.Fprintf(&message, "  retries: %d\n", len(record.Retries))
+			for _, attempt := range record.Retries {
+				fmt.Fprintf(&message, "    %s, attempt %d: %s after %s\n", attempt.Step, attempt.Attempt, attempt.Reason, attempt.Duration.Round(time.Second))
+			}
+		}
+	}
+
+	fmt.Fprintf(&message, "\nDuration: %s\n", duration.Round(time.Second))
+	return message.String()
+}
+
+// countTerraformResources counts the resource blocks of a .tf file
+func countTerraformResources(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), `resource "`) {
+			count++
+		}
+	}
+	return count
+}
+
+// unignoreInGit removes a pattern from the .gitignore of dir
+func unignoreInGit(dir, pattern string) error {
+	gitignore := filepath.Join(dir, ".gitignore")
+	data, err := os.ReadFile(gitignore)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if strings.TrimSpace(line) != pattern {
+			lines = append(lines, line)
+		}
+	}
+	updated := strings.Join(lines, "\n") + "\n"
+	if updated == string(data) {
+		return nil
+	}
+	return os.WriteFile(gitignore, []byte(updated), 0644)
+}
+
+// gitIdentityArgs supplies a committer identity when the user has not configured one
+func gitIdentityArgs(repoDir string) []string {
+	if output, err := runGit(repoDir, "config", "user.email"); err == nil && len(bytes.TrimSpace(output)) > 0 {
+		return nil
+	}
+	return []string{"-c", "user.name=Yogaya CLI", "-c", "user.email=yogaya@localhost"}
+}
+
+// runGit runs a git command in dir
+func runGit(dir string, args ...string) ([]byte, error) {
+	gitCmd := exec.Command("git", args...)
+	gitCmd.Dir = dir
+	var stderr bytes.Buffer
+	gitCmd.Stderr = &stderr
+
+	output, err := gitCmd.Output()
+	if err != nil {
+		return output, fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// recordsHistory reports whether commands commit their changes to the workspace's Git repository
+func (w *Workspace) recordsHistory() bool {
+	return !globalOptions.NoCommit && isDir(filepath.Join(w.Dir, ".git"))
+}
+
+// isWithinDir reports whether path is dir or lies below it
+func isWithinDir(path, dir string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
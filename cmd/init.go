@@ -4,13 +4,12 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -24,7 +23,7 @@ var initCmd = &cobra.Command{
 }
 
 func init() {
-	rootCmd.DisableFlagParsing = true
+	initCmd.Flags().String("path", "", "directory to create the .yogaya/ directory in (default: home directory)")
 	rootCmd.AddCommand(initCmd)
 }
 
@@ -32,30 +31,22 @@ func init() {
 func initCommand(cmd *cobra.Command, args []string) {
 	fmt.Println("Start of initialization process")
 
-	yogayaDir := ""
+	args = commandArgs(cmd, args, "path")
+
+	parentDir := ""
 	if len(args) < 1 {
-		homeDir, _ := os.UserHomeDir()
-		yogayaDir = fmt.Sprintf("%s/.yogaya", homeDir)
+		parentDir, _ = os.UserHomeDir()
 	} else {
-		yogayaDir = fmt.Sprintf("%s/.yogaya", args[0])
+		parentDir = args[0]
 	}
+	yogayaDir := filepath.Join(parentDir, workspaceDirName)
+	workspace := &Workspace{Root: yogayaDir, Name: defaultWorkspaceName, Dir: yogayaDir}
 
-	// Create .yogaya directory
-	os.MkdirAll(yogayaDir, os.ModePerm)
-
-	// Create tenant.conf
-	tenantConf := fmt.Sprintf("%s/tenant.conf", yogayaDir)
-	time := time.Now()
-	// TBD:Details of tenant key will be decided later.
-	tenantKey := hashingTime(time)
-	_ = os.WriteFile(tenantConf, []byte(fmt.Sprintf("tenant_key=%s", tenantKey)), 0644)
-
-	// Create cloud_accounts.conf
-	cloudConf := fmt.Sprintf("%s/cloud_accounts.conf", yogayaDir)
-	_ = os.WriteFile(cloudConf, []byte("{}"), 0644)
-
-	// Initialize Git repository
-	exec.Command("git", "init", yogayaDir).Run()
+	// Create .yogaya directory with tenant.conf, cloud_accounts.conf and a Git repository
+	if err := createWorkspaceFiles(workspace, defaultWorkspaceName); err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
 
 	readlinkCmd := exec.Command("readlink", "-f", yogayaDir)
 	output, err := readlinkCmd.Output()
@@ -72,18 +63,28 @@ func initCommand(cmd *cobra.Command, args []string) {
 	fmt.Println("Initialized configuration in", absolutePath)
 }
 
-// HashingTime takes a time.Time value and returns its SHA-256 hash as a hexadecimal string.
-func hashingTime(t time.Time) string {
-	// Convert time.Time to string in RFC 3339 format
-	timeString := t.Format(time.RFC3339)
+// createWorkspaceFiles creates the directory of a workspace with a new tenant key, an empty
+// cloud_accounts.conf and a Git repository for its history. activeWorkspace is only
+// recorded for the .yogaya directory itself.
+func createWorkspaceFiles(workspace *Workspace, activeWorkspace string) error {
+	if err := os.MkdirAll(workspace.Dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create %s: %v", workspace.Dir, err)
+	}
 
-	// Create a new SHA-256 hash
-	hash := sha256.New()
-	// Write the byte representation of the string to the hash
-	hash.Write([]byte(timeString))
-	// Get the final hash value
-	hashBytes := hash.Sum(nil)
+	tenantKey, err := newTenantKey()
+	if err != nil {
+		return err
+	}
+	if err := writeTenantConf(workspace.TenantConfPath(), &TenantConfig{TenantKey: tenantKey, ActiveWorkspace: activeWorkspace}); err != nil {
+		return fmt.Errorf("failed to write %s: %v", tenantConfFile, err)
+	}
 
-	// Return the hash value as a hexadecimal string
-	return hex.EncodeToString(hashBytes)
+	emptyConfig, _ := json.MarshalIndent(CloudAccountsConfig{SchemaVersion: currentSchemaVersion, Accounts: []CloudAccount{}}, "", "  ")
+	if err := os.WriteFile(workspace.CloudAccountsPath(), emptyConfig, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", cloudAccountsFile, err)
+	}
+
+	// Initialize Git repository
+	exec.Command("git", "init", workspace.Dir).Run()
+	return nil
 }
@@ -0,0 +1,163 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// labelKeyPattern restricts label keys to characters that are safe in selectors and paths
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+
+// Built-in selector keys, matched against the account itself rather than its labels
+var builtinLabelKeys = []string{"provider", "id", "alias"}
+
+// selectorRequirement is a single `key=value` or `key!=value` term of a selector
+type selectorRequirement struct {
+	Key    string
+	Value  string
+	Negate bool
+}
+
+// Selector chooses accounts by their labels, e.g. `env=prod,provider=aws`.
+// All requirements must match; an empty selector matches every account.
+type Selector []selectorRequirement
+
+// parseLabels parses `key=value` pairs into a label map
+func parseLabels(values []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, value := range values {
+		key, val, found := strings.Cut(value, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid label %q: expected key=value", value)
+		}
+		if err := validateLabelKey(key); err != nil {
+			return nil, err
+		}
+		labels[key] = val
+	}
+	return labels, nil
+}
+
+// validateLabelKey rejects keys that cannot be used in a selector or that shadow a built-in key
+func validateLabelKey(key string) error {
+	if !labelKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid label key %q", key)
+	}
+	for _, builtin := range builtinLabelKeys {
+		if key == builtin {
+			return fmt.Errorf("label key %q is reserved", key)
+		}
+	}
+	return nil
+}
+
+// parseSelector parses a comma separated list of `key=value`, `key==value` and `key!=value` terms
+func parseSelector(selector string) (Selector, error) {
+	parsed := Selector{}
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		requirement := selectorRequirement{}
+		key, value, found := strings.Cut(term, "!=")
+		if found {
+			requirement.Negate = true
+		} else if key, value, found = strings.Cut(term, "=="); !found {
+			key, value, found = strings.Cut(term, "=")
+		}
+		if !found || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid selector term %q: expected key=value or key!=value", term)
+		}
+
+		requirement.Key = strings.TrimSpace(key)
+		requirement.Value = strings.TrimSpace(value)
+		parsed = append(parsed, requirement)
+	}
+	return parsed, nil
+}
+
+// Matches reports whether the account satisfies every requirement of the selector
+func (s Selector) Matches(account *CloudAccount) bool {
+	labels := account.selectorLabels()
+	for _, requirement := range s {
+		value, ok := labels[requirement.Key]
+		matched := ok && value == requirement.Value
+		if matched == requirement.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+// selectorLabels returns the labels of the account together with the built-in keys
+func (a *CloudAccount) selectorLabels() map[string]string {
+	labels := map[string]string{}
+	for key, value := range a.Labels {
+		labels[key] = value
+	}
+	labels["provider"] = a.Provider
+	labels["id"] = a.ID
+	if a.Alias != "" {
+		labels["alias"] = a.Alias
+	}
+	return labels
+}
+
+// formatLabels renders labels as sorted `key=value` pairs
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// displayName identifies the account in logs by its alias when it has one
+func (a *CloudAccount) displayName() string {
+	if a.Alias == "" {
+		return a.ID
+	}
+	return fmt.Sprintf("%s [%s]", a.Alias, a.ID)
+}
+
+// LabelAccount sets and removes labels of an account
+func (cm *CredentialManager) LabelAccount(idOrAlias string, set map[string]string, remove []string) (*CloudAccount, error) {
+	account, err := cm.FindAccount(idOrAlias)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.Labels == nil {
+		account.Labels = map[string]string{}
+	}
+	for key, value := range set {
+		account.Labels[key] = value
+	}
+	for _, key := range remove {
+		delete(account.Labels, key)
+	}
+	if len(account.Labels) == 0 {
+		account.Labels = nil
+	}
+
+	return account, cm.saveConfig()
+}
+
+// SelectAccounts returns the accounts matching the selector
+func (cm *CredentialManager) SelectAccounts(selector Selector) []CloudAccount {
+	selected := []CloudAccount{}
+	for _, account := range cm.config.Accounts {
+		if selector.Matches(&account) {
+			selected = append(selected, account)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,131 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     Selector
+		wantErr  bool
+	}{
+		{selector: "", want: Selector{}},
+		{selector: " , ,", want: Selector{}},
+		{selector: "env=prod", want: Selector{{Key: "env", Value: "prod"}}},
+		{selector: "env==prod", want: Selector{{Key: "env", Value: "prod"}}},
+		{selector: "env!=prod", want: Selector{{Key: "env", Value: "prod", Negate: true}}},
+		{selector: "env=", want: Selector{{Key: "env", Value: ""}}},
+		{
+			selector: " env = prod , provider=aws,team!=data ",
+			want: Selector{
+				{Key: "env", Value: "prod"},
+				{Key: "provider", Value: "aws"},
+				{Key: "team", Value: "data", Negate: true},
+			},
+		},
+		{selector: "url=https://example.com/?a=b", want: Selector{{Key: "url", Value: "https://example.com/?a=b"}}},
+		{selector: "env", wantErr: true},
+		{selector: "=prod", wantErr: true},
+		{selector: " !=prod", wantErr: true},
+		{selector: "env=prod,team", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			got, err := parseSelector(tt.selector)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "invalid selector term") {
+					t.Fatalf("parseSelector(%q) error = %v, want an invalid selector term error", tt.selector, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSelector(%q) error = %v", tt.selector, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSelector(%q) = %+v, want %+v", tt.selector, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	account := &CloudAccount{ID: "a1b2", Alias: "prod-main", Provider: "aws", Labels: map[string]string{"env": "prod", "team": ""}}
+	unaliased := &CloudAccount{ID: "c3d4", Provider: "gcp"}
+
+	tests := []struct {
+		selector string
+		account  *CloudAccount
+		want     bool
+	}{
+		{"", account, true},
+		{"env=prod", account, true},
+		{"env=dev", account, false},
+		{"env!=dev", account, true},
+		{"env!=prod", account, false},
+		{"env=prod,provider=aws", account, true},
+		{"env=prod,provider=gcp", account, false},
+		{"id=a1b2", account, true},
+		{"alias=prod-main", account, true},
+		{"team=", account, true},
+		{"owner!=me", account, true},
+		{"owner=", account, false},
+		{"alias!=prod-main", unaliased, true},
+		{"alias=", unaliased, false},
+		{"provider=gcp", unaliased, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.account.ID+" "+tt.selector, func(t *testing.T) {
+			selector, err := parseSelector(tt.selector)
+			if err != nil {
+				t.Fatalf("parseSelector(%q) error = %v", tt.selector, err)
+			}
+			if got := selector.Matches(tt.account); got != tt.want {
+				t.Errorf("Matches(%q) = %v, want %v", tt.selector, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		values  []string
+		want    map[string]string
+		wantErr string
+	}{
+		{values: nil, want: map[string]string{}},
+		{values: []string{"env=prod", "team=", "cost-center=a=b"}, want: map[string]string{"env": "prod", "team": "", "cost-center": "a=b"}},
+		{values: []string{"example.com/owner=me"}, want: map[string]string{"example.com/owner": "me"}},
+		{values: []string{"env"}, wantErr: "expected key=value"},
+		{values: []string{"=prod"}, wantErr: "invalid label key"},
+		{values: []string{"-env=prod"}, wantErr: "invalid label key"},
+		{values: []string{"env,team=prod"}, wantErr: "invalid label key"},
+		{values: []string{"provider=aws"}, wantErr: "is reserved"},
+		{values: []string{"alias=prod"}, wantErr: "is reserved"},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.values, " "), func(t *testing.T) {
+			got, err := parseLabels(tt.values)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseLabels(%q) error = %v, want it to contain %q", tt.values, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseLabels(%q) error = %v", tt.values, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLabels(%q) = %v, want %v", tt.values, got, tt.want)
+			}
+		})
+	}
+}
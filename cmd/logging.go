@@ -0,0 +1,99 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Log levels, in increasing severity
+const (
+	logLevelDebug = iota
+	logLevelInfo
+	logLevelWarn
+	logLevelError
+)
+
+var logLevels = map[string]int{
+	"debug":   logLevelDebug,
+	"info":    logLevelInfo,
+	"warn":    logLevelWarn,
+	"warning": logLevelWarn,
+	"error":   logLevelError,
+}
+
+// plainMarkers replaces the emoji in log messages when --no-color is set
+var plainMarkers = strings.NewReplacer(
+	"✅", "[OK]",
+	"❌", "[ERROR]",
+	"⚠️", "[WARN]",
+)
+
+// ansiEscape matches the color codes in the output of Terraform that is passed on to the log
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// plainText is a log message without emoji and color codes
+func plainText(p []byte) []byte {
+	return []byte(plainMarkers.Replace(string(ansiEscape.ReplaceAll(p, nil))))
+}
+
+// levelWriter filters log output by the level of each message.
+// Messages carry their level through the markers the commands already use:
+// ❌ for errors, ⚠️ for warnings and a "Debug:" prefix for debug output.
+type levelWriter struct {
+	out     io.Writer
+	level   int
+	noColor bool
+}
+
+// setupLogging routes the standard logger through a levelWriter
+func setupLogging(level string, noColor bool) error {
+	minLevel, ok := logLevels[strings.ToLower(level)]
+	if !ok {
+		return fmt.Errorf("unsupported log level: %s", level)
+	}
+
+	log.SetOutput(&levelWriter{out: os.Stderr, level: minLevel, noColor: noColor})
+	return nil
+}
+
+// debugf logs a message that is only shown with --log-level debug
+func debugf(format string, v ...interface{}) {
+	log.Printf("Debug: "+format, v...)
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	if messageLevel(p) < w.level {
+		return len(p), nil
+	}
+
+	out := p
+	if w.noColor {
+		out = plainText(p)
+	}
+	if _, err := w.out.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// messageLevel derives the level of a log line from its markers
+func messageLevel(p []byte) int {
+	switch {
+	case bytes.Contains(p, []byte("❌")):
+		return logLevelError
+	case bytes.Contains(p, []byte("⚠️")):
+		return logLevelWarn
+	case bytes.Contains(p, []byte("Debug: ")):
+		return logLevelDebug
+	default:
+		return logLevelInfo
+	}
+}
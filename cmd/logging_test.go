@@ -0,0 +1,40 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLevelWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   int
+		noColor bool
+		message string
+		want    string
+	}{
+		{name: "info", level: logLevelInfo, message: "✅ added\n", want: "✅ added\n"},
+		{name: "debug below the level", level: logLevelInfo, message: "Debug: plan\n", want: ""},
+		{name: "warning at the level", level: logLevelWarn, message: "⚠️ Warning: slow\n", want: "⚠️ Warning: slow\n"},
+		{name: "info below the level", level: logLevelWarn, message: "Synced\n", want: ""},
+		{name: "no color markers", level: logLevelInfo, noColor: true, message: "❌ failed, ⚠️ retrying, ✅ done\n", want: "[ERROR] failed, [WARN] retrying, [OK] done\n"},
+		{name: "no color Terraform output", level: logLevelInfo, noColor: true, message: "Terraform init output:\n\x1b[0m\x1b[1m\x1b[32mTerraform has been successfully initialized!\x1b[0m\n", want: "Terraform init output:\nTerraform has been successfully initialized!\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := &levelWriter{out: &out, level: tt.level, noColor: tt.noColor}
+			n, err := w.Write([]byte(tt.message))
+			if err != nil || n != len(tt.message) {
+				t.Fatalf("Write() = %d, %v; want %d, nil", n, err, len(tt.message))
+			}
+			if out.String() != tt.want {
+				t.Errorf("Write() printed %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// currentSchemaVersion is the schema version of cloud_accounts.conf written by this build
+const currentSchemaVersion = 3
+
+// configMigration upgrades the raw JSON of cloud_accounts.conf by one schema version.
+// Migrations see the credentials of encrypted files in plain text; credentials kept in
+// an external store are not part of the file and are not migrated.
+type configMigration struct {
+	// Version is the schema version the migration upgrades to
+	Version     int
+	Description string
+	Apply       func(config map[string]interface{}) error
+}
+
+// configMigrations lists every migration in version order
+var configMigrations = []configMigration{
+	{
+		Version:     1,
+		Description: "add the accounts list to files created empty by init",
+		Apply:       migrateAccountsList,
+	},
+	{
+		Version:     2,
+		Description: "record credential types and keep GCP service account keys as credential documents",
+		Apply:       migrateCredentialTypes,
+	},
+	{
+		Version:     3,
+		Description: "record the source account of accounts that use the credentials of another account",
+		Apply:       migrateSourceAccounts,
+	},
+}
+
+// schemaVersion returns the schema version of a raw config; files without one are version 0
+func schemaVersion(config map[string]interface{}) (int, error) {
+	value, ok := config["schema_version"]
+	if !ok {
+		return 0, nil
+	}
+	version, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("invalid schema_version: %v", value)
+	}
+	return int(version), nil
+}
+
+// pendingMigrations returns the migrations needed to bring a file at version up to date
+func pendingMigrations(version int) ([]configMigration, error) {
+	if version > currentSchemaVersion {
+		return nil, fmt.Errorf("cloud_accounts.conf has schema version %d, but this version of yogaya only supports up to %d; please upgrade yogaya", version, currentSchemaVersion)
+	}
+
+	pending := []configMigration{}
+	for _, migration := range configMigrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// applyMigrations runs the migrations on a raw config and stamps the new schema version
+func applyMigrations(config map[string]interface{}, migrations []configMigration) error {
+	for _, migration := range migrations {
+		if err := migration.Apply(config); err != nil {
+			return fmt.Errorf("migration to schema version %d failed: %v", migration.Version, err)
+		}
+		config["schema_version"] = migration.Version
+		log.Printf("✅ Migrated cloud_accounts.conf to schema version %d: %s", migration.Version, migration.Description)
+	}
+	return nil
+}
+
+// backupConfigFile copies the file as it was before migrating it
+func backupConfigFile(path string, data []byte, version int) (string, error) {
+	backupPath := fmt.Sprintf("%s.v%d.bak", path, version)
+	if _, err := os.Stat(backupPath); err == nil {
+		backupPath = fmt.Sprintf("%s.v%d.%s.bak", path, version, time.Now().Format("20060102150405"))
+	}
+	if err := os.WriteFile(backupPath, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to back up %s: %v", path, err)
+	}
+	return backupPath, nil
+}
+
+// openRawCredentials decrypts the sealed credentials of a raw config so migrations can see them.
+// saveConfig seals them again after the migrated file is loaded.
+func openRawCredentials(config map[string]interface{}, key []byte) error {
+	for _, account := range rawAccounts(config) {
+		if account["sealed_credentials"] == nil {
+			continue
+		}
+		if key == nil {
+			return fmt.Errorf("account %v has encrypted credentials but the file has no encryption settings", account["id"])
+		}
+
+		sealed := &SealedCredentials{}
+		if err := remarshal(account["sealed_credentials"], sealed); err != nil {
+			return err
+		}
+		id, _ := account["id"].(string)
+		plaintext, err := openCredentials(key, id, sealed)
+		if err != nil {
+			return err
+		}
+		var creds interface{}
+		if err := json.Unmarshal(plaintext, &creds); err != nil {
+			return fmt.Errorf("failed to decode credentials for account %s: %v", id, err)
+		}
+		account["credentials"] = creds
+		delete(account, "sealed_credentials")
+	}
+	return nil
+}
+
+// PlanConfigMigrations returns the schema version of a cloud_accounts.conf file
+// and the migrations loading it would apply, without changing the file
+func PlanConfigMigrations(path string) (int, []configMigration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return 0, nil, err
+	}
+	version, err := schemaVersion(raw)
+	if err != nil {
+		return 0, nil, err
+	}
+	pending, err := pendingMigrations(version)
+	return version, pending, err
+}
+
+// rawAccounts returns the accounts of a raw config as JSON objects
+func rawAccounts(config map[string]interface{}) []map[string]interface{} {
+	list, _ := config["accounts"].([]interface{})
+	accounts := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		if account, ok := item.(map[string]interface{}); ok {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+// migrateAccountsList makes sure the accounts list exists
+func migrateAccountsList(config map[string]interface{}) error {
+	if _, ok := config["accounts"]; !ok || config["accounts"] == nil {
+		config["accounts"] = []interface{}{}
+	}
+	if _, ok := config["accounts"].([]interface{}); !ok {
+		return fmt.Errorf("accounts is not a list")
+	}
+	return nil
+}
+
+// migrateCredentialTypes sets the credential type fields that older versions did not write
+func migrateCredentialTypes(config map[string]interface{}) error {
+	for _, account := range rawAccounts(config) {
+		if id, ok := account["id"].(string); ok && account["fingerprint"] == nil {
+			account["fingerprint"] = id
+		}
+
+		creds, ok := account["credentials"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		switch account["provider"] {
+		case "aws":
+			if creds["type"] == nil && creds["access_key_id"] != nil {
+				creds["type"] = awsCredentialStatic
+			}
+		case "azure":
+			if creds["auth_type"] == nil {
+				creds["auth_type"] = azureAuthCLI
+			}
+		case "gcp":
+			if creds["document"] != nil || creds["private_key"] == nil {
+				continue
+			}
+			legacy := &GCPCloudCredentials{}
+			if err := remarshal(creds, legacy); err != nil {
+				return err
+			}
+			document, err := legacy.credentialsJSON()
+			if err != nil {
+				return err
+			}
+			creds["type"] = gcpServiceAccount
+			creds["principal"] = legacy.ClientEmail
+			creds["document"] = json.RawMessage(document)
+			for _, field := range []string{"private_key_id", "private_key", "client_email", "client_id"} {
+				delete(creds, field)
+			}
+		}
+	}
+	return nil
+}
+
+// migrateSourceAccounts copies the source account out of the credentials onto the account.
+// Discovered accounts in an external store get the source of their discovery; other
+// accounts in an external store are recorded when they are next revalidated.
+func migrateSourceAccounts(config map[string]interface{}) error {
+	discoverySources := map[string]string{}
+	discoveries, _ := config["discoveries"].([]interface{})
+	for _, item := range discoveries {
+		discovery, _ := item.(map[string]interface{})
+		id, _ := discovery["id"].(string)
+		source, _ := discovery["source_account_id"].(string)
+		discoverySources[id] = source
+	}
+
+	for _, account := range rawAccounts(config) {
+		if creds, ok := account["credentials"].(map[string]interface{}); ok {
+			if source, ok := creds["source_account_id"].(string); ok && source != "" {
+				account["source_account_id"] = source
+			}
+			continue
+		}
+		if discoveredBy, _ := account["discovered_by"].(string); discoverySources[discoveredBy] != "" {
+			account["source_account_id"] = discoverySources[discoveredBy]
+			continue
+		}
+		if store, _ := account["credential_store"].(string); store != "" && store != fileStoreBackend {
+			log.Printf("⚠️ Account %v keeps its credentials in the %s store; run `yogaya accounts revalidate %v` so removing the account it uses is refused", account["id"], store, account["id"])
+		}
+	}
+	return nil
+}
+
+// remarshal converts between JSON-compatible values through a JSON round-trip
+func remarshal(from, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
@@ -0,0 +1,517 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// defaultPathTemplate reproduces the original layout, generated/<provider>-<id>/<region>
+const defaultPathTemplate = "{provider}-{account_id}/{region}"
+
+// Placeholders of a path template
+const (
+	placeholderProvider  = "{provider}"
+	placeholderAlias     = "{alias}"
+	placeholderAccountID = "{account_id}"
+	placeholderRegion    = "{region}"
+	placeholderService   = "{service}"
+)
+
+var placeholderPattern = regexp.MustCompile(`\{[^}]*\}`)
+
+// defaultOutputDir is the output root below the workspace when neither --output-dir nor
+// a saved root is set
+const defaultOutputDir = "generated"
+
+// OutputConfig is the output layout of a workspace saved in cloud_accounts.conf
+type OutputConfig struct {
+	// Root is the directory generated code is written to. A relative root is relative
+	// to the workspace directory.
+	Root         string `json:"root,omitempty"`
+	PathTemplate string `json:"path_template,omitempty"`
+}
+
+// OutputLayout is where a generate run writes code: a root directory and a path template below it
+type OutputLayout struct {
+	Root         string
+	PathTemplate string
+}
+
+// validatePathTemplate rejects unknown placeholders and templates whose accounts,
+// regions or services would overwrite each other
+func validatePathTemplate(template string) error {
+	for _, placeholder := range placeholderPattern.FindAllString(template, -1) {
+		switch placeholder {
+		case placeholderProvider, placeholderAlias, placeholderAccountID, placeholderRegion, placeholderService:
+		default:
+			return fmt.Errorf("unknown placeholder %s in path template; use {provider}, {alias}, {account_id}, {region} or {service}", placeholder)
+		}
+	}
+	if filepath.IsAbs(template) {
+		return fmt.Errorf("path template %q must be relative to the output root", template)
+	}
+	for _, segment := range strings.Split(filepath.ToSlash(template), "/") {
+		if segment == ".." {
+			return fmt.Errorf("path template %q must stay inside the output root", template)
+		}
+	}
+	if !strings.Contains(template, placeholderAccountID) && !strings.Contains(template, placeholderAlias) {
+		return fmt.Errorf("path template %q needs {account_id} or {alias} to keep accounts apart", template)
+	}
+	if strings.Contains(template, placeholderService) && !strings.Contains(template, placeholderRegion) {
+		return fmt.Errorf("path template %q needs {region} when it uses {service}", template)
+	}
+	return nil
+}
+
+// outputLayout resolves the output root and path template of a generate run. Flags win over
+// the workspace config. Without either, code is written to <workspace>/generated; a relative
+// saved root is relative to the workspace directory. An explicit --output-dir is relative to
+// the current directory, like any other path argument. While the workspace records its
+// history, the root must lie inside the workspace, or generate would commit no code.
+func (cm *CredentialManager) outputLayout(rootFlagChanged bool, templateFlag string) (*OutputLayout, error) {
+	saved := OutputConfig{}
+	if cm.config.Output != nil {
+		saved = *cm.config.Output
+	}
+
+	workspace := workspaceOfConfig(cm.configPath)
+	layout := &OutputLayout{Root: workspace.OutputDir(), PathTemplate: defaultPathTemplate}
+	switch {
+	case rootFlagChanged:
+		if globalOptions.OutputDir == "" {
+			return nil, fmt.Errorf("--output-dir cannot be empty")
+		}
+		layout.Root = globalOptions.OutputDir
+	case saved.Root != "":
+		layout.Root = saved.Root
+		if !filepath.IsAbs(layout.Root) {
+			layout.Root = filepath.Join(workspace.Dir, layout.Root)
+		}
+	}
+	if workspace.recordsHistory() && !isWithinDir(layout.Root, workspace.Dir) {
+		return nil, fmt.Errorf("output root %s is outside the workspace %s, so its code would be missing from the workspace history; use a directory inside the workspace or pass --no-commit", layout.Root, workspace.Dir)
+	}
+
+	layout.PathTemplate = firstNonEmpty(templateFlag, saved.PathTemplate, defaultPathTemplate)
+	if err := validatePathTemplate(layout.PathTemplate); err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
+
+// legacyOutputDir returns the generated directory next to .yogaya that earlier versions
+// wrote the code of the default workspace to, or "" when there is none
+func legacyOutputDir(workspace *Workspace) string {
+	if workspace.Name != defaultWorkspaceName {
+		return ""
+	}
+	dir := filepath.Join(filepath.Dir(workspace.Root), defaultOutputDir)
+	if !isDir(dir) {
+		return ""
+	}
+	return dir
+}
+
+// SetOutputConfig saves the output root and path template of the workspace.
+// Empty values are left unchanged. A layout that generate would refuse is not saved.
+func (cm *CredentialManager) SetOutputConfig(root, template string) error {
+	previous := cm.config.Output
+	output := OutputConfig{}
+	if previous != nil {
+		output = *previous
+	}
+	if root != "" {
+		output.Root = root
+	}
+	if template != "" {
+		output.PathTemplate = template
+	}
+
+	cm.config.Output = &output
+	if _, err := cm.outputLayout(false, ""); err != nil {
+		cm.config.Output = previous
+		return err
+	}
+	return cm.saveConfig()
+}
+
+// backupSuffix matches the names RenameDirWithBackup gives to backups
+var backupSuffix = regexp.MustCompile(`_bk[0-9]*$`)
+
+// accountOutput places the generated code of one account according to the output layout.
+// Every directory or file it replaces is backed up with a _bk suffix first, so an
+// interrupted run can be rolled back.
+type accountOutput struct {
+	layout  *OutputLayout
+	account CloudAccount
+	// partial is set when filters select only some regions or services, so only their
+	// code is replaced and the rest of the account's output is kept
+	partial bool
+	// affects reports whether the run imports a service; nil when it imports every service
+	affects func(service string) bool
+
+	mu       sync.Mutex
+	prepared map[string]bool
+	regions  map[string]bool
+	files    map[string]bool
+	// backups are the directories and files replaced in this run, in the order they were moved
+	backups []outputBackup
+}
+
+// outputBackup is a directory or file moved away by RenameDirWithBackup. An empty backup
+// means path did not exist before the run.
+type outputBackup struct {
+	path   string
+	backup string
+}
+
+// newAccountOutput prepares the output of an account. A run without filters backs up the
+// account's whole directory, so code of regions and services that no longer exist does
+// not linger. A partial run only replaces the code of the regions and services it imports.
+func newAccountOutput(layout *OutputLayout, account CloudAccount, filters *GenerateFilters) (*accountOutput, error) {
+	output := &accountOutput{
+		layout:   layout,
+		account:  account,
+		partial:  filters.partial(),
+		prepared: map[string]bool{},
+		regions:  map[string]bool{},
+		files:    map[string]bool{},
+	}
+	if filters.selectsResources() {
+		output.affects = filters.affectsService
+	}
+	if !output.partial {
+		if dir := output.accountDir(); dir != "" {
+			if _, err := output.backup(dir); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return output, nil
+}
+
+// accountDir returns the directory that only holds code of this account: the leading part
+// of the template before {region} and {service}, if it names the account
+func (o *accountOutput) accountDir() string {
+	segments := strings.Split(filepath.ToSlash(o.layout.PathTemplate), "/")
+	prefix := []string{}
+	for _, segment := range segments {
+		if strings.Contains(segment, placeholderRegion) || strings.Contains(segment, placeholderService) {
+			break
+		}
+		prefix = append(prefix, segment)
+	}
+
+	template := strings.Join(prefix, "/")
+	if !strings.Contains(template, placeholderAccountID) && !strings.Contains(template, placeholderAlias) {
+		return ""
+	}
+	return filepath.Join(o.layout.Root, filepath.FromSlash(o.render(template, "", "")))
+}
+
+// render replaces the placeholders of a template. Values are made safe to use as a single
+// path segment.
+func (o *accountOutput) render(template, region, service string) string {
+	safe := strings.NewReplacer("/", "-", `\`, "-", "..", "-")
+	alias := o.account.Alias
+	if alias == "" {
+		alias = o.account.ID
+	}
+	return strings.NewReplacer(
+		placeholderProvider, safe.Replace(o.account.Provider),
+		placeholderAlias, safe.Replace(alias),
+		placeholderAccountID, safe.Replace(o.account.ID),
+		placeholderRegion, safe.Replace(region),
+		placeholderService, safe.Replace(service),
+	).Replace(template)
+}
+
+// perService reports whether each service is written to its own directory
+func (o *accountOutput) perService() bool {
+	return strings.Contains(o.layout.PathTemplate, placeholderService)
+}
+
+// path returns the directory of a region and service in the output
+func (o *accountOutput) path(region, service string) string {
+	return filepath.Join(o.layout.Root, filepath.FromSlash(o.render(o.layout.PathTemplate, region, service)))
+}
+
+// Dir returns the directory for a region and service, creating it the first time it is used
+// in this run. A directory that only holds code this run replaces is backed up first; in
+// a partial run, the merged directory of a region may hold code of other regions, so
+// publishFile backs up the single file it replaces instead.
+func (o *accountOutput) Dir(region, service string) (string, error) {
+	dir := o.path(region, service)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if region != "" {
+		o.regions[region] = true
+	}
+	if o.prepared[dir] {
+		return dir, nil
+	}
+	if o.partial && !o.perService() {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			o.backups = append(o.backups, outputBackup{path: dir})
+		}
+	} else if _, err := o.backup(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating output directory: %v", err)
+	}
+	o.prepared[dir] = true
+	return dir, nil
+}
+
+// backup moves a directory or file to a _bk path and remembers it for rollback.
+// It returns the path of the backup, or "" when there was nothing to back up.
+func (o *accountOutput) backup(path string) (string, error) {
+	backup, err := RenameDirWithBackup(path)
+	if err != nil {
+		return "", err
+	}
+	o.backups = append(o.backups, outputBackup{path: path, backup: backup})
+	return backup, nil
+}
+
+// rollback removes the code written in this run and moves the backed up directories and
+// files back. It is used when a run is cancelled, so no half-written account is left behind.
+func (o *accountOutput) rollback() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// Paths moved last lie inside the ones moved first, so restore in reverse
+	for i := len(o.backups) - 1; i >= 0; i-- {
+		moved := o.backups[i]
+		if err := os.RemoveAll(moved.path); err != nil {
+			return fmt.Errorf("error removing partial output %s: %v", moved.path, err)
+		}
+		if moved.backup == "" {
+			continue
+		}
+		if err := os.Rename(moved.backup, moved.path); err != nil {
+			return fmt.Errorf("error restoring %s from %s: %v", moved.path, moved.backup, err)
+		}
+		log.Printf("✅ %v restored from %v", moved.path, moved.backup)
+	}
+
+	o.prepared = map[string]bool{}
+	o.regions = map[string]bool{}
+	o.files = map[string]bool{}
+	o.backups = nil
+	return nil
+}
+
+// publishFile copies a merged file to the directory of its region. In a partial run the
+// previous file is backed up, and when only some services were imported, the sections of
+// the other services are carried over from it.
+func (o *accountOutput) publishFile(path, region string) error {
+	dir, err := o.Dir(region, "")
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, filepath.Base(path))
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.files[dst] = true
+	if !o.partial {
+		return copyFile(path, dst)
+	}
+
+	backup, err := o.backup(dst)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(path, dst); err != nil {
+		return err
+	}
+	if backup == "" || o.affects == nil {
+		return nil
+	}
+
+	previous, err := os.ReadFile(backup)
+	if err != nil {
+		return err
+	}
+	kept, unattributed := keptSections(string(previous), func(service string) bool { return !o.affects(service) })
+	if unattributed {
+		log.Printf("⚠️ %s has no per-service sections, so the code of services not imported in this run was not carried over; it remains in %s", dst, backup)
+	}
+	if kept == "" {
+		return nil
+	}
+	file, err := os.OpenFile(dst, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(kept); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// publishServices copies the .tf files Terraformer wrote below terraformerDir to the
+// directory of their service, which is the directory that contains them
+func (o *accountOutput) publishServices(terraformerDir, region string) error {
+	return filepath.Walk(terraformerDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".tf") {
+			return nil
+		}
+
+		dir, err := o.Dir(region, filepath.Base(filepath.Dir(path)))
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, info.Name())
+		o.mu.Lock()
+		o.files[dst] = true
+		o.mu.Unlock()
+		return copyFile(path, dst)
+	})
+}
+
+// pruneServices backs up the directories of services of a region that this run imported
+// but wrote no code for, so code of resources that no longer exist does not linger.
+// The candidates are the named services and, when {service} is the last segment of the
+// template, the service directories already in the output.
+func (o *accountOutput) pruneServices(region string, named []string) error {
+	candidates := append([]string{}, named...)
+	segments := strings.Split(filepath.ToSlash(o.layout.PathTemplate), "/")
+	if segments[len(segments)-1] == placeholderService {
+		entries, err := os.ReadDir(filepath.Dir(o.path(region, "service")))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && !backupSuffix.MatchString(entry.Name()) {
+				candidates = append(candidates, entry.Name())
+			}
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, service := range candidates {
+		if o.affects != nil && !o.affects(service) {
+			continue
+		}
+		dir := o.path(region, service)
+		if o.prepared[dir] {
+			continue
+		}
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		if _, err := o.backup(dir); err != nil {
+			return err
+		}
+		o.prepared[dir] = true
+	}
+	return nil
+}
+
+// summary returns the regions written in this run and the number of resources in the written files
+func (o *accountOutput) summary() ([]string, int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	regions := make([]string, 0, len(o.regions))
+	for region := range o.regions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	resources := 0
+	for file := range o.files {
+		if strings.HasSuffix(file, ".tf") {
+			resources += countTerraformResources(file)
+		}
+	}
+	return regions, resources
+}
+
+// keptSections returns the "# Start of <service>/<file>" ... "# End of <service>/<file>"
+// sections of a merged file whose service is kept. unattributed reports sections without
+// a service, written before merged files named the service of each section.
+func keptSections(content string, keep func(service string) bool) (kept string, unattributed bool) {
+	var builder strings.Builder
+	section, keeping := "", false
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if section == "" {
+			name, ok := strings.CutPrefix(trimmed, "# Start of ")
+			if !ok {
+				continue
+			}
+			service, _, qualified := strings.Cut(name, "/")
+			if !qualified {
+				unattributed = true
+			}
+			section, keeping = name, qualified && keep(service)
+		}
+		if keeping {
+			builder.WriteString(line)
+		}
+		if trimmed == "# End of "+section {
+			if keeping {
+				builder.WriteString("\n")
+			}
+			section, keeping = "", false
+		}
+	}
+	return builder.String(), unattributed
+}
+
+// newWorkDir creates a temporary directory for one Terraformer run. Its base name is the
+// region, which mergeFilesOfRefion uses to name the merged file.
+func newWorkDir(region string) (string, func(), error) {
+	tempDir, err := os.MkdirTemp("", "yogaya-generate-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating work directory: %v", err)
+	}
+	cleanup := func() { os.RemoveAll(tempDir) }
+
+	workDir := filepath.Join(tempDir, firstNonEmpty(region, "global"))
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("error creating work directory: %v", err)
+	}
+	return workDir, cleanup, nil
+}
+
+// copyFile copies a file; the work directory may be on another file system than the output
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,333 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidatePathTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  string
+	}{
+		{template: defaultPathTemplate},
+		{template: "{alias}/{region}/{service}"},
+		{template: "accounts/{provider}/{account_id}"},
+		{template: "{alias}/{zone}", wantErr: "unknown placeholder {zone}"},
+		{template: "/srv/{account_id}", wantErr: "must be relative"},
+		{template: "../{account_id}/{region}", wantErr: "must stay inside"},
+		{template: "{provider}/{region}", wantErr: "needs {account_id} or {alias}"},
+		{template: "{alias}/{service}", wantErr: "needs {region}"},
+	}
+
+	for _, tt := range tests {
+		err := validatePathTemplate(tt.template)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("validatePathTemplate(%q) error = %v", tt.template, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("validatePathTemplate(%q) error = %v, want it to contain %q", tt.template, err, tt.wantErr)
+		}
+	}
+}
+
+func TestOutputLayout(t *testing.T) {
+	previousDir, previousNoCommit := globalOptions.OutputDir, globalOptions.NoCommit
+	defer func() { globalOptions.OutputDir, globalOptions.NoCommit = previousDir, previousNoCommit }()
+
+	tests := []struct {
+		name  string
+		named bool
+		// history makes the workspace a Git repository
+		history     bool
+		noCommit    bool
+		saved       *OutputConfig
+		outputDir   string
+		flagChanged bool
+		template    string
+		// wantRoot is relative to the workspace directory unless it is absolute
+		wantRoot     string
+		wantTemplate string
+		wantErr      string
+	}{
+		{
+			name:         "default workspace",
+			history:      true,
+			wantRoot:     "generated",
+			wantTemplate: defaultPathTemplate,
+		},
+		{
+			name:         "named workspace",
+			named:        true,
+			history:      true,
+			wantRoot:     "generated",
+			wantTemplate: defaultPathTemplate,
+		},
+		{
+			name:         "relative saved root",
+			named:        true,
+			history:      true,
+			saved:        &OutputConfig{Root: "infra/live", PathTemplate: "{alias}/{region}"},
+			wantRoot:     filepath.Join("infra", "live"),
+			wantTemplate: "{alias}/{region}",
+		},
+		{
+			name:    "saved root outside the workspace",
+			history: true,
+			saved:   &OutputConfig{Root: "/srv/terraform"},
+			wantErr: "outside the workspace",
+		},
+		{
+			name:         "saved root outside a workspace without history",
+			saved:        &OutputConfig{Root: "/srv/terraform"},
+			wantRoot:     "/srv/terraform",
+			wantTemplate: defaultPathTemplate,
+		},
+		{
+			name:        "--output-dir outside the workspace",
+			history:     true,
+			outputDir:   "out",
+			flagChanged: true,
+			wantErr:     "use a directory inside the workspace or pass --no-commit",
+		},
+		{
+			name:         "flags win over the saved layout with --no-commit",
+			history:      true,
+			noCommit:     true,
+			saved:        &OutputConfig{Root: "infra/live", PathTemplate: "{alias}/{region}"},
+			outputDir:    "out",
+			flagChanged:  true,
+			template:     "{provider}/{alias}/{region}/{service}",
+			wantRoot:     "out",
+			wantTemplate: "{provider}/{alias}/{region}/{service}",
+		},
+		{
+			name:        "empty --output-dir",
+			flagChanged: true,
+			wantErr:     "--output-dir cannot be empty",
+		},
+		{
+			name:     "invalid template",
+			template: "{provider}/{region}",
+			wantErr:  "needs {account_id} or {alias}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workspaceDir := filepath.Join(t.TempDir(), workspaceDirName)
+			if tt.named {
+				workspaceDir = filepath.Join(workspaceDir, workspacesDirName, "acme")
+			}
+			if tt.history {
+				if err := os.MkdirAll(filepath.Join(workspaceDir, ".git"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			globalOptions.OutputDir, globalOptions.NoCommit = tt.outputDir, tt.noCommit
+			cm := &CredentialManager{configPath: filepath.Join(workspaceDir, cloudAccountsFile)}
+			cm.config.Output = tt.saved
+
+			layout, err := cm.outputLayout(tt.flagChanged, tt.template)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("outputLayout() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("outputLayout() error = %v", err)
+			}
+			wantRoot := tt.wantRoot
+			if !filepath.IsAbs(wantRoot) && !tt.flagChanged {
+				wantRoot = filepath.Join(workspaceDir, wantRoot)
+			}
+			if layout.Root != wantRoot || layout.PathTemplate != tt.wantTemplate {
+				t.Errorf("outputLayout() = %s, %s; want %s, %s", layout.Root, layout.PathTemplate, wantRoot, tt.wantTemplate)
+			}
+		})
+	}
+}
+
+func TestSetOutputConfigRefusesRootOutsideTheWorkspace(t *testing.T) {
+	workspaceDir := filepath.Join(t.TempDir(), workspaceDirName)
+	if err := os.MkdirAll(filepath.Join(workspaceDir, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cm := &CredentialManager{configPath: filepath.Join(workspaceDir, cloudAccountsFile)}
+	if err := cm.SetOutputConfig("live", ""); err != nil {
+		t.Fatalf("SetOutputConfig(live) error = %v", err)
+	}
+
+	err := cm.SetOutputConfig("../live", "{alias}/{region}")
+	if err == nil || !strings.Contains(err.Error(), "outside the workspace") {
+		t.Fatalf("SetOutputConfig(../live) error = %v, want it refused", err)
+	}
+	if want := (OutputConfig{Root: "live"}); *cm.config.Output != want {
+		t.Errorf("output config = %+v, want %+v kept", *cm.config.Output, want)
+	}
+}
+
+func TestLegacyOutputDir(t *testing.T) {
+	project := t.TempDir()
+	root := filepath.Join(project, workspaceDirName)
+	defaultWorkspace := &Workspace{Root: root, Name: defaultWorkspaceName, Dir: root}
+	named := &Workspace{Root: root, Name: "acme", Dir: filepath.Join(root, workspacesDirName, "acme")}
+
+	if got := legacyOutputDir(defaultWorkspace); got != "" {
+		t.Errorf("legacyOutputDir() = %s before the old directory exists", got)
+	}
+	if err := os.MkdirAll(filepath.Join(project, "generated"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := legacyOutputDir(defaultWorkspace), filepath.Join(project, "generated"); got != want {
+		t.Errorf("legacyOutputDir() = %s, want %s", got, want)
+	}
+	if got := legacyOutputDir(named); got != "" {
+		t.Errorf("legacyOutputDir() of a named workspace = %s, want none", got)
+	}
+}
+
+func TestAccountOutputDir(t *testing.T) {
+	root := t.TempDir()
+	account := CloudAccount{ID: "abc123", Alias: "team/prod", Provider: "aws"}
+
+	tests := []struct {
+		template   string
+		account    CloudAccount
+		region     string
+		service    string
+		want       string
+		accountDir string
+	}{
+		{template: defaultPathTemplate, account: account, region: "eu-west-1", want: "aws-abc123/eu-west-1", accountDir: "aws-abc123"},
+		{template: "{alias}/{region}/{service}", account: account, region: "eu-west-1", service: "s3", want: "team-prod/eu-west-1/s3", accountDir: "team-prod"},
+		{template: "{alias}/{region}", account: CloudAccount{ID: "abc123", Provider: "gcp"}, region: "global", want: "abc123/global", accountDir: "abc123"},
+		{template: "{region}/{account_id}", account: account, region: "us-east-1", want: "us-east-1/abc123", accountDir: ""},
+	}
+
+	for _, tt := range tests {
+		output := &accountOutput{
+			layout:   &OutputLayout{Root: root, PathTemplate: tt.template},
+			account:  tt.account,
+			prepared: map[string]bool{},
+			regions:  map[string]bool{},
+		}
+
+		dir, err := output.Dir(tt.region, tt.service)
+		if err != nil {
+			t.Fatalf("Dir() error = %v", err)
+		}
+		if want := filepath.Join(root, tt.want); dir != want {
+			t.Errorf("Dir(%s) with %q = %s, want %s", tt.region, tt.template, dir, want)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			t.Errorf("Dir() did not create %s", dir)
+		}
+
+		want := ""
+		if tt.accountDir != "" {
+			want = filepath.Join(root, tt.accountDir)
+		}
+		if got := output.accountDir(); got != want {
+			t.Errorf("accountDir() with %q = %s, want %s", tt.template, got, want)
+		}
+	}
+}
+
+// writeFiles writes files below root, keyed by their slash-separated relative path
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readFiles returns the files below root keyed by their slash-separated relative path
+func readFiles(t *testing.T, root string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(root, path)
+		files[filepath.ToSlash(name)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestAccountOutputRollback(t *testing.T) {
+	previous := map[string]string{
+		"aws-abc123/eu-west-1/eu-west-1.tf": "old eu",
+		"aws-abc123/us-east-1/us-east-1.tf": "old us",
+		"aws-abc123_bk/eu-west-1/main.tf":   "backup of an earlier run",
+		"aws-other/eu-west-1/eu-west-1.tf":  "another account",
+	}
+	account := CloudAccount{ID: "abc123", Provider: "aws"}
+
+	tests := []struct {
+		name     string
+		existing map[string]string
+		filters  *GenerateFilters
+		regions  []string
+	}{
+		{name: "whole account", existing: previous, filters: &GenerateFilters{}, regions: []string{"eu-west-1", "ap-south-1"}},
+		{name: "some regions", existing: previous, filters: &GenerateFilters{Regions: []string{"eu-west-1", "ap-south-1"}}, regions: []string{"eu-west-1", "ap-south-1"}},
+		{name: "some services", existing: previous, filters: &GenerateFilters{Resources: []string{"vpc"}}, regions: []string{"eu-west-1"}},
+		{name: "new account", existing: map[string]string{"aws-other/eu-west-1/eu-west-1.tf": "another account"}, filters: &GenerateFilters{}, regions: []string{"eu-west-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, tt.existing)
+
+			output, err := newAccountOutput(&OutputLayout{Root: root, PathTemplate: defaultPathTemplate}, account, tt.filters)
+			if err != nil {
+				t.Fatalf("newAccountOutput() error = %v", err)
+			}
+			work := t.TempDir()
+			for _, region := range tt.regions {
+				merged := filepath.Join(work, region+".tf")
+				writeFiles(t, work, map[string]string{region + ".tf": "# vpc\nhalf written\n"})
+				if err := output.publishFile(merged, region); err != nil {
+					t.Fatalf("publishFile(%s) error = %v", region, err)
+				}
+			}
+
+			if err := output.rollback(); err != nil {
+				t.Fatalf("rollback() error = %v", err)
+			}
+			if got := readFiles(t, root); !reflect.DeepEqual(got, tt.existing) {
+				t.Errorf("files after rollback = %v, want %v", got, tt.existing)
+			}
+			if regions, _ := output.summary(); len(regions) != 0 {
+				t.Errorf("summary() after rollback lists regions %v", regions)
+			}
+		})
+	}
+}
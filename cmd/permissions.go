@@ -0,0 +1,400 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/option"
+)
+
+// awsMutatingActions are simulated against the caller; any allowed action means write access.
+// The simulation takes no wildcards, so each commonly used service is represented by
+// creating, changing and deleting its main resources.
+var awsMutatingActions = []string{
+	// Compute and containers
+	"ec2:RunInstances",
+	"ec2:TerminateInstances",
+	"ec2:ModifyInstanceAttribute",
+	"ec2:CreateSecurityGroup",
+	"ec2:AuthorizeSecurityGroupIngress",
+	"ec2:CreateVpc",
+	"ec2:DeleteVpc",
+	"ec2:CreateSnapshot",
+	"autoscaling:UpdateAutoScalingGroup",
+	"autoscaling:DeleteAutoScalingGroup",
+	"ecs:CreateService",
+	"ecs:UpdateService",
+	"ecs:DeleteCluster",
+	"eks:CreateCluster",
+	"eks:DeleteCluster",
+	"lambda:CreateFunction",
+	"lambda:UpdateFunctionCode",
+	"lambda:DeleteFunction",
+	// Storage and databases
+	"s3:CreateBucket",
+	"s3:DeleteBucket",
+	"s3:PutObject",
+	"s3:PutBucketPolicy",
+	"rds:CreateDBInstance",
+	"rds:ModifyDBInstance",
+	"rds:DeleteDBInstance",
+	"dynamodb:CreateTable",
+	"dynamodb:DeleteTable",
+	"dynamodb:PutItem",
+	"elasticache:DeleteCacheCluster",
+	// Networking and delivery
+	"elasticloadbalancing:CreateLoadBalancer",
+	"elasticloadbalancing:DeleteLoadBalancer",
+	"route53:ChangeResourceRecordSets",
+	"cloudfront:UpdateDistribution",
+	"apigateway:DELETE",
+	// Messaging and monitoring
+	"sns:CreateTopic",
+	"sns:DeleteTopic",
+	"sqs:DeleteQueue",
+	"logs:DeleteLogGroup",
+	"cloudwatch:PutMetricAlarm",
+	// Identity, secrets and account management
+	"iam:CreateUser",
+	"iam:CreateRole",
+	"iam:AttachRolePolicy",
+	"iam:PutRolePolicy",
+	"iam:CreateAccessKey",
+	"kms:ScheduleKeyDeletion",
+	"kms:PutKeyPolicy",
+	"secretsmanager:PutSecretValue",
+	"ssm:PutParameter",
+	"organizations:CreateAccount",
+	// Deployment
+	"cloudformation:CreateStack",
+	"cloudformation:DeleteStack",
+}
+
+// gcpMutatingPermissions are tested on the project; any granted permission means write access
+var gcpMutatingPermissions = []string{
+	"compute.instances.create",
+	"compute.instances.delete",
+	"compute.firewalls.create",
+	"storage.buckets.create",
+	"storage.buckets.delete",
+	"iam.serviceAccounts.create",
+	"resourcemanager.projects.setIamPolicy",
+	"container.clusters.delete",
+	"cloudsql.instances.delete",
+}
+
+// PermissionAudit is the result of checking what an identity is allowed to change
+type PermissionAudit struct {
+	// Principal is the identity that was audited
+	Principal string
+	// WriteAccess lists the mutating actions, permissions or roles the identity holds
+	WriteAccess []string
+}
+
+// enforceReadOnly audits the credentials and refuses identities that can mutate
+// infrastructure unless allowWrite is set. An audit that cannot run refuses the credentials
+// as well, since they may be able to write; with allowWrite it only warns.
+func (cm *CredentialManager) enforceReadOnly(provider string, creds interface{}, allowWrite bool) error {
+	audit, err := cm.auditPermissions(provider, creds)
+	if err != nil {
+		if allowWrite {
+			log.Printf("⚠️ Warning: could not verify that the credentials are read-only: %v; continuing because of --allow-write", err)
+			return nil
+		}
+		return fmt.Errorf("could not verify that the credentials are read-only: %v; grant the permissions the audit needs or pass --allow-write to skip the check", err)
+	}
+
+	if len(audit.WriteAccess) == 0 {
+		log.Printf("✅ %s has read-only access", audit.Principal)
+		return nil
+	}
+
+	if allowWrite {
+		log.Printf("⚠️ Warning: %s can modify infrastructure (%s); continuing because of --allow-write", audit.Principal, strings.Join(audit.WriteAccess, ", "))
+		return nil
+	}
+	return fmt.Errorf("%s can modify infrastructure (%s); use read-only credentials or pass --allow-write", audit.Principal, strings.Join(audit.WriteAccess, ", "))
+}
+
+// auditPermissions checks which mutating permissions the identity behind the credentials holds
+func (cm *CredentialManager) auditPermissions(provider string, creds interface{}) (*PermissionAudit, error) {
+	ctx := context.Background()
+	switch provider {
+	case "aws":
+		return cm.auditAWSPermissions(ctx, creds.(*AWSCredentials))
+	case "gcp":
+		return cm.auditGCPPermissions(ctx, creds.(*GCPCloudCredentials))
+	case "azure":
+		return cm.auditAzurePermissions(ctx, creds.(*AzureCredentials))
+	default:
+		return nil, fmt.Errorf("unsupported provider: %s", provider)
+	}
+}
+
+// auditAWSPermissions simulates mutating actions against the IAM policies of the caller
+func (cm *CredentialManager) auditAWSPermissions(ctx context.Context, creds *AWSCredentials) (*PermissionAudit, error) {
+	cfg, err := cm.awsConfig(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	stsClient := sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if creds.STSEndpoint != "" {
+			o.BaseEndpoint = aws.String(creds.STSEndpoint)
+		}
+	})
+	identity, err := stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	callerARN := aws.ToString(identity.Arn)
+	audit := &PermissionAudit{Principal: callerARN}
+
+	// The root user is not subject to IAM policies
+	if strings.HasSuffix(callerARN, ":root") {
+		audit.WriteAccess = []string{"root user"}
+		return audit, nil
+	}
+
+	iamClient := iam.NewFromConfig(cfg)
+	policySourceARN, err := awsPolicySourceARN(ctx, iamClient, callerARN)
+	if err != nil {
+		return nil, err
+	}
+
+	paginator := iam.NewSimulatePrincipalPolicyPaginator(iamClient, &iam.SimulatePrincipalPolicyInput{
+		PolicySourceArn: aws.String(policySourceARN),
+		ActionNames:     awsMutatingActions,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to simulate IAM policies: %v", err)
+		}
+		for _, result := range page.EvaluationResults {
+			if result.EvalDecision == iamtypes.PolicyEvaluationDecisionTypeAllowed {
+				audit.WriteAccess = append(audit.WriteAccess, aws.ToString(result.EvalActionName))
+			}
+		}
+	}
+
+	return audit, nil
+}
+
+// awsPolicySourceARN turns the caller ARN into the IAM entity whose policies are simulated.
+// Assumed-role sessions are resolved to their role, including the role path.
+func awsPolicySourceARN(ctx context.Context, client *iam.Client, callerARN string) (string, error) {
+	// arn:aws:sts::<account>:assumed-role/<role-name>/<session-name>
+	_, resource, found := strings.Cut(callerARN, ":assumed-role/")
+	if !found {
+		return callerARN, nil
+	}
+	roleName, _, _ := strings.Cut(resource, "/")
+
+	role, err := client.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(roleName)})
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve role %s: %v", roleName, err)
+	}
+	return aws.ToString(role.Role.Arn), nil
+}
+
+// auditGCPPermissions asks the project which mutating permissions the caller holds
+func (cm *CredentialManager) auditGCPPermissions(ctx context.Context, creds *GCPCloudCredentials) (*PermissionAudit, error) {
+	credJSON, err := cm.gcpCredentialsJSON(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := cloudresourcemanager.NewService(ctx, option.WithCredentialsJSON(credJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Resource Manager client: %v", err)
+	}
+
+	resp, err := service.Projects.TestIamPermissions("projects/"+creds.ProjectID, &cloudresourcemanager.TestIamPermissionsRequest{
+		Permissions: gcpMutatingPermissions,
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to test IAM permissions: %v", err)
+	}
+
+	return &PermissionAudit{
+		Principal:   creds.principal(),
+		WriteAccess: resp.Permissions,
+	}, nil
+}
+
+// azureRoleAssignments is the list response of Microsoft.Authorization/roleAssignments
+type azureRoleAssignments struct {
+	Value []struct {
+		Properties struct {
+			RoleDefinitionID string `json:"roleDefinitionId"`
+			Scope            string `json:"scope"`
+		} `json:"properties"`
+	} `json:"value"`
+}
+
+// azureRoleDefinition is a Microsoft.Authorization/roleDefinitions resource
+type azureRoleDefinition struct {
+	Properties struct {
+		RoleName    string `json:"roleName"`
+		Permissions []struct {
+			Actions    []string `json:"actions"`
+			NotActions []string `json:"notActions"`
+		} `json:"permissions"`
+	} `json:"properties"`
+}
+
+// auditAzurePermissions inspects the roles assigned to the principal on the subscription
+func (cm *CredentialManager) auditAzurePermissions(ctx context.Context, creds *AzureCredentials) (*PermissionAudit, error) {
+	resolved, err := cm.azureAccountCredentials(creds)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := azureTokenCredential(resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{"https://management.azure.com/.default"}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Azure token: %v", err)
+	}
+	objectID, err := azureTokenObjectID(token.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := arm.NewClient("yogaya", "v0.0.1", credential, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure client: %v", err)
+	}
+
+	assignmentsURL := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.Authorization/roleAssignments?api-version=2022-04-01&$filter=%s",
+		client.Endpoint(), creds.SubscriptionID, url.QueryEscape(fmt.Sprintf("assignedTo('%s')", objectID)))
+	var assignments azureRoleAssignments
+	if err := azureGet(ctx, client, assignmentsURL, &assignments); err != nil {
+		return nil, fmt.Errorf("failed to list role assignments: %v", err)
+	}
+
+	audit := &PermissionAudit{Principal: objectID}
+	for _, assignment := range assignments.Value {
+		var definition azureRoleDefinition
+		definitionURL := client.Endpoint() + assignment.Properties.RoleDefinitionID + "?api-version=2022-04-01"
+		if err := azureGet(ctx, client, definitionURL, &definition); err != nil {
+			return nil, fmt.Errorf("failed to read role definition: %v", err)
+		}
+		if azureRoleCanMutate(&definition) {
+			audit.WriteAccess = append(audit.WriteAccess, fmt.Sprintf("%s on %s", definition.Properties.RoleName, assignment.Properties.Scope))
+		}
+	}
+
+	return audit, nil
+}
+
+// azureMutatingOperations are the operation types of Azure actions that change resources
+var azureMutatingOperations = []string{"write", "delete", "action"}
+
+// azureRoleCanMutate reports whether a role grants any write, delete or other action
+// operation that its NotActions do not take away again
+func azureRoleCanMutate(definition *azureRoleDefinition) bool {
+	for _, permission := range definition.Properties.Permissions {
+		for _, action := range permission.Actions {
+			for _, operation := range azureMutatingActions(action) {
+				if !azureActionExcluded(operation, permission.NotActions) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// azureMutatingActions returns the mutating operations an action grants as action patterns:
+// the action itself when it is a write, delete or action operation, and the mutating
+// operations below it when it ends in a wildcard. Read operations grant none.
+func azureMutatingActions(action string) []string {
+	action = strings.ToLower(action)
+	if action == "*" || strings.HasSuffix(action, "/*") {
+		operations := []string{}
+		for _, operation := range azureMutatingOperations {
+			operations = append(operations, action+"/"+operation)
+		}
+		return operations
+	}
+	for _, operation := range azureMutatingOperations {
+		if strings.HasSuffix(action, "/"+operation) {
+			return []string{action}
+		}
+	}
+	return nil
+}
+
+// azureActionExcluded reports whether a NotActions pattern covers every operation the action
+// pattern matches; a wildcard in NotActions matches any text, including other wildcards
+func azureActionExcluded(action string, notActions []string) bool {
+	for _, notAction := range notActions {
+		pattern := "^" + strings.ReplaceAll(regexp.QuoteMeta(strings.ToLower(notAction)), `\*`, ".*") + "$"
+		if regexp.MustCompile(pattern).MatchString(action) {
+			return true
+		}
+	}
+	return false
+}
+
+// azureTokenObjectID returns the object ID (oid claim) of the principal an access token was issued to
+func azureTokenObjectID(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("unexpected Azure access token format")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("failed to decode Azure access token: %v", err)
+	}
+
+	var claims struct {
+		ObjectID string `json:"oid"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("failed to decode Azure access token: %v", err)
+	}
+	if claims.ObjectID == "" {
+		return "", fmt.Errorf("Azure access token has no object ID")
+	}
+	return claims.ObjectID, nil
+}
+
+// azureGet sends a GET request through the ARM pipeline and decodes the JSON response
+func azureGet(ctx context.Context, client *arm.Client, endpoint string, v interface{}) error {
+	req, err := runtime.NewRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Pipeline().Do(req)
+	if err != nil {
+		return err
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return runtime.NewResponseError(resp)
+	}
+	return runtime.UnmarshalAsJSON(resp, v)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAzureRoleCanMutate(t *testing.T) {
+	tests := []struct {
+		name string
+		// definition is the role definition as returned by Microsoft.Authorization/roleDefinitions
+		definition string
+		want       bool
+	}{
+		{
+			name:       "Reader",
+			definition: `{"properties": {"roleName": "Reader", "permissions": [{"actions": ["*/read"], "notActions": []}]}}`,
+			want:       false,
+		},
+		{
+			name: "Contributor",
+			definition: `{"properties": {"roleName": "Contributor", "permissions": [{"actions": ["*"], "notActions": [
+				"Microsoft.Authorization/*/Delete", "Microsoft.Authorization/*/Write", "Microsoft.Authorization/elevateAccess/Action",
+				"Microsoft.Blueprint/blueprintAssignments/write", "Microsoft.Blueprint/blueprintAssignments/delete",
+				"Microsoft.Compute/galleries/share/action", "Microsoft.Purview/consents/write", "Microsoft.Purview/consents/delete"]}]}}`,
+			want: true,
+		},
+		{
+			name:       "Owner",
+			definition: `{"properties": {"roleName": "Owner", "permissions": [{"actions": ["*"], "notActions": []}]}}`,
+			want:       true,
+		},
+		{
+			name:       "Reader and Data Access",
+			definition: `{"properties": {"roleName": "Reader and Data Access", "permissions": [{"actions": ["Microsoft.Storage/storageAccounts/listKeys/action", "Microsoft.Storage/storageAccounts/read"], "notActions": []}]}}`,
+			want:       true,
+		},
+		{
+			name:       "service wildcard",
+			definition: `{"properties": {"roleName": "Virtual Machine Operator", "permissions": [{"actions": ["Microsoft.Compute/*"], "notActions": []}]}}`,
+			want:       true,
+		},
+		{
+			name:       "wildcard taken away by NotActions",
+			definition: `{"properties": {"roleName": "Custom Reader", "permissions": [{"actions": ["*"], "notActions": ["*/write", "*/delete", "*/action"]}]}}`,
+			want:       false,
+		},
+		{
+			name:       "service wildcard taken away by a broader NotAction",
+			definition: `{"properties": {"roleName": "Custom Reader", "permissions": [{"actions": ["*/read", "Microsoft.Compute/*"], "notActions": ["Microsoft.Compute/*"]}]}}`,
+			want:       false,
+		},
+		{
+			name:       "NotActions of another permission block",
+			definition: `{"properties": {"roleName": "Split", "permissions": [{"actions": ["*/read"], "notActions": ["*"]}, {"actions": ["Microsoft.Network/*/write"], "notActions": []}]}}`,
+			want:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var definition azureRoleDefinition
+			if err := json.Unmarshal([]byte(tt.definition), &definition); err != nil {
+				t.Fatal(err)
+			}
+			if got := azureRoleCanMutate(&definition); got != tt.want {
+				t.Errorf("azureRoleCanMutate(%s) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// processWaitDelay is how long a cancelled command may take to close its output after it was killed
+const processWaitDelay = 10 * time.Second
+
+// commandContext returns a command that is killed together with its children when ctx is done.
+// Terraformer starts provider plugins as child processes; they run in the command's own
+// process group so that none of them outlives a cancelled run.
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	startProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	cmd.WaitDelay = processWaitDelay
+	return cmd
+}
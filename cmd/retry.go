@@ -0,0 +1,208 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// maxRetryDelay caps the backoff between two attempts of a step
+const maxRetryDelay = 5 * time.Minute
+
+// StepPolicy is the timeout and retry policy of one step of an import job
+type StepPolicy struct {
+	// Timeout ends an attempt that runs longer; 0 means no timeout
+	Timeout time.Duration
+	// Retries is the number of attempts after the first one
+	Retries int
+	// Delay is the backoff before the first retry; it doubles with every further retry
+	Delay time.Duration
+}
+
+var (
+	initPolicy   = StepPolicy{Timeout: 10 * time.Minute, Retries: 2, Delay: 10 * time.Second}
+	importPolicy = StepPolicy{Timeout: time.Hour, Retries: 3, Delay: 10 * time.Second}
+
+	// retryPatterns are added to retryableOutputPatterns with --retry-pattern
+	retryPatterns []string
+	// compiledRetryPatterns are retryPatterns compiled by compileRetryPatterns
+	compiledRetryPatterns []*regexp.Regexp
+)
+
+// retryableOutputPatterns match the output of terraform and Terraformer when a step failed
+// because of throttling or a transient network failure rather than a problem that a retry
+// cannot fix, such as missing permissions. Status codes and error codes only match where
+// they are reported as such (e.g. "StatusCode: 503", "googleapi: Error 503", "api error
+// InternalError"), not in resource names or IDs that happen to contain them.
+var retryableOutputPatterns = []struct {
+	reason  string
+	pattern *regexp.Regexp
+}{
+	{"throttled", regexp.MustCompile(`(?i)throttl|rate exceeded|ratelimitexceeded|requestlimitexceeded|too many requests|(status ?code:? ?|http(/[0-9.]+)? |error )429\b|slowdown|resource_exhausted|quota exceeded`)},
+	{"service unavailable", regexp.MustCompile(`(?i)(status ?code:? ?|http(/[0-9.]+)? |error )50[234]\b|service unavailable|bad gateway|gateway timeout|(api error |code: ?)internalerror\b|internal server error|\bbackenderror\b|serverbusy`)},
+	{"network failure", regexp.MustCompile(`(?i)connection reset|connection refused|broken pipe|i/o timeout|tls handshake timeout|no such host|temporary failure in name resolution|unexpected eof|network is unreachable|client\.timeout exceeded`)},
+	{"provider download failed", regexp.MustCompile(`(?i)failed to install provider|failed to query available provider packages|could not connect to registry|error while installing`)},
+}
+
+// validate rejects negative timeouts, retries and delays
+func (p StepPolicy) validate(step string) error {
+	if p.Timeout < 0 || p.Retries < 0 || p.Delay < 0 {
+		return fmt.Errorf("the timeout, retries and retry delay of %s cannot be negative", step)
+	}
+	return nil
+}
+
+// compileRetryPatterns compiles the --retry-pattern expressions once, before any step runs
+func compileRetryPatterns() error {
+	compiled := make([]*regexp.Regexp, 0, len(retryPatterns))
+	for _, pattern := range retryPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid --retry-pattern %q: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	compiledRetryPatterns = compiled
+	return nil
+}
+
+// backoff returns the delay before the given retry: exponential, capped and with jitter so
+// that jobs throttled at the same moment do not retry at the same moment
+func (p StepPolicy) backoff(retry int) time.Duration {
+	delay := p.Delay
+	for i := 1; i < retry && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// Equal jitter: half the delay is fixed, the other half random
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryReason returns why a failed attempt may succeed when retried, or "" when it may not
+func retryReason(output []byte, timedOut bool) string {
+	if timedOut {
+		return "timed out"
+	}
+	for _, retryable := range retryableOutputPatterns {
+		if retryable.pattern.Match(output) {
+			return retryable.reason
+		}
+	}
+	for _, pattern := range compiledRetryPatterns {
+		if pattern.Match(output) {
+			return "matched --retry-pattern " + pattern.String()
+		}
+	}
+	return ""
+}
+
+// StepAttempt is one attempt of a step, as recorded in the run log
+type StepAttempt struct {
+	Step     string
+	Attempt  int
+	Duration time.Duration
+	// Reason is why the attempt was retried; empty for the last attempt of a step
+	Reason string
+	Err    error
+}
+
+// stepLog collects the attempts of the steps of one account
+type stepLog struct {
+	mu       sync.Mutex
+	attempts []StepAttempt
+}
+
+type stepLogKey struct{}
+
+// withStepLog returns a context whose steps are recorded in steps
+func withStepLog(ctx context.Context, steps *stepLog) context.Context {
+	return context.WithValue(ctx, stepLogKey{}, steps)
+}
+
+// recordAttempt adds an attempt to the step log of ctx, if it has one
+func recordAttempt(ctx context.Context, attempt StepAttempt) {
+	steps, ok := ctx.Value(stepLogKey{}).(*stepLog)
+	if !ok {
+		return
+	}
+	steps.mu.Lock()
+	steps.attempts = append(steps.attempts, attempt)
+	steps.mu.Unlock()
+}
+
+// retried returns the attempts that were retried
+func (l *stepLog) retried() []StepAttempt {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	retried := []StepAttempt{}
+	for _, attempt := range l.attempts {
+		if attempt.Reason != "" {
+			retried = append(retried, attempt)
+		}
+	}
+	return retried
+}
+
+// runStep runs a command under a step policy: each attempt ends after the policy's timeout,
+// and attempts that failed because of throttling, a transient network failure or a timeout
+// are retried with backoff. newCmd is called for every attempt, since a command can only be
+// started once. It returns the combined output of the last attempt.
+func runStep(ctx context.Context, policy StepPolicy, step string, newCmd func(ctx context.Context) (*exec.Cmd, error)) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if policy.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, policy.Timeout)
+		}
+
+		started := time.Now()
+		cmd, err := newCmd(attemptCtx)
+		var output []byte
+		if err == nil {
+			output, err = cmd.CombinedOutput()
+		}
+		timedOut := errors.Is(attemptCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+		cancel()
+
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("✅ %s succeeded on attempt %d", step, attempt)
+			}
+			debugf("%s took %s", step, time.Since(started).Round(time.Second))
+			recordAttempt(ctx, StepAttempt{Step: step, Attempt: attempt, Duration: time.Since(started)})
+			return output, nil
+		}
+		if timedOut {
+			err = fmt.Errorf("timed out after %s: %v", policy.Timeout, err)
+		}
+
+		reason := retryReason(output, timedOut)
+		if ctx.Err() != nil || reason == "" || attempt > policy.Retries {
+			recordAttempt(ctx, StepAttempt{Step: step, Attempt: attempt, Duration: time.Since(started), Err: err})
+			return output, err
+		}
+		recordAttempt(ctx, StepAttempt{Step: step, Attempt: attempt, Duration: time.Since(started), Reason: reason, Err: err})
+
+		delay := policy.backoff(attempt)
+		log.Printf("⚠️ %s failed on attempt %d/%d (%s); retrying in %s", step, attempt, policy.Retries+1, reason, delay.Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return output, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		timedOut bool
+		patterns []string
+		want     string
+	}{
+		{name: "timeout", output: "anything", timedOut: true, want: "timed out"},
+		{name: "aws throttling", output: "operation error EC2: DescribeVpcs, https response error StatusCode: 400, api error Throttling: Rate exceeded", want: "throttled"},
+		{name: "aws request limit", output: "RequestLimitExceeded: Request limit exceeded.", want: "throttled"},
+		{name: "status 429", output: "StatusCode: 429, too busy", want: "throttled"},
+		{name: "google 429", output: "googleapi: Error 429: Quota exceeded for quota metric", want: "throttled"},
+		{name: "google quota", output: "rpc error: code = ResourceExhausted desc = RESOURCE_EXHAUSTED", want: "throttled"},
+		{name: "azure too many requests", output: "Status=429 Code=\"TooManyRequests\" Message=\"Too many requests\"", want: "throttled"},
+		{name: "s3 slow down", output: "api error SlowDown: Please reduce your request rate.", want: "throttled"},
+		{name: "status 503", output: "https response error StatusCode: 503, RequestID: abc", want: "service unavailable"},
+		{name: "google 502", output: "googleapi: Error 502: Bad Gateway", want: "service unavailable"},
+		{name: "http 504", output: "HTTP/1.1 504 Gateway Timeout", want: "service unavailable"},
+		{name: "aws internal error", output: "api error InternalError: We encountered an internal error", want: "service unavailable"},
+		{name: "google backend error", output: "googleapi: Error 500: backendError", want: "service unavailable"},
+		{name: "azure server busy", output: "Code=\"ServerBusy\"", want: "service unavailable"},
+		{name: "connection reset", output: "read tcp 10.0.0.1:443: read: connection reset by peer", want: "network failure"},
+		{name: "dns failure", output: "dial tcp: lookup ec2.eu-west-1.amazonaws.com: no such host", want: "network failure"},
+		{name: "client timeout", output: "(Client.Timeout exceeded while awaiting headers)", want: "network failure"},
+		{name: "provider download", output: "Error: Failed to install provider", want: "provider download failed"},
+		{name: "access denied", output: "api error UnauthorizedOperation: You are not authorized to perform this operation", want: ""},
+		{name: "status code in a resource ID", output: "Error: importing vpc-0503abc failed: invalid configuration", want: ""},
+		{name: "status code in a resource count", output: "subnet 502 created, 429 instances imported", want: ""},
+		{name: "error code inside another word", output: "panic in MyInternalErrorHandler", want: ""},
+		{name: "empty output", output: "", want: ""},
+		{
+			name:     "custom pattern",
+			output:   "Error: ResourceInUse: the table is being updated",
+			patterns: []string{`ResourceInUse`},
+			want:     "matched --retry-pattern ResourceInUse",
+		},
+		{
+			name:     "built-in reason before custom pattern",
+			output:   "StatusCode: 503 ResourceInUse",
+			patterns: []string{`ResourceInUse`},
+			want:     "service unavailable",
+		},
+		{
+			name:     "custom pattern not matching",
+			output:   "api error AccessDenied",
+			patterns: []string{`^ResourceInUse`},
+			want:     "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := retryPatterns
+			defer func() {
+				retryPatterns = previous
+				compileRetryPatterns()
+			}()
+			retryPatterns = tt.patterns
+			if err := compileRetryPatterns(); err != nil {
+				t.Fatalf("compileRetryPatterns() error = %v", err)
+			}
+
+			if got := retryReason([]byte(tt.output), tt.timedOut); got != tt.want {
+				t.Errorf("retryReason(%q) = %q, want %q", tt.output, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileRetryPatterns(t *testing.T) {
+	previous := retryPatterns
+	defer func() {
+		retryPatterns = previous
+		compileRetryPatterns()
+	}()
+
+	retryPatterns = []string{`ok`, `unclosed(`}
+	err := compileRetryPatterns()
+	if err == nil || !strings.Contains(err.Error(), `invalid --retry-pattern "unclosed("`) {
+		t.Errorf("compileRetryPatterns() error = %v, want an invalid --retry-pattern error", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name  string
+		delay time.Duration
+		retry int
+		// the delay before jitter; backoff returns between half of it and all of it
+		want time.Duration
+	}{
+		{name: "first retry", delay: 10 * time.Second, retry: 1, want: 10 * time.Second},
+		{name: "second retry doubles", delay: 10 * time.Second, retry: 2, want: 20 * time.Second},
+		{name: "fourth retry", delay: 10 * time.Second, retry: 4, want: 80 * time.Second},
+		{name: "capped", delay: 10 * time.Second, retry: 10, want: maxRetryDelay},
+		{name: "many retries do not overflow", delay: 10 * time.Second, retry: 1000, want: maxRetryDelay},
+		{name: "delay above the cap", delay: time.Hour, retry: 1, want: maxRetryDelay},
+		{name: "no delay", delay: 0, retry: 3, want: 0},
+		{name: "one nanosecond", delay: time.Nanosecond, retry: 1, want: time.Nanosecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := StepPolicy{Delay: tt.delay}
+			for i := 0; i < 200; i++ {
+				got := policy.backoff(tt.retry)
+				if got < tt.want/2 || got > tt.want {
+					t.Fatalf("backoff(%d) with delay %s = %s, want between %s and %s", tt.retry, tt.delay, got, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStepPolicyValidate(t *testing.T) {
+	tests := []struct {
+		policy  StepPolicy
+		wantErr bool
+	}{
+		{policy: StepPolicy{}},
+		{policy: StepPolicy{Timeout: time.Minute, Retries: 3, Delay: time.Second}},
+		{policy: StepPolicy{Timeout: -time.Second}, wantErr: true},
+		{policy: StepPolicy{Retries: -1}, wantErr: true},
+		{policy: StepPolicy{Delay: -time.Second}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if err := tt.policy.validate("terraform init"); (err != nil) != tt.wantErr {
+			t.Errorf("validate() of %+v error = %v, want error %v", tt.policy, err, tt.wantErr)
+		}
+	}
+}
@@ -4,7 +4,11 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
+	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
@@ -17,12 +21,36 @@ var rootCmd = &cobra.Command{
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return setupLogging(globalOptions.LogLevel, globalOptions.NoColor)
+	},
 }
 
+// GlobalOptions holds the flags shared by every command
+type GlobalOptions struct {
+	Workspace  string
+	ConfigPath string
+	OutputDir  string
+	LogLevel   string
+	NoColor    bool
+	NoCommit   bool
+}
+
+var globalOptions GlobalOptions
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// The context of every command is cancelled by SIGINT or SIGTERM; a second signal
+// terminates immediately.
 func Execute() {
-	err := rootCmd.Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
 		os.Exit(1)
 	}
@@ -32,4 +60,44 @@ func init() {
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	// rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	rootCmd.PersistentFlags().StringVar(&globalOptions.Workspace, "workspace", "", "path to the .yogaya directory, whose active workspace is used (default $YOGAYA_HOME, the nearest .yogaya/ or ~/.yogaya)")
+	rootCmd.PersistentFlags().StringVar(&globalOptions.ConfigPath, "config", "", "path to .yogaya/cloud_accounts.conf (replaces the positional argument)")
+	rootCmd.PersistentFlags().StringVar(&globalOptions.OutputDir, "output-dir", "", "directory the generated Terraform code is written to, overriding the root set with config output; it must be inside the workspace unless --no-commit is set (default <workspace>/generated)")
+	rootCmd.PersistentFlags().StringVar(&globalOptions.LogLevel, "log-level", "info", "minimum level of log messages (debug|info|warn|error)")
+	rootCmd.PersistentFlags().BoolVar(&globalOptions.NoCommit, "no-commit", false, "do not record changes in the workspace's Git repository")
+	rootCmd.PersistentFlags().BoolVar(&globalOptions.NoColor, "no-color", os.Getenv("NO_COLOR") != "", "log [OK], [WARN] and [ERROR] instead of emoji and strip colors from the Terraform output in the log")
+}
+
+// commandArgs returns the arguments of a command in its positional order.
+// Each name is taken from the flag of the same name when it is set and otherwise
+// from the next positional argument, so `add aws conf creds` and
+// `add aws creds --config conf` are equivalent. Positional arguments left over
+// are appended. Filling stops at the first name that has neither.
+// Without --config or a positional cloud_accounts.conf path, "config" is taken from the workspace.
+func commandArgs(cmd *cobra.Command, args []string, names ...string) []string {
+	resolved := []string{}
+	for _, name := range names {
+		if flag := cmd.Flags().Lookup(name); flag != nil && flag.Changed {
+			resolved = append(resolved, flag.Value.String())
+			continue
+		}
+		if name == "config" {
+			configPath, positional, err := configPathArg(args)
+			if err != nil {
+				log.Printf("❌ %v", err)
+				return resolved
+			}
+			resolved = append(resolved, configPath)
+			if positional {
+				args = args[1:]
+			}
+			continue
+		}
+		if len(args) == 0 {
+			return resolved
+		}
+		resolved = append(resolved, args[0])
+		args = args[1:]
+	}
+	return append(resolved, args...)
 }
@@ -0,0 +1,197 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// defaultConcurrency is the number of Terraformer jobs run at once when --concurrency is not set
+const defaultConcurrency = 7
+
+// SchedulerLimits bounds how many Terraformer jobs run at once
+type SchedulerLimits struct {
+	// Global is the number of jobs running at once across all accounts
+	Global int
+	// Providers caps the running jobs of a provider below Global, e.g. to stay under API rate limits
+	Providers map[string]int
+}
+
+var schedulerLimits = SchedulerLimits{Global: defaultConcurrency}
+
+// validate rejects limits below one and unknown providers
+func (l SchedulerLimits) validate() error {
+	if l.Global < 1 {
+		return fmt.Errorf("--concurrency must be at least 1, got %d", l.Global)
+	}
+	for provider, limit := range l.Providers {
+		switch provider {
+		case "aws", "gcp", "azure":
+		default:
+			return fmt.Errorf("unsupported provider in --provider-concurrency: %s", provider)
+		}
+		if limit < 1 {
+			return fmt.Errorf("--provider-concurrency %s must be at least 1, got %d", provider, limit)
+		}
+	}
+	return nil
+}
+
+// GenerateJob is one Terraformer import of an account: a region, or a service of providers
+// that are imported per service
+type GenerateJob struct {
+	Region  string
+	Service string
+	Run     func() error
+}
+
+// scheduledJob is a queued job with the provider it counts against
+type scheduledJob struct {
+	job      GenerateJob
+	provider string
+	done     func(error)
+}
+
+// Scheduler runs the jobs of every account of a generate run on one pool of workers.
+// Accounts take turns: a free worker takes the next job of the account after the one it
+// served last, skipping accounts whose provider is at its limit, so a large account does
+// not hold up the small ones queued after it. Once ctx is done, queued jobs are dropped
+// with its error instead of being started.
+type Scheduler struct {
+	ctx    context.Context
+	limits SchedulerLimits
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queues  map[string][]scheduledJob // pending jobs by account
+	order   []string                  // accounts in the order they queued their first job
+	next    int                       // position in order of the account served next
+	running map[string]int            // running jobs by provider
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// NewScheduler starts the workers of a scheduler
+func NewScheduler(ctx context.Context, limits SchedulerLimits) *Scheduler {
+	s := &Scheduler{
+		ctx:     ctx,
+		limits:  limits,
+		queues:  map[string][]scheduledJob{},
+		running: map[string]int{},
+	}
+	s.cond = sync.NewCond(&s.mu)
+	for i := 0; i < limits.Global; i++ {
+		s.workers.Add(1)
+		go s.work()
+	}
+	return s
+}
+
+// Run queues the jobs of an account and waits until all of them have finished.
+// It returns the errors of the jobs that failed.
+func (s *Scheduler) Run(account CloudAccount, jobs []GenerateJob) []error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errors := []error{}
+
+	key := account.Provider + "/" + account.ID
+	s.mu.Lock()
+	if _, queued := s.queues[key]; !queued {
+		s.order = append(s.order, key)
+	}
+	for _, job := range jobs {
+		wg.Add(1)
+		s.queues[key] = append(s.queues[key], scheduledJob{
+			job:      job,
+			provider: account.Provider,
+			done: func(err error) {
+				if err != nil {
+					mu.Lock()
+					errors = append(errors, err)
+					mu.Unlock()
+				}
+				wg.Done()
+			},
+		})
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	wg.Wait()
+	return errors
+}
+
+// Close stops the workers once every queued job has finished
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.workers.Wait()
+}
+
+// work runs queued jobs until the scheduler is closed
+func (s *Scheduler) work() {
+	defer s.workers.Done()
+	for {
+		s.mu.Lock()
+		next, ok := s.take()
+		for !ok {
+			if s.closed && s.pending() == 0 {
+				s.mu.Unlock()
+				return
+			}
+			s.cond.Wait()
+			next, ok = s.take()
+		}
+		if err := s.ctx.Err(); err != nil {
+			s.mu.Unlock()
+			next.done(err)
+			continue
+		}
+		s.running[next.provider]++
+		s.mu.Unlock()
+
+		err := next.job.Run()
+
+		s.mu.Lock()
+		s.running[next.provider]--
+		// A slot of this provider is free again
+		s.cond.Broadcast()
+		s.mu.Unlock()
+		next.done(err)
+	}
+}
+
+// take removes the next job to run from its queue. It must be called with s.mu held.
+func (s *Scheduler) take() (scheduledJob, bool) {
+	for i := 0; i < len(s.order); i++ {
+		index := (s.next + i) % len(s.order)
+		queue := s.queues[s.order[index]]
+		if len(queue) == 0 {
+			continue
+		}
+		provider := queue[0].provider
+		if limit, limited := s.limits.Providers[provider]; limited && s.running[provider] >= limit {
+			continue
+		}
+
+		s.queues[s.order[index]] = queue[1:]
+		// Not wrapped here: accounts queued later are appended after index and come next
+		s.next = index + 1
+		return queue[0], true
+	}
+	return scheduledJob{}, false
+}
+
+// pending returns the number of queued jobs. It must be called with s.mu held.
+func (s *Scheduler) pending() int {
+	count := 0
+	for _, queue := range s.queues {
+		count += len(queue)
+	}
+	return count
+}
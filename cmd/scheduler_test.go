@@ -0,0 +1,266 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitPending waits until the scheduler has n queued jobs
+func waitPending(t *testing.T, s *Scheduler, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		pending := s.pending()
+		s.mu.Unlock()
+		if pending == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scheduler has %d queued jobs, want %d", pending, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// concurrency tracks how many jobs run at once
+type concurrency struct {
+	mu      sync.Mutex
+	running map[string]int
+	max     map[string]int
+}
+
+func newConcurrency() *concurrency {
+	return &concurrency{running: map[string]int{}, max: map[string]int{}}
+}
+
+// job returns a job that counts against key and "" while it waits for release
+func (c *concurrency) job(key string, release func()) GenerateJob {
+	return GenerateJob{Run: func() error {
+		c.mu.Lock()
+		for _, k := range []string{key, ""} {
+			c.running[k]++
+			if c.running[k] > c.max[k] {
+				c.max[k] = c.running[k]
+			}
+		}
+		c.mu.Unlock()
+
+		release()
+
+		c.mu.Lock()
+		c.running[key]--
+		c.running[""]--
+		c.mu.Unlock()
+		return nil
+	}}
+}
+
+// barrier returns a release function that returns once n jobs are waiting in it,
+// or after a timeout, which is reported as a failure
+func barrier(t *testing.T, n int) func() {
+	var arrived sync.WaitGroup
+	arrived.Add(n)
+	return func() {
+		arrived.Done()
+		done := make(chan struct{})
+		go func() {
+			arrived.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("only some of %d jobs ran at the same time", n)
+		}
+	}
+}
+
+func TestSchedulerTakesTurnsBetweenAccounts(t *testing.T) {
+	s := NewScheduler(context.Background(), SchedulerLimits{Global: 1})
+	defer s.Close()
+
+	var mu sync.Mutex
+	order := []string{}
+	started := make(chan struct{})
+	gate := make(chan struct{})
+
+	jobs := func(account string, n int) []GenerateJob {
+		jobs := []GenerateJob{}
+		for i := 1; i <= n; i++ {
+			name := fmt.Sprintf("%s%d", account, i)
+			jobs = append(jobs, GenerateJob{Run: func() error {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				if name == "a1" {
+					close(started)
+					<-gate
+				}
+				return nil
+			}})
+		}
+		return jobs
+	}
+
+	var wg sync.WaitGroup
+	run := func(account string, n int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Run(CloudAccount{ID: account, Provider: "aws"}, jobs(account, n))
+		}()
+	}
+
+	// The large account starts first; the others queue while its first job runs
+	run("a", 3)
+	<-started
+	run("b", 2)
+	waitPending(t, s, 4)
+	run("c", 1)
+	waitPending(t, s, 5)
+	close(gate)
+	wg.Wait()
+
+	want := []string{"a1", "b1", "c1", "a2", "b2", "a3"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("jobs ran in order %v, want %v", order, want)
+	}
+}
+
+func TestSchedulerLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits SchedulerLimits
+		// jobs per provider; each provider has one account
+		jobs map[string]int
+		// together is the number of jobs of each provider that must be able to run at once
+		together map[string]int
+		wantMax  map[string]int
+	}{
+		{
+			name:     "global limit",
+			limits:   SchedulerLimits{Global: 2},
+			jobs:     map[string]int{"aws": 6},
+			together: map[string]int{"aws": 2},
+			wantMax:  map[string]int{"aws": 2, "": 2},
+		},
+		{
+			name:     "provider limit leaves the other workers to other providers",
+			limits:   SchedulerLimits{Global: 4, Providers: map[string]int{"aws": 1}},
+			jobs:     map[string]int{"aws": 4, "gcp": 3},
+			together: map[string]int{"gcp": 3},
+			wantMax:  map[string]int{"aws": 1, "gcp": 3, "": 4},
+		},
+		{
+			name:     "provider limit above the global limit",
+			limits:   SchedulerLimits{Global: 2, Providers: map[string]int{"azure": 5}},
+			jobs:     map[string]int{"azure": 4},
+			together: map[string]int{"azure": 2},
+			wantMax:  map[string]int{"azure": 2, "": 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScheduler(context.Background(), tt.limits)
+			defer s.Close()
+
+			c := newConcurrency()
+			var wg sync.WaitGroup
+			for provider, n := range tt.jobs {
+				release := func() { time.Sleep(5 * time.Millisecond) }
+				if together := tt.together[provider]; together > 0 {
+					// Jobs beyond the first round do not wait, so a barrier for one round suffices
+					wait := barrier(t, together)
+					var calls sync.Mutex
+					count := 0
+					release = func() {
+						calls.Lock()
+						count++
+						first := count <= together
+						calls.Unlock()
+						if first {
+							wait()
+						}
+					}
+				}
+
+				jobs := []GenerateJob{}
+				for i := 0; i < n; i++ {
+					jobs = append(jobs, c.job(provider, release))
+				}
+				wg.Add(1)
+				go func(provider string) {
+					defer wg.Done()
+					if errs := s.Run(CloudAccount{ID: provider + "-1", Provider: provider}, jobs); len(errs) > 0 {
+						t.Errorf("Run() errors = %v", errs)
+					}
+				}(provider)
+			}
+			wg.Wait()
+
+			for key, want := range tt.wantMax {
+				if got := c.max[key]; got > want {
+					t.Errorf("%q ran %d jobs at once, want at most %d", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSchedulerReportsErrorsAndDropsJobsOnceCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewScheduler(ctx, SchedulerLimits{Global: 1})
+	defer s.Close()
+
+	failed := errors.New("import failed")
+	ran := 0
+	jobs := []GenerateJob{
+		{Run: func() error { ran++; return failed }},
+		{Run: func() error { ran++; cancel(); return nil }},
+		{Run: func() error { ran++; return nil }},
+		{Run: func() error { ran++; return nil }},
+	}
+
+	errs := s.Run(CloudAccount{ID: "a1", Provider: "aws"}, jobs)
+	if ran != 2 {
+		t.Errorf("%d jobs ran, want the 2 queued before cancelling", ran)
+	}
+	want := []error{failed, context.Canceled, context.Canceled}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("Run() errors = %v, want %v", errs, want)
+	}
+}
+
+func TestSchedulerLimitsValidate(t *testing.T) {
+	tests := []struct {
+		limits  SchedulerLimits
+		wantErr string
+	}{
+		{limits: SchedulerLimits{Global: 1}},
+		{limits: SchedulerLimits{Global: 7, Providers: map[string]int{"aws": 2, "gcp": 1, "azure": 10}}},
+		{limits: SchedulerLimits{Global: 0}, wantErr: "--concurrency must be at least 1"},
+		{limits: SchedulerLimits{Global: 2, Providers: map[string]int{"oci": 1}}, wantErr: "unsupported provider in --provider-concurrency: oci"},
+		{limits: SchedulerLimits{Global: 2, Providers: map[string]int{"aws": 0}}, wantErr: "--provider-concurrency aws must be at least 1"},
+	}
+
+	for _, tt := range tests {
+		err := tt.limits.validate()
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("validate() of %+v error = %v", tt.limits, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("validate() of %+v error = %v, want it to contain %q", tt.limits, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,534 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	workspaceDirName  = ".yogaya"
+	workspacesDirName = "workspaces"
+	cloudAccountsFile = "cloud_accounts.conf"
+	tenantConfFile    = "tenant.conf"
+	workspaceHomeEnv  = "YOGAYA_HOME"
+
+	// defaultWorkspaceName is the workspace kept directly in the .yogaya directory
+	defaultWorkspaceName = "default"
+)
+
+// Workspace holds the cloud_accounts.conf and tenant.conf of one tenant. The default
+// workspace is the .yogaya directory itself; named workspaces live in .yogaya/workspaces/<name>.
+type Workspace struct {
+	// Root is the .yogaya directory
+	Root string
+	Name string
+	Dir  string
+}
+
+// TenantConfig is the content of tenant.conf. Every workspace has its own tenant key;
+// the active workspace is only recorded in the tenant.conf of the .yogaya directory.
+type TenantConfig struct {
+	TenantKey       string
+	ActiveWorkspace string
+}
+
+// NewWorkspace returns the active workspace of the .yogaya directory at path, which may
+// be the .yogaya directory itself or the directory that contains it
+func NewWorkspace(path string) (*Workspace, error) {
+	return openWorkspace(workspaceRoot(path))
+}
+
+// workspaceRoot returns the .yogaya directory for a path given by the user
+func workspaceRoot(path string) string {
+	if filepath.Base(path) != workspaceDirName && isDir(filepath.Join(path, workspaceDirName)) {
+		return filepath.Join(path, workspaceDirName)
+	}
+	return path
+}
+
+// openWorkspace returns the workspace recorded as active in the tenant.conf of root
+func openWorkspace(root string) (*Workspace, error) {
+	tenant, err := readTenantConf(filepath.Join(root, tenantConfFile))
+	if err != nil {
+		return nil, err
+	}
+	return namedWorkspace(root, tenant.ActiveWorkspace)
+}
+
+// namedWorkspace returns the workspace called name in root and checks that it exists
+func namedWorkspace(root, name string) (*Workspace, error) {
+	if name == "" || name == defaultWorkspaceName {
+		return &Workspace{Root: root, Name: defaultWorkspaceName, Dir: root}, nil
+	}
+	workspace := &Workspace{Root: root, Name: name, Dir: filepath.Join(root, workspacesDirName, name)}
+	if !isDir(workspace.Dir) {
+		return nil, fmt.Errorf("workspace %s does not exist in %s; run `yogaya workspace create %s` or `yogaya workspace switch %s`", name, root, name, defaultWorkspaceName)
+	}
+	return workspace, nil
+}
+
+// workspaceOfConfig returns the workspace that holds a cloud_accounts.conf path
+func workspaceOfConfig(configPath string) *Workspace {
+	dir := filepath.Dir(configPath)
+	if filepath.Base(filepath.Dir(dir)) == workspacesDirName {
+		return &Workspace{Root: filepath.Dir(filepath.Dir(dir)), Name: filepath.Base(dir), Dir: dir}
+	}
+	return &Workspace{Root: dir, Name: defaultWorkspaceName, Dir: dir}
+}
+
+// ResolveWorkspace finds the workspace to use. The .yogaya directory is the explicit path
+// (--workspace), then $YOGAYA_HOME, then the nearest .yogaya/ in the current directory or
+// its parents, then ~/.yogaya. Its active workspace is returned.
+func ResolveWorkspace(explicit string) (*Workspace, error) {
+	root, err := resolveWorkspaceRoot(explicit)
+	if err != nil {
+		return nil, err
+	}
+	return openWorkspace(root)
+}
+
+// resolveWorkspaceRoot finds the .yogaya directory in the order described at ResolveWorkspace
+func resolveWorkspaceRoot(explicit string) (string, error) {
+	if explicit != "" {
+		return workspaceRoot(explicit), nil
+	}
+
+	if home := os.Getenv(workspaceHomeEnv); home != "" {
+		return workspaceRoot(home), nil
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for dir := cwd; ; dir = filepath.Dir(dir) {
+		if isDir(filepath.Join(dir, workspaceDirName)) {
+			return filepath.Join(dir, workspaceDirName), nil
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err == nil && isDir(filepath.Join(homeDir, workspaceDirName)) {
+		return filepath.Join(homeDir, workspaceDirName), nil
+	}
+
+	return "", fmt.Errorf("no %s workspace found in %s or its parents; run `yogaya init`, pass --workspace or set $%s", workspaceDirName, cwd, workspaceHomeEnv)
+}
+
+// CloudAccountsPath returns the path of cloud_accounts.conf
+func (w *Workspace) CloudAccountsPath() string {
+	return filepath.Join(w.Dir, cloudAccountsFile)
+}
+
+// TenantConfPath returns the path of tenant.conf
+func (w *Workspace) TenantConfPath() string {
+	return filepath.Join(w.Dir, tenantConfFile)
+}
+
+// OutputDir returns the directory generated code of the workspace is written to by default
+func (w *Workspace) OutputDir() string {
+	return filepath.Join(w.Dir, defaultOutputDir)
+}
+
+// readTenantConf reads the key=value lines of tenant.conf. A missing file yields an empty config.
+func readTenantConf(path string) (*TenantConfig, error) {
+	tenant := &TenantConfig{}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return tenant, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !found {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "tenant_key":
+			tenant.TenantKey = strings.TrimSpace(value)
+		case "active_workspace":
+			tenant.ActiveWorkspace = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return tenant, nil
+}
+
+// writeTenantConf writes tenant.conf
+func writeTenantConf(path string, tenant *TenantConfig) error {
+	content := fmt.Sprintf("tenant_key=%s\n", tenant.TenantKey)
+	if tenant.ActiveWorkspace != "" {
+		content += fmt.Sprintf("active_workspace=%s\n", tenant.ActiveWorkspace)
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// newTenantKey returns a random key identifying a workspace
+func newTenantKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate tenant key: %v", err)
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// configPathArg returns the cloud_accounts.conf path for a command. A positional
+// argument naming a cloud_accounts.conf file is used as is for backward compatibility;
+// other files, such as provider credentials ending in .conf, are left to the command.
+// Otherwise the path comes from the resolved workspace.
+func configPathArg(args []string) (string, bool, error) {
+	if len(args) > 0 && filepath.Base(args[0]) == cloudAccountsFile {
+		return args[0], true, nil
+	}
+
+	workspace, err := ResolveWorkspace(globalOptions.Workspace)
+	if err != nil {
+		return "", false, err
+	}
+	return workspace.CloudAccountsPath(), false, nil
+}
+
+// isDir reports whether path exists and is a directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// workspaceNamePattern restricts workspace names to characters that are safe in paths
+var workspaceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// workspaceCmd represents the workspace command
+var workspaceCmd = &cobra.Command{
+	Use:   "workspace",
+	Short: "Manage the workspaces of the .yogaya directory, one per tenant",
+}
+
+// workspaceCreateCmd represents the workspace create command
+var workspaceCreateCmd = &cobra.Command{
+	Use:   "create [workspace-name]",
+	Short: "Create a workspace with its own accounts, output and history",
+	Run:   workspaceCreateCommand,
+}
+
+// workspaceListCmd represents the workspace list command
+var workspaceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List workspaces and mark the active one",
+	Run:   workspaceListCommand,
+}
+
+// workspaceSwitchCmd represents the workspace switch command
+var workspaceSwitchCmd = &cobra.Command{
+	Use:   "switch [workspace-name]",
+	Short: "Make a workspace the one add, generate and accounts operate on",
+	Run:   workspaceSwitchCommand,
+}
+
+// workspaceDeleteCmd represents the workspace delete command
+var workspaceDeleteCmd = &cobra.Command{
+	Use:   "delete [workspace-name]",
+	Short: "Delete a workspace with its accounts and generated code",
+	Run:   workspaceDeleteCommand,
+}
+
+var (
+	workspaceCreateSwitch bool
+	workspaceDeleteForce  bool
+)
+
+func init() {
+	for _, c := range []*cobra.Command{workspaceCreateCmd, workspaceSwitchCmd, workspaceDeleteCmd} {
+		c.Flags().String("name", "", "name of the workspace")
+	}
+	workspaceCreateCmd.Flags().BoolVar(&workspaceCreateSwitch, "switch", false, "make the new workspace active")
+	workspaceDeleteCmd.Flags().BoolVar(&workspaceDeleteForce, "force", false, "delete the workspace even if it has accounts")
+
+	workspaceCmd.AddCommand(workspaceCreateCmd)
+	workspaceCmd.AddCommand(workspaceListCmd)
+	workspaceCmd.AddCommand(workspaceSwitchCmd)
+	workspaceCmd.AddCommand(workspaceDeleteCmd)
+	rootCmd.AddCommand(workspaceCmd)
+}
+
+// CreateWorkspace creates a named workspace in the .yogaya directory root
+func CreateWorkspace(root, name string) (*Workspace, error) {
+	if !workspaceNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid workspace name %q: use lowercase letters, digits, - and _", name)
+	}
+	if name == defaultWorkspaceName {
+		return nil, fmt.Errorf("workspace %s already exists", name)
+	}
+	if !isDir(root) {
+		return nil, fmt.Errorf("%s does not exist; run `yogaya init` first", root)
+	}
+
+	workspace := &Workspace{Root: root, Name: name, Dir: filepath.Join(root, workspacesDirName, name)}
+	if isDir(workspace.Dir) {
+		return nil, fmt.Errorf("workspace %s already exists", name)
+	}
+	if err := createWorkspaceFiles(workspace, ""); err != nil {
+		return nil, err
+	}
+
+	// Each workspace keeps its own history, so the root repository ignores them
+	if err := ignoreInGit(root, workspacesDirName+"/"); err != nil {
+		return nil, err
+	}
+	return workspace, nil
+}
+
+// ListWorkspaces returns the default workspace followed by the named workspaces of root
+func ListWorkspaces(root string) ([]*Workspace, error) {
+	workspaces := []*Workspace{{Root: root, Name: defaultWorkspaceName, Dir: root}}
+
+	entries, err := os.ReadDir(filepath.Join(root, workspacesDirName))
+	if os.IsNotExist(err) {
+		return workspaces, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			workspaces = append(workspaces, &Workspace{Root: root, Name: entry.Name(), Dir: filepath.Join(root, workspacesDirName, entry.Name())})
+		}
+	}
+	return workspaces, nil
+}
+
+// SwitchWorkspace records name as the active workspace of root
+func SwitchWorkspace(root, name string) (*Workspace, error) {
+	workspace, err := namedWorkspace(root, name)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantConf := filepath.Join(root, tenantConfFile)
+	tenant, err := readTenantConf(tenantConf)
+	if err != nil {
+		return nil, err
+	}
+	tenant.ActiveWorkspace = workspace.Name
+	if err := writeTenantConf(tenantConf, tenant); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %v", tenantConfFile, err)
+	}
+	return workspace, nil
+}
+
+// DeleteWorkspace removes a named workspace. The credentials its accounts keep in
+// external stores are deleted as well. A workspace with accounts is only deleted with force.
+func DeleteWorkspace(root, name string, force bool) error {
+	if name == defaultWorkspaceName {
+		return fmt.Errorf("the %s workspace cannot be deleted", defaultWorkspaceName)
+	}
+	workspace, err := namedWorkspace(root, name)
+	if err != nil {
+		return err
+	}
+	tenant, err := readTenantConf(filepath.Join(root, tenantConfFile))
+	if err != nil {
+		return err
+	}
+	if tenant.ActiveWorkspace == name {
+		return fmt.Errorf("workspace %s is active; switch to another workspace first", name)
+	}
+
+	count, err := countWorkspaceAccounts(workspace)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		if !force {
+			return fmt.Errorf("workspace %s has %d accounts; pass --force to delete it", name, count)
+		}
+		cm, err := NewCredentialManager(workspace.CloudAccountsPath())
+		if err != nil {
+			return err
+		}
+		// Every account goes, so accounts using the credentials of another need no check
+		accountIDs := []string{}
+		for _, account := range cm.config.Accounts {
+			accountIDs = append(accountIDs, account.ID)
+		}
+		discoveryIDs := []string{}
+		for _, discovery := range cm.config.Discoveries {
+			discoveryIDs = append(discoveryIDs, discovery.ID)
+		}
+		if err := cm.removeAccounts(accountIDs, discoveryIDs); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(workspace.Dir)
+}
+
+// countWorkspaceAccounts counts the accounts of a workspace without decrypting them
+func countWorkspaceAccounts(workspace *Workspace) (int, error) {
+	data, err := os.ReadFile(workspace.CloudAccountsPath())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var config struct {
+		Accounts []json.RawMessage `json:"accounts"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %v", workspace.CloudAccountsPath(), err)
+	}
+	return len(config.Accounts), nil
+}
+
+// ignoreInGit adds a pattern to the .gitignore of dir unless it is already there
+func ignoreInGit(dir, pattern string) error {
+	gitignore := filepath.Join(dir, ".gitignore")
+	data, err := os.ReadFile(gitignore)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == pattern {
+			return nil
+		}
+	}
+
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		data = append(data, '\n')
+	}
+	data = append(data, []byte(pattern+"\n")...)
+	return os.WriteFile(gitignore, data, 0644)
+}
+
+// workspaceCreateCommand creates a workspace
+func workspaceCreateCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "name")
+	if len(args) != 1 {
+		fmt.Println("Usage: yogaya workspace create <workspace-name> [--switch]")
+		return
+	}
+
+	root, err := resolveWorkspaceRoot(globalOptions.Workspace)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	workspace, err := CreateWorkspace(root, args[0])
+	if err != nil {
+		fmt.Printf("Error creating workspace: %v\n", err)
+		return
+	}
+	fmt.Printf("Created workspace %s in %s\n", workspace.Name, workspace.Dir)
+
+	if workspaceCreateSwitch {
+		if _, err := SwitchWorkspace(root, workspace.Name); err != nil {
+			fmt.Printf("Error switching workspace: %v\n", err)
+			return
+		}
+		fmt.Printf("Switched to workspace %s\n", workspace.Name)
+	}
+}
+
+// workspaceListCommand lists the workspaces
+func workspaceListCommand(cmd *cobra.Command, args []string) {
+	root, err := resolveWorkspaceRoot(globalOptions.Workspace)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	workspaces, err := ListWorkspaces(root)
+	if err != nil {
+		fmt.Printf("Error listing workspaces: %v\n", err)
+		return
+	}
+	tenant, err := readTenantConf(filepath.Join(root, tenantConfFile))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	active := firstNonEmpty(tenant.ActiveWorkspace, defaultWorkspaceName)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTIVE\tNAME\tACCOUNTS\tPATH")
+	for _, workspace := range workspaces {
+		marker := ""
+		if workspace.Name == active {
+			marker = "*"
+		}
+		accounts := "-"
+		if count, err := countWorkspaceAccounts(workspace); err == nil {
+			accounts = fmt.Sprint(count)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", marker, workspace.Name, accounts, workspace.Dir)
+	}
+	w.Flush()
+}
+
+// workspaceSwitchCommand makes a workspace active
+func workspaceSwitchCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "name")
+	if len(args) != 1 {
+		fmt.Println("Usage: yogaya workspace switch <workspace-name>")
+		return
+	}
+
+	root, err := resolveWorkspaceRoot(globalOptions.Workspace)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	workspace, err := SwitchWorkspace(root, args[0])
+	if err != nil {
+		fmt.Printf("Error switching workspace: %v\n", err)
+		return
+	}
+	fmt.Printf("Switched to workspace %s\n", workspace.Name)
+}
+
+// workspaceDeleteCommand deletes a workspace
+func workspaceDeleteCommand(cmd *cobra.Command, args []string) {
+	args = commandArgs(cmd, args, "name")
+	if len(args) != 1 {
+		fmt.Println("Usage: yogaya workspace delete <workspace-name> [--force]")
+		return
+	}
+
+	root, err := resolveWorkspaceRoot(globalOptions.Workspace)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	if err := DeleteWorkspace(root, args[0], workspaceDeleteForce); err != nil {
+		fmt.Printf("Error deleting workspace: %v\n", err)
+		return
+	}
+	fmt.Printf("Deleted workspace %s\n", args[0])
+}
@@ -0,0 +1,197 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// chdir changes the working directory for the rest of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+}
+
+// mkdirs creates directories below root
+func mkdirs(t *testing.T, root string, dirs ...string) {
+	t.Helper()
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResolveWorkspace(t *testing.T) {
+	tests := []struct {
+		name     string
+		dirs     []string
+		explicit string
+		env      string
+		cwd      string
+		home     string
+		// want is relative to the test directory
+		want    string
+		wantErr string
+	}{
+		{
+			name:     "explicit project directory",
+			dirs:     []string{"project/.yogaya", "other/.yogaya"},
+			explicit: "project",
+			env:      "other",
+			want:     "project/.yogaya",
+		},
+		{
+			name:     "explicit .yogaya directory",
+			dirs:     []string{"project/.yogaya"},
+			explicit: "project/.yogaya",
+			want:     "project/.yogaya",
+		},
+		{
+			name: "environment",
+			dirs: []string{"other/.yogaya", "project/.yogaya"},
+			env:  "other",
+			cwd:  "project",
+			want: "other/.yogaya",
+		},
+		{
+			name: "nearest parent",
+			dirs: []string{"project/.yogaya", "project/modules/network", "home/.yogaya"},
+			cwd:  "project/modules/network",
+			home: "home",
+			want: "project/.yogaya",
+		},
+		{
+			name: "home directory",
+			dirs: []string{"project", "home/.yogaya"},
+			cwd:  "project",
+			home: "home",
+			want: "home/.yogaya",
+		},
+		{
+			name:    "no workspace",
+			dirs:    []string{"project", "home"},
+			cwd:     "project",
+			home:    "home",
+			wantErr: "no .yogaya workspace found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			mkdirs(t, root, tt.dirs...)
+
+			explicit := tt.explicit
+			if explicit != "" {
+				explicit = filepath.Join(root, explicit)
+			}
+			env := tt.env
+			if env != "" {
+				env = filepath.Join(root, env)
+			}
+			t.Setenv(workspaceHomeEnv, env)
+			t.Setenv("HOME", filepath.Join(root, tt.home))
+			chdir(t, filepath.Join(root, tt.cwd))
+
+			workspace, err := ResolveWorkspace(explicit)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolveWorkspace() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveWorkspace() error = %v", err)
+			}
+
+			// The temporary directory may be reached through a symlink, as on macOS
+			got, _ := filepath.EvalSymlinks(workspace.Dir)
+			want, _ := filepath.EvalSymlinks(filepath.Join(root, tt.want))
+			if got != want {
+				t.Errorf("ResolveWorkspace() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestConfigPathArg(t *testing.T) {
+	root := t.TempDir()
+	mkdirs(t, root, "project/.yogaya")
+	t.Setenv(workspaceHomeEnv, "")
+	t.Setenv("HOME", root)
+	chdir(t, root)
+
+	previous := globalOptions.Workspace
+	defer func() { globalOptions.Workspace = previous }()
+
+	tests := []struct {
+		name           string
+		workspace      string
+		args           []string
+		want           string
+		wantPositional bool
+		wantErr        string
+	}{
+		{
+			name:           "positional config path",
+			workspace:      filepath.Join(root, "project"),
+			args:           []string{"legacy/cloud_accounts.conf", "aws"},
+			want:           "legacy/cloud_accounts.conf",
+			wantPositional: true,
+		},
+		{
+			name:      "credentials file ending in .conf",
+			workspace: filepath.Join(root, "project"),
+			args:      []string{"creds.conf"},
+			want:      filepath.Join(root, "project", ".yogaya", cloudAccountsFile),
+		},
+		{
+			name:      "no arguments",
+			workspace: filepath.Join(root, "project"),
+			want:      filepath.Join(root, "project", ".yogaya", cloudAccountsFile),
+		},
+		{
+			name:      "other first argument",
+			workspace: filepath.Join(root, "project"),
+			args:      []string{"aws", "credentials"},
+			want:      filepath.Join(root, "project", ".yogaya", cloudAccountsFile),
+		},
+		{
+			name:    "no workspace",
+			args:    []string{"aws"},
+			wantErr: "no .yogaya workspace found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			globalOptions.Workspace = tt.workspace
+
+			got, positional, err := configPathArg(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("configPathArg() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("configPathArg() error = %v", err)
+			}
+			if got != tt.want || positional != tt.wantPositional {
+				t.Errorf("configPathArg(%q) = %s, %v; want %s, %v", tt.args, got, positional, tt.want, tt.wantPositional)
+			}
+		})
+	}
+}